@@ -0,0 +1,308 @@
+package main
+
+import (
+	"cinevault.interimme.net/internal/data"
+	"cinevault.interimme.net/internal/validator"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"strconv"
+)
+
+// createActorHandler handles requests to create a new actor record.
+func (app *application) createActorHandler(w http.ResponseWriter, r *http.Request) {
+	// Define a struct to hold the input data from the request body.
+	var input struct {
+		Name string `json:"name"`
+	}
+
+	// Parse the JSON request body into the input struct.
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	actor := &data.Actor{Name: input.Name}
+
+	// Validate the actor data.
+	v := validator.New()
+	if data.ValidateActor(v, actor); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Insert the actor record into the database.
+	err = app.models.Actors.Insert(r.Context(), actor)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Set the Location header for the new actor resource.
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/actors/%d", actor.ID))
+
+	// Respond with a 201 Created status and the actor data in JSON format.
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"actor": actor}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showActorHandler handles requests to retrieve a specific actor by ID.
+func (app *application) showActorHandler(w http.ResponseWriter, r *http.Request) {
+	// Extract the actor ID from the URL parameters.
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Retrieve the actor from the database.
+	actor, err := app.models.Actors.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Respond with 304 Not Modified if the client already has this version of the actor.
+	if !app.checkPrecondition(w, r, actor.Version, actor.ID) {
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("ETag", etagFor(actor.Version, actor.ID))
+
+	// Respond with a 200 OK status and the actor data in JSON format.
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"actor": actor}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listActorsHandler handles requests to list actors, optionally filtered by name, with sorting and pagination.
+func (app *application) listActorsHandler(w http.ResponseWriter, r *http.Request) {
+	// Define a struct to hold the input data from the URL query string.
+	var input struct {
+		Name string
+		data.Filters
+	}
+
+	// Initialize a new validator instance.
+	v := validator.New()
+	qs := r.URL.Query()
+
+	// Read query parameters for filtering, sorting, and pagination.
+	input.Name = app.readString(qs, "name", "")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "name")
+	input.Filters.SortSafelist = data.ActorSortSafelist
+
+	// Validate the filters.
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Retrieve the matching actors.
+	actors, metadata, err := app.models.Actors.GetAll(r.Context(), input.Name, input.Filters)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidSort):
+			v.AddError("sort", "invalid sort value")
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Add links to the neighbouring pages, based on the URL of this request.
+	metadata.SetPageURLs(r.URL)
+
+	// Respond with a 200 OK status and the list of actors along with metadata in JSON format.
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"actors": actors, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listActorMoviesHandler handles requests to list the movies a specific actor is credited in.
+func (app *application) listActorMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	// Extract the actor ID from the URL parameters.
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Make sure the actor exists, so that an unknown actor is reported rather than an empty list.
+	_, err = app.models.Actors.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Retrieve the actor's movies.
+	movies, err := app.models.Movies.GetAllForActor(r.Context(), id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Respond with a 200 OK status and the list of movies in JSON format.
+	app.presentMovies(r, movies...)
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"movies": movies}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// addCastMemberHandler handles requests to credit an actor in the cast of a specific movie. Crediting an actor
+// who is already in the cast replaces their character name and billing order.
+func (app *application) addCastMemberHandler(w http.ResponseWriter, r *http.Request) {
+	// Extract the movie ID from the URL parameters.
+	movieID, ok := app.readMovieIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	// Make sure the movie exists before changing its cast.
+	_, err := app.models.Movies.Get(r.Context(), movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Define a struct to hold the input data from the request body.
+	var input struct {
+		ActorID       int64  `json:"actor_id"`
+		CharacterName string `json:"character_name"`
+		BillingOrder  int32  `json:"billing_order"`
+	}
+
+	// Parse the JSON request body into the input struct.
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	member := &data.CastMember{
+		ActorID:       input.ActorID,
+		CharacterName: input.CharacterName,
+		BillingOrder:  input.BillingOrder,
+	}
+
+	// Validate the cast member data.
+	v := validator.New()
+	if data.ValidateCastMember(v, member); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Look up the actor, which must exist, to fill in their name.
+	actor, err := app.models.Actors.Get(r.Context(), member.ActorID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("actor_id", "must refer to an existing actor")
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	member.Name = actor.Name
+
+	// Add the actor to the movie's cast.
+	err = app.models.Actors.AddToCast(r.Context(), movieID, member)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Respond with a 200 OK status and the cast member in JSON format.
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"cast_member": member}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// removeCastMemberHandler handles requests to remove an actor from the cast of a specific movie.
+func (app *application) removeCastMemberHandler(w http.ResponseWriter, r *http.Request) {
+	// Extract the movie and actor IDs from the URL parameters.
+	movieID, ok := app.readMovieIDParam(w, r)
+	if !ok {
+		return
+	}
+	actorID, err := strconv.ParseInt(httprouter.ParamsFromContext(r.Context()).ByName("actor_id"), 10, 64)
+	if err != nil || actorID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Remove the actor from the movie's cast.
+	err = app.models.Actors.RemoveFromCast(r.Context(), movieID, actorID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Respond with a 200 OK status and a message confirming the removal.
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "actor successfully removed from cast"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readIncludeCast reads the include query parameter, which may list related data to embed in movie responses,
+// and reports whether the cast was requested. Unknown values add a validation error.
+func (app *application) readIncludeCast(r *http.Request, v *validator.Validator) bool {
+	include := app.readCSV(r.URL.Query(), "include", []string{})
+	for _, value := range include {
+		v.Check(validator.In(value, "cast"), "include", "must only contain cast")
+	}
+	return validator.In("cast", include...)
+}
+
+// loadCast fills in the cast of each of the given movies with a single query.
+func (app *application) loadCast(ctx context.Context, movies ...*data.Movie) error {
+	ids := make([]int64, len(movies))
+	for i, movie := range movies {
+		ids[i] = movie.ID
+	}
+
+	casts, err := app.models.Actors.GetCastForMovies(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, movie := range movies {
+		movie.Cast = casts[movie.ID]
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"cinevault.interimme.net/internal/data"
+	"cinevault.interimme.net/internal/validator"
+	"errors"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"strconv"
+)
+
+// createAPIKeyHandler handles requests from an activated user to create an API key for a machine client, limited to
+// the given scopes. The key itself is only included in this response; afterwards only its identifier is shown.
+func (app *application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	// Define a struct to hold the name and scopes of the key from the request body.
+	var input struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+
+	// Parse the JSON request body into the input struct.
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	key := &data.APIKey{
+		UserID: app.contextGetUser(r).ID,
+		Name:   input.Name,
+		Scopes: input.Scopes,
+	}
+
+	// Retrieve the permission codes defined in the database, to validate the scopes against.
+	known, err := app.models.Permissions.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Validate the name and check that every scope is a known permission.
+	v := validator.New()
+	if data.ValidateAPIKey(v, key, known); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Generate the key and insert it into the database.
+	err = app.models.APIKeys.Insert(r.Context(), key)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Respond with a 201 Created status and the key, including its plaintext, in JSON format.
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"api_key": key}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listAPIKeysHandler handles requests from an authenticated user to list their API keys. The keys themselves are not
+// included.
+func (app *application) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := app.models.APIKeys.GetAllForUser(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Respond with a 200 OK status and the keys in JSON format.
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"api_keys": keys}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revokeAPIKeyHandler handles requests from an authenticated user to revoke one of their API keys, identified by the
+// ID shown by listAPIKeysHandler. Requests made with the key fail from then on.
+func (app *application) revokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	// Extract the key ID from the URL parameters.
+	id, err := strconv.ParseInt(httprouter.ParamsFromContext(r.Context()).ByName("key_id"), 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Delete the key, provided it belongs to the current user.
+	err = app.models.APIKeys.DeleteForUser(r.Context(), id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Respond with a 200 OK status and a message confirming the revocation.
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "API key successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
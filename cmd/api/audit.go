@@ -0,0 +1,77 @@
+package main
+
+import (
+	"cinevault.interimme.net/internal/data"
+	"cinevault.interimme.net/internal/validator"
+	"errors"
+	"net/http"
+)
+
+// recordAudit adds an entry to the audit log for a change made by the user making the request. The change has
+// already been committed by the time this is called, so a failure to record it is logged rather than reported to
+// the client.
+func (app *application) recordAudit(r *http.Request, action, entityType string, entityID int64, oldValue, newValue interface{}) {
+	user := app.contextGetUser(r)
+
+	err := app.models.Audit.Record(r.Context(), user.ID, action, entityType, entityID, oldValue, newValue)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{
+			"action":      action,
+			"entity_type": entityType,
+		})
+	}
+}
+
+// listAuditLogHandler handles requests to read the audit log, optionally filtered to the history of a single
+// entity with the entity and id query parameters.
+func (app *application) listAuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Entity string
+		ID     int
+		data.Filters
+	}
+
+	// Initialize a new validator instance.
+	v := validator.New()
+	qs := r.URL.Query()
+
+	// Read the entity filters, and the query parameters for pagination and sorting. The newest entries come first.
+	input.Entity = app.readString(qs, "entity", "")
+	input.ID = app.readInt(qs, "id", 0, v)
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-id")
+	input.Filters.SortSafelist = data.AuditSortSafelist
+
+	// Validate the entity filters and the pagination options.
+	if input.Entity != "" {
+		v.Check(validator.In(input.Entity, data.AuditEntityMovie), "entity", "must be movie")
+	}
+	v.Check(input.ID >= 0, "id", "must not be negative")
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Retrieve the matching audit log entries.
+	entries, metadata, err := app.models.Audit.GetAll(r.Context(), input.Entity, int64(input.ID), input.Filters)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidSort):
+			v.AddError("sort", "invalid sort value")
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Add links to the neighbouring pages, based on the URL of this request.
+	metadata.SetPageURLs(r.URL)
+
+	// Respond with a 200 OK status and the audit log entries along with metadata in JSON format.
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"audit_log": entries, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// minCompressSize is the smallest response body, in bytes, that is worth compressing. Smaller bodies are sent
+// as they are, since the gzip overhead would outweigh any saving.
+const minCompressSize = 1024
+
+// gzipWriterPool holds gzip writers for reuse between responses, as each one allocates sizeable buffers.
+var gzipWriterPool = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(nil)
+	},
+}
+
+// compress is a middleware that gzips response bodies for clients that send "Accept-Encoding: gzip". The start of
+// each body is buffered so that responses below minCompressSize, and responses that are already encoded or are
+// partial content, can be sent uncompressed.
+func (app *application) compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The response depends on the Accept-Encoding header, so caches must take it into account.
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		if r.Method == http.MethodHead || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		defer gw.Close()
+
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether an Accept-Encoding header value allows a gzip-encoded response.
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		// A quality value of zero means the client explicitly does not want this encoding.
+		q := strings.ReplaceAll(strings.TrimSpace(params), " ", "")
+		return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+	}
+	return false
+}
+
+// gzipResponseWriter wraps an http.ResponseWriter, buffering the status code and the start of the body until it
+// can decide whether to compress the response. The status code is always passed on to the wrapped writer, so
+// middleware further out, such as metrics, still sees it.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status      int          // Status code passed to WriteHeader, sent once the decision to compress is made.
+	wroteHeader bool         // Whether the handler has called WriteHeader.
+	buf         []byte       // Start of the body, held until it reaches minCompressSize.
+	decided     bool         // Whether the status code has been sent and the encoding chosen.
+	gz          *gzip.Writer // Writer that compresses the body, or nil if the response is sent uncompressed.
+}
+
+// WriteHeader records the status code. It is sent to the client when the first part of the body is written.
+func (gw *gzipResponseWriter) WriteHeader(status int) {
+	if gw.wroteHeader || gw.decided {
+		return
+	}
+	gw.status = status
+	gw.wroteHeader = true
+}
+
+// Write buffers the body until there is enough of it to decide whether to compress, then writes it through.
+func (gw *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !gw.decided {
+		gw.buf = append(gw.buf, b...)
+		if len(gw.buf) < minCompressSize {
+			return len(b), nil
+		}
+		if err := gw.decide(true); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	if gw.gz != nil {
+		return gw.gz.Write(b)
+	}
+	return gw.ResponseWriter.Write(b)
+}
+
+// decide sends the status code and chooses whether to compress the body, then writes out anything buffered. The
+// body is compressed only if it is large enough, is not an image (which is compressed already), and the handler
+// has not already encoded it or sent a range.
+func (gw *gzipResponseWriter) decide(large bool) error {
+	gw.decided = true
+
+	h := gw.Header()
+	if large && h.Get("Content-Encoding") == "" && h.Get("Content-Range") == "" && !strings.HasPrefix(h.Get("Content-Type"), "image/") &&
+		gw.status != http.StatusPartialContent && gw.status != http.StatusNoContent && gw.status != http.StatusNotModified {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length") // The length of the compressed body is not known in advance.
+
+		gw.gz = gzipWriterPool.Get().(*gzip.Writer)
+		gw.gz.Reset(gw.ResponseWriter)
+	}
+
+	gw.ResponseWriter.WriteHeader(gw.status)
+
+	if len(gw.buf) == 0 {
+		return nil
+	}
+	var err error
+	if gw.gz != nil {
+		_, err = gw.gz.Write(gw.buf)
+	} else {
+		_, err = gw.ResponseWriter.Write(gw.buf)
+	}
+	gw.buf = nil
+	return err
+}
+
+// Close sends any response that is still buffered, uncompressed since it is below minCompressSize, and finishes
+// the gzip stream otherwise.
+func (gw *gzipResponseWriter) Close() error {
+	if !gw.decided {
+		// Leave the status code unsent if the handler wrote nothing at all, so that the server's default applies.
+		if !gw.wroteHeader && len(gw.buf) == 0 {
+			return nil
+		}
+		return gw.decide(false)
+	}
+
+	if gw.gz == nil {
+		return nil
+	}
+	err := gw.gz.Close()
+	gzipWriterPool.Put(gw.gz)
+	gw.gz = nil
+	return err
+}
+
+// FlushError sends any buffered data to the client, compressing it first if the response is being compressed or
+// is already large enough to be. It is used by http.ResponseController to flush streamed responses.
+func (gw *gzipResponseWriter) FlushError() error {
+	if !gw.decided {
+		if err := gw.decide(len(gw.buf) >= minCompressSize); err != nil {
+			return err
+		}
+	}
+	if gw.gz != nil {
+		if err := gw.gz.Flush(); err != nil {
+			return err
+		}
+	}
+	return http.NewResponseController(gw.ResponseWriter).Flush()
+}
+
+// Unwrap returns the wrapped http.ResponseWriter, for use by http.ResponseController.
+func (gw *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return gw.ResponseWriter
+}
@@ -12,6 +12,14 @@ type contextKey string
 // context.
 const userContextKey = contextKey("user")
 
+// apiKeyContextKey is used as a key for getting and setting the API key a request was authenticated with in the
+// request context.
+const apiKeyContextKey = contextKey("api_key")
+
+// requestIDContextKey is used as a key for getting and setting the request ID in the request
+// context.
+const requestIDContextKey = contextKey("request_id")
+
 // contextSetUser returns a new copy of the request with the provided User struct added to the
 // context.
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
@@ -29,3 +37,31 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+// contextSetAPIKey returns a new copy of the request with the provided API key added to the context.
+func (app *application) contextSetAPIKey(r *http.Request, key *data.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// contextGetAPIKey retrieves the API key the request was authenticated with from the request context. It returns
+// nil if the request was not authenticated with an API key.
+func (app *application) contextGetAPIKey(r *http.Request) *data.APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return key
+}
+
+// contextSetRequestID returns a new copy of the request with the provided request ID added to
+// the context.
+func (app *application) contextSetRequestID(r *http.Request, requestID string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, requestID)
+	return r.WithContext(ctx)
+}
+
+// contextGetRequestID retrieves the request ID from the request context. Unlike contextGetUser,
+// a missing value is not treated as an error, since errors may be logged before the requestID
+// middleware has run; an empty string is returned instead.
+func (app *application) contextGetRequestID(r *http.Request) string {
+	requestID, _ := r.Context().Value(requestIDContextKey).(string)
+	return requestID
+}
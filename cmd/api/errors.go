@@ -1,13 +1,34 @@
 package main
 
 import (
+	"cinevault.interimme.net/internal/validator"
+	"context"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
-// logError logs an error message along with the HTTP request method and URL that caused the error.
+// shutdownRetryAfter is how long clients are asked to wait before retrying a request refused during shutdown.
+const shutdownRetryAfter = 5 * time.Second
+
+// retryAfterSeconds formats a duration as the value of a Retry-After header, a whole number of seconds rounded up
+// so that clients never retry too early, and at least one.
+func retryAfterSeconds(d time.Duration) string {
+	seconds := int(math.Ceil(d.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.Itoa(seconds)
+}
+
+// logError logs an error message along with the HTTP request method, URL, and ID of the request that caused the error.
 func (app *application) logError(r *http.Request, err error) {
 	app.logger.PrintError(err, map[string]string{
+		"request_id":     app.contextGetRequestID(r),
 		"request_method": r.Method,
 		"request_url":    r.URL.String(),
 	})
@@ -17,7 +38,7 @@ func (app *application) logError(r *http.Request, err error) {
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message interface{}) {
 	env := envelope{"error": message}
 
-	err := app.writeJSON(w, status, env, nil)
+	err := app.writeJSON(w, r, status, env, nil)
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(500) // Send a generic 500 Internal Server Error response if unable to write JSON response.
@@ -25,10 +46,67 @@ func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, st
 }
 
 // serverErrorResponse logs an internal server error and sends a 500 Internal Server Error response to the client.
+// If the error happened because the request ran past its deadline, a 503 Service Unavailable response is sent
+// instead, since the error is due to the time taken rather than a fault in the server.
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+		app.requestTimeoutResponse(w, r)
+		return
+	}
+
 	app.logError(r, err)
 	message := "the server encountered a problem and could not process your request"
-	app.errorResponse(w, r, http.StatusInternalServerError, message)
+	env := envelope{"error": message}
+
+	// In development, include the error itself, and the stack of a recovered panic, so that the cause can be seen
+	// without digging through the logs. Other environments only ever send the generic message.
+	if app.config.env == "development" {
+		env["debug"] = serverErrorDetails(err)
+	}
+
+	err = app.writeJSON(w, r, http.StatusInternalServerError, env, nil)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(500) // Send a generic 500 Internal Server Error response if unable to write JSON response.
+	}
+}
+
+// serverErrorDetails describes an error for the debug field of a development server error response: its message,
+// and, for a recovered panic, the stack of the goroutine that panicked, one frame line per element.
+func serverErrorDetails(err error) map[string]interface{} {
+	details := map[string]interface{}{"error": err.Error()}
+
+	var pe *panicError
+	if errors.As(err, &pe) {
+		var stack []string
+		for _, line := range strings.Split(strings.TrimSpace(string(pe.stack)), "\n") {
+			stack = append(stack, strings.TrimSpace(line))
+		}
+		details["stack"] = stack
+	}
+	return details
+}
+
+// requestTimeoutResponse sends a 503 Service Unavailable response when a request takes longer than the configured
+// request timeout.
+func (app *application) requestTimeoutResponse(w http.ResponseWriter, r *http.Request) {
+	app.logger.PrintWarn("request timed out", map[string]string{
+		"request_id":     app.contextGetRequestID(r),
+		"request_method": r.Method,
+		"request_url":    r.URL.String(),
+	})
+	message := "the server took too long to process your request, please try again later"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, message)
+}
+
+// shuttingDownResponse sends a 503 Service Unavailable response for a request that arrives after the server has
+// started shutting down. The connection is closed, and the Retry-After header suggests when another instance is
+// likely to be available.
+func (app *application) shuttingDownResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Connection", "close")
+	w.Header().Set("Retry-After", retryAfterSeconds(shutdownRetryAfter))
+	message := "the server is shutting down, please try again later"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, message)
 }
 
 // notFoundResponse sends a 404 Not Found response to the client when a resource cannot be found.
@@ -43,34 +121,147 @@ func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.
 	app.errorResponse(w, r, http.StatusMethodNotAllowed, message)
 }
 
-// badRequestResponse sends a 400 Bad Request response to the client when there is an issue with the request.
+// badRequestResponse sends a 400 Bad Request response to the client when there is an issue with the request. If the
+// issue is that the body is not JSON, it sends a 415 Unsupported Media Type response instead.
 func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errUnsupportedMediaType) {
+		app.unsupportedMediaTypeResponse(w, r)
+		return
+	}
 	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
 }
 
+// unsupportedMediaTypeResponse sends a 415 Unsupported Media Type response when the request body is not JSON.
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request) {
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, errUnsupportedMediaType.Error())
+}
+
 // failedValidationResponse sends a 422 Unprocessable Entity response when a request fails validation checks.
-func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
+func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, v *validator.Validator) {
+	lang := app.validationLanguage(w, r)
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, app.validationErrors(v, lang))
+}
+
+// failedBatchValidationResponse sends a 422 Unprocessable Entity response when one or more elements of a batch
+// request fail validation. The errors are keyed by the index of the offending element.
+func (app *application) failedBatchValidationResponse(w http.ResponseWriter, r *http.Request, validators map[int]*validator.Validator) {
+	lang := app.validationLanguage(w, r)
+	errors := make(map[int]interface{}, len(validators))
+	for i, v := range validators {
+		errors[i] = app.validationErrors(v, lang)
+	}
 	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
 }
 
+// validationError is the representation of a single field's validation error when error codes are enabled.
+type validationError struct {
+	Message string `json:"message"`        // Human-readable description of the error.
+	Code    string `json:"code,omitempty"` // Machine-readable code for the error, omitted if the check has none.
+}
+
+// validationErrors returns the errors recorded by a validator in the shape sent to clients, with the messages
+// translated into lang. By default this is a flat map of field names to messages; when validation error codes are
+// enabled, each field maps to an object holding both the message and its code.
+func (app *application) validationErrors(v *validator.Validator, lang string) interface{} {
+	messages := v.Localized(lang)
+	if !app.config.validation.codes {
+		return messages
+	}
+
+	errors := make(map[string]validationError, len(messages))
+	for key, message := range messages {
+		errors[key] = validationError{Message: message, Code: v.Codes[key]}
+	}
+	return errors
+}
+
+// validationLanguage picks the language to write validation messages in from the request's Accept-Language
+// header, and sets the Content-Language and Vary headers of the response to match. Languages are tried in order
+// of their quality values, with region subtags such as the "MX" of "es-MX" ignored; the default language is used
+// if none of them are supported.
+func (app *application) validationLanguage(w http.ResponseWriter, r *http.Request) string {
+	w.Header().Add("Vary", "Accept-Language")
+
+	lang := validator.DefaultLanguage
+	bestQuality := 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		base, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			quality = q
+		}
+
+		// Earlier languages win ties, so only a strictly higher quality replaces the current choice.
+		if quality > bestQuality && validator.Supported(base) {
+			lang, bestQuality = base, quality
+		}
+	}
+
+	w.Header().Set("Content-Language", lang)
+	return lang
+}
+
 // editConflictResponse sends a 409 Conflict response when an edit conflict occurs during an update operation.
 func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
 	message := "unable to update the record due to an edit conflict, please try again"
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
 
-// rateLimitExceededResponse sends a 429 Too Many Requests response when a client exceeds the rate limit.
-func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
+// preconditionFailedResponse sends a 412 Precondition Failed response when an If-Match header does not match the
+// current version of the resource.
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the resource has been modified since you last retrieved it, please fetch it again"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
+// rateLimitExceededResponse sends a 429 Too Many Requests response when a client exceeds the rate limit. The
+// Retry-After header tells the client how long to wait before trying again.
+func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", retryAfterSeconds(retryAfter))
 	message := "rate limit exceeded"
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
 }
 
+// emailThrottledResponse sends a 429 Too Many Requests response when a token email was sent to the requested
+// address too recently for another to be sent. The Retry-After header tells the client when one can be.
+func (app *application) emailThrottledResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", retryAfterSeconds(retryAfter))
+	message := "an email was sent to this address recently, please wait before requesting another"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
 // invalidCredentialsResponse sends a 401 Unauthorized response when authentication credentials are invalid.
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
 	message := "invalid authentication credentials"
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+// totpRequiredResponse sends a 401 Unauthorized response when a user with two-factor authentication enabled logs
+// in without a TOTP code, so that the client knows to prompt for one.
+func (app *application) totpRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "a two-factor authentication code is required for this account"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// twoFactorUnavailableResponse sends a 503 Service Unavailable response when two-factor authentication is used
+// but the server has not been configured with a key for encrypting TOTP secrets.
+func (app *application) twoFactorUnavailableResponse(w http.ResponseWriter, r *http.Request) {
+	message := "two-factor authentication is not available on this server"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, message)
+}
+
+// ipNotAllowedResponse sends a 403 Forbidden response when the client's IP address is refused by the IP filter.
+func (app *application) ipNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your IP address is not allowed to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
 // invalidAuthenticationTokenResponse sends a 401 Unauthorized response when an authentication token is missing or invalid.
 func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("WWW-Authenticate", "Bearer")
@@ -78,6 +269,13 @@ func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+// invalidAPIKeyResponse sends a 401 Unauthorized response when an API key is malformed or has been revoked.
+func (app *application) invalidAPIKeyResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "ApiKey")
+	message := "invalid or revoked API key"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
 // authenticationRequiredResponse sends a 401 Unauthorized response when a resource requires authentication.
 func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
 	message := "you must be authenticated to access this resource"
@@ -95,3 +293,17 @@ func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Requ
 	message := "your user account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+// apiKeyScopeResponse sends a 403 Forbidden response when a request made with an API key needs a permission that
+// is not among the key's scopes.
+func (app *application) apiKeyScopeResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your API key doesn't have the necessary scope to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// apiKeyNotAllowedResponse sends a 403 Forbidden response when an API key is used for a resource that only a
+// logged-in user may access, such as managing the account's API keys.
+func (app *application) apiKeyNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this resource can't be accessed with an API key"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
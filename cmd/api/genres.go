@@ -0,0 +1,115 @@
+package main
+
+import (
+	"cinevault.interimme.net/internal/data"
+	"cinevault.interimme.net/internal/validator"
+	"errors"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+)
+
+// listGenresHandler handles requests to list the canonical genres along with their movie counts.
+func (app *application) listGenresHandler(w http.ResponseWriter, r *http.Request) {
+	// Retrieve the genres from the database.
+	genres, err := app.models.Genres.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Respond with a 200 OK status and the list of genres in JSON format.
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"genres": genres}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createGenreHandler handles requests to add a genre to the canonical list.
+func (app *application) createGenreHandler(w http.ResponseWriter, r *http.Request) {
+	// Define a struct to hold the input data from the request body.
+	var input struct {
+		Name string `json:"name"`
+	}
+
+	// Parse the JSON request body into the input struct.
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Initialize a new validator instance.
+	v := validator.New()
+
+	// Validate the genre name.
+	if data.ValidateGenreName(v, input.Name); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Insert the genre into the database.
+	err = app.models.Genres.Insert(r.Context(), input.Name)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateGenre):
+			v.AddError("name", "a genre with this name already exists")
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Respond with a 201 Created status and the new genre in JSON format.
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"genre": data.Genre{Name: input.Name}}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteGenreHandler handles requests to remove a genre from the canonical list.
+func (app *application) deleteGenreHandler(w http.ResponseWriter, r *http.Request) {
+	// Extract the genre name from the URL parameters.
+	name := httprouter.ParamsFromContext(r.Context()).ByName("genre")
+
+	// Delete the genre from the database.
+	err := app.models.Genres.Delete(r.Context(), name)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Respond with a 200 OK status and a message indicating successful deletion.
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "genre successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listGenreMoviesHandler handles requests to list the movies in the genre named by the URL, with the same
+// filtering, sorting, and pagination as listMoviesHandler. When strict genres are enabled, a genre that is not in
+// the canonical list is a 404 rather than an empty page.
+func (app *application) listGenreMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	// Extract the genre name from the URL parameters.
+	genre := httprouter.ParamsFromContext(r.Context()).ByName("genre")
+
+	// Check the genre against the canonical list, if movies are limited to it.
+	if app.config.genres.strict {
+		canonical, err := app.models.Genres.GetAllNames(r.Context())
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !validator.In(genre, canonical...) {
+			app.notFoundResponse(w, r)
+			return
+		}
+	}
+
+	app.listMovies(w, r, []string{genre})
+}
@@ -1,22 +1,160 @@
 package main
 
 import (
+	"cinevault.interimme.net/internal/validator"
+	"context"
 	"net/http"
+	"sync"
+	"time"
 )
 
+// probePaths lists the paths of the liveness and readiness probes, which bypass the IP filter, the rate limiter, and
+// authentication so that an orchestrator's probes always reach their handlers.
+var probePaths = []string{"/v1/livez", "/v1/readyz", "/v1/healthcheck"}
+
+// healthChecks lists the optional checks that readiness probes can ask for with the checks query parameter, on top
+// of the database check they always make.
+var healthChecks = []string{"smtp"}
+
+// smtpCheckTTL is how long the result of checking the SMTP server is reused for. Probes bypass the rate limiter, so
+// without it every probe asking for the check would open a connection to the server.
+const smtpCheckTTL = 30 * time.Second
+
+// smtpCheckTimeout is how long checking the SMTP server may take before it is reported as unavailable.
+const smtpCheckTimeout = 2 * time.Second
+
+// smtpCheck holds the result of the most recent check of the SMTP server, so that it can be reused until it
+// expires.
+type smtpCheck struct {
+	mu        sync.Mutex // Guards the fields below, and is held during a check so that only one runs at a time.
+	checkedAt time.Time  // When the server was last checked, or zero if it has not been checked yet.
+	err       error      // The error from the last check, or nil if the server was reachable.
+}
+
+// livezHandler handles liveness probes. It always responds with a 200 OK status, since being able to respond at all
+// shows that the process is alive; whether it can serve traffic is left to the readiness probe.
+func (app *application) livezHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, r, http.StatusOK, envelope{"status": "alive"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// healthcheckHandler handles readiness probes, at both /v1/readyz and /v1/healthcheck. It responds with a 503
+// Service Unavailable status if the database can't be reached or every connection in the pool is in use, so that
+// orchestrators stop routing traffic to this instance until it recovers. The read replica, if one is configured, must
+// be reachable too. With checks=smtp, it also checks that the
+// SMTP server can be reached, responding with a 503 status if not.
 func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
+	// Read the optional checks to make, rejecting any that are unknown.
+	checks := app.readCSV(r.URL.Query(), "checks", []string{})
+	for _, check := range checks {
+		if !validator.In(check, healthChecks...) {
+			v := validator.New()
+			v.AddError("checks", "invalid checks value")
+			app.failedValidationResponse(w, r, v)
+			return
+		}
+	}
+
+	// Ping the database with a short timeout so that a readiness probe never hangs on an unreachable database.
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cancel()
+
+	status := http.StatusOK
+	availability := "available"
+	database := "available"
+
+	err := app.db.PingContext(ctx)
+
+	// Collect the connection pool statistics, after the ping so that its connection has been returned to the pool.
+	stats := app.db.Stats()
+
+	switch {
+	case err != nil:
+		app.logError(r, err)
+		// Report 503 Service Unavailable so that orchestrators can stop routing traffic to this instance.
+		status = http.StatusServiceUnavailable
+		availability = "unavailable"
+		database = "unavailable"
+	case stats.MaxOpenConnections > 0 && stats.InUse >= stats.MaxOpenConnections:
+		// The database is reachable, but new queries would have to wait for a connection to be freed.
+		status = http.StatusServiceUnavailable
+		availability = "unavailable"
+		database = "exhausted"
+	}
+
 	// Declare an envelope map containing the data for the response. Note,
 	// environment and version data are now nested under system_info key.
 	env := envelope{
-		"status": "available",
+		"status": availability,
 		"system_info": map[string]string{
 			"environment": app.config.env,
 			"version":     version,
 		},
+		"database": database,
+		"database_stats": map[string]interface{}{
+			"max_open_connections": stats.MaxOpenConnections,
+			"open_connections":     stats.OpenConnections,
+			"in_use":               stats.InUse,
+			"idle":                 stats.Idle,
+			"wait_count":           stats.WaitCount,
+			"wait_duration":        stats.WaitDuration.String(),
+			"max_idle_closed":      stats.MaxIdleClosed,
+			"max_idle_time_closed": stats.MaxIdleTimeClosed,
+			"max_lifetime_closed":  stats.MaxLifetimeClosed,
+		},
+	}
+
+	// Ping the read replica as well, if there is one, since reads would fail without it.
+	if app.replica != nil {
+		env["replica"] = "available"
+		if err := app.replica.PingContext(ctx); err != nil {
+			app.logError(r, err)
+			status = http.StatusServiceUnavailable
+			env["status"] = "unavailable"
+			env["replica"] = "unavailable"
+		}
+	}
+
+	// Check the SMTP server if asked to, reporting the instance as unavailable if it can't be reached.
+	if validator.In("smtp", checks...) {
+		smtp := app.checkSMTP()
+		if smtp == "unavailable" {
+			status = http.StatusServiceUnavailable
+			env["status"] = "unavailable"
+		}
+		env["smtp"] = smtp
 	}
 
-	err := app.writeJSON(w, http.StatusOK, env, nil)
+	err = app.writeJSON(w, r, status, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// checkSMTP reports whether the SMTP server can be reached: "available" or "unavailable", or "log-only" if the
+// mailer has no server and writes emails to the log instead. The result of a check is reused for smtpCheckTTL,
+// and the error from a failed check is logged.
+func (app *application) checkSMTP() string {
+	if app.mailer.LogOnly() {
+		return "log-only"
+	}
+
+	app.smtpCheck.mu.Lock()
+	defer app.smtpCheck.mu.Unlock()
+
+	// Check the server again if it has not been checked yet or the last result has expired.
+	if time.Since(app.smtpCheck.checkedAt) >= smtpCheckTTL {
+		app.smtpCheck.err = app.mailer.Ping(smtpCheckTimeout)
+		app.smtpCheck.checkedAt = time.Now()
+		if app.smtpCheck.err != nil {
+			app.logger.PrintError(app.smtpCheck.err, map[string]string{"check": "smtp"})
+		}
+	}
+
+	if app.smtpCheck.err != nil {
+		return "unavailable"
+	}
+	return "available"
+}
@@ -1,16 +1,24 @@
 package main
 
 import (
+	"bytes"
+	"cinevault.interimme.net/internal/data"
 	"cinevault.interimme.net/internal/validator"
+	"compress/flate"
+	"compress/gzip"
+	"crypto/rand"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/julienschmidt/httprouter"
 	"io"
+	"mime"
 	"net/http"
 	"net/url"
+	"reflect"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // readIDParam extracts the "id" parameter from the URL and converts it to an int64.
@@ -24,13 +32,104 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+// readMovieIDParam extracts the movie identified by the "id" URL parameter and returns its ID. When movie UUIDs are
+// enabled, the parameter is the movie's UUID, which is looked up to find the ID; otherwise it is the ID itself. If
+// the parameter identifies no movie, or the lookup fails, it sends the error response itself and reports false.
+func (app *application) readMovieIDParam(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	if !app.config.movieUUIDs {
+		id, err := app.readIDParam(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return 0, false
+		}
+		return id, true
+	}
+
+	id, err := app.models.Movies.GetIDForUUID(r.Context(), httprouter.ParamsFromContext(r.Context()).ByName("id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return 0, false
+	}
+	return id, true
+}
+
+// runtimeFormats are the ways movie runtimes can be shown in JSON, chosen with the runtime_format query parameter
+// or the -runtime-format flag: a string such as "102 mins", or a plain number of minutes.
+var runtimeFormats = []string{"string", "int"}
+
+// presentMovies prepares movies to be sent to clients, making them identify themselves by their UUIDs rather than
+// their sequential IDs when movie UUIDs are enabled, and show their runtimes in the format asked for by the request's
+// runtime_format query parameter, or the configured format if it has none. The parameter is checked by the
+// checkRuntimeFormat middleware.
+func (app *application) presentMovies(r *http.Request, movies ...*data.Movie) {
+	runtimeFormat := app.readString(r.URL.Query(), "runtime_format", app.config.runtimeFormat)
+	for _, movie := range movies {
+		if app.config.movieUUIDs {
+			movie.UseUUID()
+		}
+		if runtimeFormat == "int" {
+			movie.UseRuntimeMinutes()
+		}
+	}
+}
+
+// bearerToken extracts the token from an Authorization header of the form "Bearer <token>". It reports false if
+// the header is missing or in any other form.
+func bearerToken(r *http.Request) (string, bool) {
+	return authorizationCredentials(r, "Bearer")
+}
+
+// apiKeyCredentials extracts the key from an Authorization header of the form "ApiKey <key>". It reports false if
+// the header is missing or in any other form.
+func apiKeyCredentials(r *http.Request) (string, bool) {
+	return authorizationCredentials(r, "ApiKey")
+}
+
+// authorizationCredentials extracts the credentials from an Authorization header of the form
+// "<scheme> <credentials>", reporting false if the header is missing or uses another scheme.
+func authorizationCredentials(r *http.Request, scheme string) (string, bool) {
+	headerParts := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(headerParts) != 2 || headerParts[0] != scheme {
+		return "", false
+	}
+	return headerParts[1], true
+}
+
+// isJWT reports whether a token has the shape of a JWT, three dot-separated segments, as opposed to the opaque
+// tokens generated by the data package.
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
 // envelope is a type alias for a map that holds JSON response data.
 type envelope map[string]interface{}
 
-// writeJSON writes a JSON response to the client with a specified status code and optional headers.
-func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
-	// Marshal the data into a pretty-printed JSON format.
-	js, err := json.MarshalIndent(data, "", "\t")
+// writeJSON writes a JSON response to the client with a specified status code and optional headers. An envelope
+// with a single key is unwrapped, so that its value is sent on its own, if the client turned enveloping off for the
+// request; see useEnvelope.
+func (app *application) writeJSON(w http.ResponseWriter, r *http.Request, status int, data envelope, headers http.Header) error {
+	// Error responses are always enveloped, so that clients can tell them apart from the resources they asked for.
+	unwrappable := len(data) == 1 && status < http.StatusBadRequest
+	var body interface{} = data
+	if unwrappable && !app.useEnvelope(r) {
+		for _, value := range data {
+			body = value
+		}
+	}
+
+	// Marshal the data into JSON, pretty-printed if configured to be.
+	var js []byte
+	var err error
+	if app.config.jsonIndent {
+		js, err = json.MarshalIndent(body, "", "\t")
+	} else {
+		js, err = json.Marshal(body)
+	}
 	if err != nil {
 		return err
 	}
@@ -40,6 +139,9 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data envelo
 	for key, value := range headers {
 		w.Header()[key] = value
 	}
+	if unwrappable {
+		w.Header().Add("Vary", "X-Envelope") // Caches must not serve an enveloped response to a client that turned it off.
+	}
 
 	// Set the Content-Type header to indicate JSON response.
 	w.Header().Set("Content-Type", "application/json")
@@ -48,18 +150,104 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data envelo
 	return nil
 }
 
-// readJSON reads and parses JSON data from the request body into the destination struct.
-// Validates the JSON format and checks for various errors, such as syntax errors and unexpected fields.
+// useEnvelope reports whether the response to a request should be wrapped in an envelope. Clients can choose with an
+// X-Envelope header of true or false, such as generated SDKs that expect the bare resource; otherwise the envelope
+// flag decides.
+func (app *application) useEnvelope(r *http.Request) bool {
+	if enveloped, err := strconv.ParseBool(r.Header.Get("X-Envelope")); err == nil {
+		return enveloped
+	}
+	return app.config.envelope
+}
+
+// Request body size limits for handlers that need a different limit from the configured default.
+const (
+	maxAuthBodyBytes  = 4 << 10 // Limit for the token and credential endpoints, whose bodies are only a few fields.
+	maxBatchBodyBytes = 8 << 20 // Limit for batch movie creation, which accepts up to maxBatchSize movies.
+)
+
+// errUnsupportedMediaType is returned by readJSON when the request body is not declared to be JSON.
+// badRequestResponse reports it as a 415 Unsupported Media Type rather than a 400.
+var errUnsupportedMediaType = errors.New("Content-Type header must be application/json")
+
+// checkJSONContentType checks that the request's Content-Type is application/json, or another JSON type such as
+// application/merge-patch+json, with an optional UTF-8 charset parameter. A request with no Content-Type and no
+// body passes, so that it gets the clearer "body must not be empty" error instead.
+func checkJSONContentType(r *http.Request) error {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" && r.ContentLength == 0 {
+		return nil
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return errUnsupportedMediaType
+	}
+	if mediaType != "application/json" && !(strings.HasPrefix(mediaType, "application/") && strings.HasSuffix(mediaType, "+json")) {
+		return errUnsupportedMediaType
+	}
+	if charset, ok := params["charset"]; ok && !strings.EqualFold(charset, "utf-8") {
+		return errUnsupportedMediaType
+	}
+	return nil
+}
+
+// readJSON reads and parses JSON data from the request body into the destination struct, limiting the body to the
+// configured maximum size. See readJSONLimited for details.
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
+	return app.readJSONLimited(w, r, dst, app.config.maxBodyBytes)
+}
+
+// readJSONLimited reads and parses JSON data from the request body into the destination struct, rejecting bodies
+// larger than maxBytes. Validates the JSON format and checks for various errors, such as syntax errors and
+// unexpected fields. Bodies sent with "Content-Encoding: gzip" are decompressed first, and the size limit applies
+// to the decompressed data as well as to the compressed body. Bodies that are not declared to be JSON are
+// rejected with errUnsupportedMediaType before anything is read.
+func (app *application) readJSONLimited(w http.ResponseWriter, r *http.Request, dst interface{}, maxBytes int64) error {
+	return app.decodeJSONBody(w, r, dst, maxBytes, true)
+}
+
+// readJSONLenient reads and parses JSON data from the request body into the destination struct like
+// readJSONLimited, except that fields the destination doesn't have are ignored rather than rejected. It is for the
+// few endpoints whose clients are known to send extra fields we don't use, such as OAuth-style client libraries
+// posting a grant_type alongside a refresh token; every other endpoint should stay strict, so that typos in field
+// names are caught.
+func (app *application) readJSONLenient(w http.ResponseWriter, r *http.Request, dst interface{}, maxBytes int64) error {
+	return app.decodeJSONBody(w, r, dst, maxBytes, false)
+}
+
+// decodeJSONBody does the work of readJSONLimited and readJSONLenient, rejecting unknown fields if strict is set.
+func (app *application) decodeJSONBody(w http.ResponseWriter, r *http.Request, dst interface{}, maxBytes int64, strict bool) error {
+	// Check that the body is declared to be JSON, so that a form or XML body gets a clear error.
+	err := checkJSONContentType(r)
+	if err != nil {
+		return err
+	}
+
 	// Limit the size of the request body to prevent large payloads from causing issues.
-	maxBytes := 1_048_576
-	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+
+	// If the body is gzip-compressed, decompress it, limiting the decompressed size too so that a small
+	// compressed body can't expand into an arbitrarily large one.
+	if strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			if err.Error() == "http: request body too large" {
+				return fmt.Errorf("body must not be larger than %d bytes", maxBytes)
+			}
+			return errors.New("body contains invalid gzip data")
+		}
+		defer gz.Close()
+		r.Body = http.MaxBytesReader(w, gz, maxBytes)
+	}
 
 	dec := json.NewDecoder(r.Body)
-	dec.DisallowUnknownFields() // Disallow unknown fields to enforce strict schema validation.
+	if strict {
+		dec.DisallowUnknownFields() // Disallow unknown fields to enforce strict schema validation.
+	}
 
 	// Decode JSON data into the destination struct.
-	err := dec.Decode(dst)
+	err = dec.Decode(dst)
 
 	// Handle various JSON parsing errors.
 	if err != nil {
@@ -68,6 +256,9 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 		var invalidUnmarshalError *json.InvalidUnmarshalError
 
 		switch {
+		case isGzipError(err):
+			// Corrupt gzip stream.
+			return errors.New("body contains invalid gzip data")
 		case errors.As(err, &syntaxError):
 			// JSON syntax error.
 			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
@@ -101,12 +292,162 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 
 	// Ensure the JSON data only contains a single value.
 	err = dec.Decode(&struct{}{})
+	if isGzipError(err) {
+		// The gzip checksum is only verified at the end of the stream, so corruption may surface here.
+		return errors.New("body contains invalid gzip data")
+	}
 	if err != io.EOF {
 		return errors.New("body must only contain a single JSON value")
 	}
 	return nil
 }
 
+// readJSONFields reads a JSON object from the request body into the destination struct like readJSON, but instead
+// of stopping at the first field with the wrong type or format, it checks every field and adds an error to v for
+// each problem it finds, including unknown fields. The returned error is only for problems with the body as a
+// whole, such as badly-formed JSON. If v is not valid afterwards, dst may be partly filled in. See decodeJSONFields
+// for how each field is decoded.
+func (app *application) readJSONFields(w http.ResponseWriter, r *http.Request, dst interface{}, v *validator.Validator) error {
+	var raw map[string]json.RawMessage
+	err := app.readJSON(w, r, &raw)
+	if err != nil {
+		return err
+	}
+	if raw == nil {
+		return errors.New("body must be a JSON object")
+	}
+
+	decodeJSONFields(raw, dst, v)
+	return nil
+}
+
+// decodeJSONFields decodes each of the raw values of a JSON object into the field of the destination struct with
+// the matching JSON name, adding an error to v for each value with the wrong type or format and for each unknown
+// field, rather than stopping at the first problem.
+//
+// Following JSON merge patch (RFC 7386), a field set to null is cleared rather than ignored: a pointer field is set
+// to point to the zero value of its type and a slice field to an empty slice, so that both can be told apart from
+// an omitted field, which stays nil. Other fields are set to their zero value.
+func decodeJSONFields(raw map[string]json.RawMessage, dst interface{}, v *validator.Validator) {
+	// Index the fields of the struct by their JSON names.
+	sv := reflect.ValueOf(dst).Elem()
+	fields := make(map[string]reflect.Value, sv.NumField())
+	for i := 0; i < sv.NumField(); i++ {
+		name, _, _ := strings.Cut(sv.Type().Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = sv.Field(i)
+		}
+	}
+
+	for key, value := range raw {
+		field, ok := fields[key]
+		if !ok {
+			v.AddError(key, "is not a known field")
+			continue
+		}
+
+		if string(bytes.TrimSpace(value)) == "null" {
+			switch field.Kind() {
+			case reflect.Pointer:
+				field.Set(reflect.New(field.Type().Elem()))
+			case reflect.Slice:
+				field.Set(reflect.MakeSlice(field.Type(), 0, 0))
+			default:
+				field.SetZero()
+			}
+			continue
+		}
+
+		err := json.Unmarshal(value, field.Addr().Interface())
+		if err != nil {
+			var unmarshalTypeError *json.UnmarshalTypeError
+			switch {
+			case errors.As(err, &unmarshalTypeError):
+				v.AddCodedError(key, validator.CodeInvalidFormat, "must be "+jsonTypeName(field.Type()))
+			default:
+				v.AddCodedError(key, validator.CodeInvalidFormat, err.Error())
+			}
+		}
+	}
+}
+
+// jsonTypeName describes the JSON type that a value of type t is decoded from, such as "an integer" or "an array
+// of strings", for use in error messages.
+func jsonTypeName(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array of " + strings.TrimPrefix(strings.TrimPrefix(jsonTypeName(t.Elem()), "a "), "an ") + "s"
+	default:
+		return "an object"
+	}
+}
+
+// isGzipError reports whether err was caused by a corrupt gzip stream.
+func isGzipError(err error) bool {
+	var corruptInputError flate.CorruptInputError
+	return errors.Is(err, gzip.ErrHeader) || errors.Is(err, gzip.ErrChecksum) || errors.As(err, &corruptInputError)
+}
+
+// etagFor returns the entity tag of a record with the given version number and ID. Since the version is incremented
+// on every update, the tag changes whenever the record does. The tag is weak, as a record's representation also
+// depends on the fields, runtime format, and envelope asked for, so it only promises that two responses describe the
+// same version of the record, not that they are byte for byte the same.
+func etagFor(version int32, id int64) string {
+	return fmt.Sprintf(`W/"%d-%d"`, id, version)
+}
+
+// checkPrecondition evaluates the conditional request headers against the current version of the record the
+// request is for, sending a response and returning false if the request should go no further. An If-Match header
+// that doesn't match the record's tag gets a 412 Precondition Failed response, so that clients can make sure they
+// are changing the version they last saw. An If-None-Match header that does match gets a 304 Not Modified response
+// for GET and HEAD requests, and a 412 Precondition Failed response for requests that change the record.
+func (app *application) checkPrecondition(w http.ResponseWriter, r *http.Request, version int32, id int64) bool {
+	etag := etagFor(version, id)
+
+	if match := r.Header.Get("If-Match"); match != "" && !etagMatches(match, etag) {
+		app.preconditionFailedResponse(w, r)
+		return false
+	}
+
+	if match := r.Header.Get("If-None-Match"); match != "" && etagMatches(match, etag) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			w.Header().Set("ETag", etag)
+			w.WriteHeader(http.StatusNotModified)
+		} else {
+			app.preconditionFailedResponse(w, r)
+		}
+		return false
+	}
+	return true
+}
+
+// etagMatches reports whether an If-None-Match or If-Match header value matches the given entity tag. The header
+// may contain a comma-separated list of tags or "*". Tags are compared weakly, ignoring any W/ prefix, so a tag sent
+// back in its strong form matches too. This is also how If-Match is compared: the tags from etagFor are all weak,
+// and comparing them strongly, as If-Match normally would, would never find a match.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
 // readString reads a string query parameter from the URL query string. If the parameter is missing, returns a default value.
 func (app *application) readString(qs url.Values, key string, defaultValue string) string {
 	s := qs.Get(key)
@@ -146,20 +487,136 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
-// background runs a function in a separate goroutine and recovers from any panic that occurs in the goroutine.
-// This is useful for running background tasks without crashing the server if a panic occurs.
+// readBool reads a boolean query parameter from the URL query string. It returns nil if the parameter is missing,
+// and nil with a validation error if it is not a valid boolean such as "true" or "false".
+func (app *application) readBool(qs url.Values, key string, v *validator.Validator) *bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return nil
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return nil
+	}
+
+	return &b
+}
+
+// readFields reads the fields query parameter, a comma-separated list of the fields to include in a response,
+// and checks each of them against the safelist. It returns nil if the parameter is missing, meaning that every
+// field should be included, and an error naming the first field that isn't in the safelist.
+func (app *application) readFields(qs url.Values, safelist []string) ([]string, error) {
+	fields := app.readCSV(qs, "fields", nil)
+	for _, field := range fields {
+		if !validator.In(field, safelist...) {
+			return nil, fmt.Errorf("unknown field %q in fields parameter", field)
+		}
+	}
+	return fields, nil
+}
+
+// selectFields returns the JSON representation of src reduced to the given fields, for use as a value in an
+// envelope. It works by marshaling src to a map of its JSON keys and deleting the keys that weren't requested,
+// so the remaining values are encoded exactly as they would be otherwise. The id field is always kept. If fields
+// is empty, src is returned unchanged.
+func selectFields(src interface{}, fields []string) (interface{}, error) {
+	if len(fields) == 0 {
+		return src, nil
+	}
+
+	js, err := json.Marshal(src)
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]json.RawMessage
+	err = json.Unmarshal(js, &m)
+	if err != nil {
+		return nil, err
+	}
+
+	for key := range m {
+		if key != "id" && !validator.In(key, fields...) {
+			delete(m, key)
+		}
+	}
+	return m, nil
+}
+
+// background queues a function to be run by one of the background workers started by startWorkers. The wait
+// group counter is incremented before the function is queued, so that serve() waits for queued as well as running
+// functions during a graceful shutdown. If the queue is full, background blocks until a worker frees up a slot,
+// applying backpressure to the caller instead of spawning an unbounded number of goroutines.
 func (app *application) background(fn func()) {
 	app.wg.Add(1) // Increment the wait group counter.
+	app.pending.Add(1)
+	app.jobs <- fn
+}
 
-	go func() {
-		defer app.wg.Done() // Decrement the wait group counter when the goroutine completes.
-
-		defer func() {
-			if err := recover(); err != nil {
-				app.logger.PrintError(fmt.Errorf("%s", err), nil) // Log any panic that occurs.
+// startWorkers starts n background workers, which run the functions queued by background for the lifetime of the
+// application. The queue is never closed, since a request that outlived the shutdown timeout could still call
+// background.
+func (app *application) startWorkers(n int) {
+	for i := 0; i < n; i++ {
+		go func() {
+			for fn := range app.jobs {
+				app.runJob(fn)
 			}
 		}()
+	}
+}
 
-		fn() // Run the background function.
+// runJob runs a single background function and recovers from any panic that occurs in it, so that a failing task
+// is logged without killing the worker that ran it.
+func (app *application) runJob(fn func()) {
+	defer app.wg.Done() // Decrement the wait group counter when the function completes.
+	defer app.pending.Add(-1)
+
+	defer func() {
+		if err := recover(); err != nil {
+			app.logger.PrintError(fmt.Errorf("%s", err), nil) // Log any panic that occurs.
+		}
 	}()
+
+	fn() // Run the background function.
+}
+
+// formatExpiry formats a token lifetime for use in an email, such as "3 days" or "45 minutes". Lifetimes that
+// aren't a whole number of minutes fall back to the Go duration format.
+func formatExpiry(d time.Duration) string {
+	units := []struct {
+		size time.Duration // Length of the unit
+		name string        // Singular name of the unit
+	}{
+		{24 * time.Hour, "day"},
+		{time.Hour, "hour"},
+		{time.Minute, "minute"},
+	}
+	for _, unit := range units {
+		if d%unit.size == 0 {
+			n := int64(d / unit.size)
+			if n == 1 {
+				return "1 " + unit.name
+			}
+			return fmt.Sprintf("%d %ss", n, unit.name)
+		}
+	}
+	return d.String()
+}
+
+// newUUID generates a random (version 4) UUID in its canonical string form.
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	b[6] = (b[6] & 0x0f) | 0x40 // Set the version to 4.
+	b[8] = (b[8] & 0x3f) | 0x80 // Set the variant to RFC 4122.
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
 }
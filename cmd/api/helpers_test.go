@@ -0,0 +1,162 @@
+package main
+
+import (
+	"cinevault.interimme.net/internal/data"
+	"cinevault.interimme.net/internal/validator"
+	"encoding/json"
+	"errors"
+	"github.com/pascaldekloe/jwt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// movieMergePatch has the fields of the body of a request to update a movie, as in updateMovieHandler.
+type movieMergePatch struct {
+	Title       *string       `json:"title"`
+	Year        *int32        `json:"year"`
+	ReleaseDate *data.Date    `json:"release_date"`
+	Runtime     *data.Runtime `json:"runtime"`
+	Genres      []string      `json:"genres"`
+	Tags        []string      `json:"tags"`
+}
+
+// ptr returns a pointer to a copy of value.
+func ptr[T any](value T) *T {
+	return &value
+}
+
+func TestDecodeJSONFields(t *testing.T) {
+	releaseDate := data.NewDate(2010, 7, 16)
+
+	tests := []struct {
+		name string
+		body string
+		want movieMergePatch
+	}{
+		{name: "title omitted", body: `{}`, want: movieMergePatch{}},
+		{name: "title null", body: `{"title": null}`, want: movieMergePatch{Title: ptr("")}},
+		{name: "title set", body: `{"title": "Inception"}`, want: movieMergePatch{Title: ptr("Inception")}},
+		{name: "year omitted", body: `{}`, want: movieMergePatch{}},
+		{name: "year null", body: `{"year": null}`, want: movieMergePatch{Year: ptr(int32(0))}},
+		{name: "year set", body: `{"year": 2010}`, want: movieMergePatch{Year: ptr(int32(2010))}},
+		{name: "release_date omitted", body: `{}`, want: movieMergePatch{}},
+		{name: "release_date null", body: `{"release_date": null}`, want: movieMergePatch{ReleaseDate: &data.Date{}}},
+		{name: "release_date set", body: `{"release_date": "2010-07-16"}`, want: movieMergePatch{ReleaseDate: &releaseDate}},
+		{name: "runtime omitted", body: `{}`, want: movieMergePatch{}},
+		{name: "runtime null", body: `{"runtime": null}`, want: movieMergePatch{Runtime: ptr(data.Runtime(0))}},
+		{name: "runtime set", body: `{"runtime": "148 mins"}`, want: movieMergePatch{Runtime: ptr(data.Runtime(148))}},
+		{name: "genres omitted", body: `{}`, want: movieMergePatch{}},
+		{name: "genres null", body: `{"genres": null}`, want: movieMergePatch{Genres: []string{}}},
+		{name: "genres set", body: `{"genres": ["sci-fi"]}`, want: movieMergePatch{Genres: []string{"sci-fi"}}},
+		{name: "tags omitted", body: `{}`, want: movieMergePatch{}},
+		{name: "tags null", body: `{"tags": null}`, want: movieMergePatch{Tags: []string{}}},
+		{name: "tags set", body: `{"tags": ["dreams"]}`, want: movieMergePatch{Tags: []string{"dreams"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var raw map[string]json.RawMessage
+			err := json.Unmarshal([]byte(tt.body), &raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got movieMergePatch
+			v := validator.New()
+			decodeJSONFields(raw, &got, v)
+			if !v.Valid() {
+				t.Fatalf("got errors %v; want none", v.Errors)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v; want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateConfigJWTSecret(t *testing.T) {
+	tests := []struct {
+		name    string
+		secret  string
+		keys    map[string][]byte
+		current string
+		want    string // Expected error for jwt-secret, or empty for none.
+	}{
+		{name: "missing secret", want: "must be provided"},
+		{name: "short secret", secret: "too-short", want: "must be at least 32 bytes long"},
+		{name: "valid secret", secret: strings.Repeat("s", 32)},
+		{name: "keyring without secret", keys: map[string][]byte{"2024": []byte(strings.Repeat("k", 32))}, current: "2024"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg config
+			cfg.jwt.secret = tt.secret
+			cfg.jwt.keys = tt.keys
+			cfg.jwt.currentKID = tt.current
+
+			v := validator.New()
+			validateConfig(v, cfg)
+			if got := v.Errors["jwt-secret"]; got != tt.want {
+				t.Errorf("got jwt-secret error %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyJWT(t *testing.T) {
+	secret := strings.Repeat("s", 32)
+	current := []byte(strings.Repeat("c", 32))
+	retired := []byte(strings.Repeat("r", 32))
+
+	// sign returns a JWT for user 42 with the given key ID, signed with the given secret.
+	sign := func(kid string, key []byte) string {
+		var claims jwt.Claims
+		claims.Subject = "42"
+		claims.NotBefore = jwt.NewNumericTime(time.Now())
+		claims.Expires = jwt.NewNumericTime(time.Now().Add(time.Hour))
+		claims.Issuer = jwtIssuer
+		claims.Audiences = []string{jwtIssuer}
+		claims.KeyID = kid
+		token, err := claims.HMACSign(jwt.HS256, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(token)
+	}
+
+	tests := []struct {
+		name    string
+		secret  string // The -jwt-secret the application runs with.
+		token   string
+		wantErr bool
+	}{
+		{name: "current kid", secret: secret, token: sign("2025", current)},
+		{name: "retired kid still in the keyring", secret: secret, token: sign("2024", retired)},
+		{name: "no kid", secret: secret, token: sign("", []byte(secret))},
+		{name: "no kid without a secret", token: sign("", []byte(secret)), wantErr: true},
+		{name: "unknown kid", secret: secret, token: sign("2023", retired), wantErr: true},
+		{name: "kid signed with another key", secret: secret, token: sign("2025", retired), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &application{}
+			app.config.jwt.secret = tt.secret
+			app.config.jwt.keys = map[string][]byte{"2025": current, "2024": retired}
+			app.config.jwt.currentKID = "2025"
+
+			_, userID, err := app.verifyJWT(tt.token)
+			switch {
+			case tt.wantErr && !errors.Is(err, errInvalidJWT):
+				t.Fatalf("got error %v; want one wrapping errInvalidJWT", err)
+			case !tt.wantErr && err != nil:
+				t.Fatalf("got error %v; want none", err)
+			case !tt.wantErr && userID != 42:
+				t.Errorf("got user ID %d; want 42", userID)
+			}
+		})
+	}
+}
@@ -4,16 +4,24 @@ import (
 	"cinevault.interimme.net/internal/data"
 	"cinevault.interimme.net/internal/jsonlog"
 	"cinevault.interimme.net/internal/mailer"
+	"cinevault.interimme.net/internal/storage"
+	"cinevault.interimme.net/internal/validator"
 	"context"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"expvar"
 	"flag"
 	"fmt"
 	_ "github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+	"io"
+	"net"
 	"os"
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -25,41 +33,126 @@ var (
 
 // config struct holds all configuration settings for the application.
 type config struct {
-	port int      // Port for the API server
-	env  string   // Environment (development, staging, production)
-	db   struct { // Database configuration
+	port            int           // Port for the API server
+	env             string        // Environment (development, staging, production)
+	maxBodyBytes    int64         // Default maximum size of JSON request bodies, in bytes
+	requestTimeout  time.Duration // Overall deadline for handling a request; 0 for no deadline
+	shutdownTimeout time.Duration // How long a graceful shutdown waits for requests and background jobs to finish
+	autoActivate    bool          // Create new users already activated, without sending an activation email
+	jsonIndent      bool          // Pretty-print JSON responses; defaults to on only in development
+	envelope        bool          // Wrap JSON responses in an envelope such as {"movie": ...}, unless the request says not to
+	movieUUIDs      bool          // Identify movies to clients by random UUIDs instead of their sequential IDs
+	runtimeFormat   string        // Default format of movie runtimes in JSON: "string" ("102 mins") or "int" (102)
+	log             struct {      // Logging configuration
+		format  jsonlog.Format // Output format of the log entries (json or text)
+		file    string         // Path of the file to write logs to, or empty to write to stdout
+		maxSize int            // Size in megabytes after which the log file is rotated; 0 for no limit
+		daily   bool           // Rotate the log file when the day changes
+		access  bool           // Log every completed request at INFO level
+	}
+	db struct { // Database configuration
 		dsn          string // Data Source Name for PostgreSQL connection
+		replicaDSN   string // Data Source Name for a read replica, or empty to read from the primary
 		maxOpenConns int    // Maximum number of open connections to the database
 		maxIdleConns int    // Maximum number of idle connections in the pool
 		maxIdleTime  string // Maximum time a connection can remain idle
+		slowQueryMS  int    // Queries taking at least this many milliseconds are logged; 0 disables slow query logging
+	}
+	movieCache struct { // In-memory cache of movies fetched by ID
+		size int           // Maximum number of movies cached; 0 disables the cache
+		ttl  time.Duration // How long a movie is cached for; 0 caches it until it is evicted
+	}
+	permissionCache struct { // In-memory cache of each user's permissions, checked on every protected request
+		size int           // Maximum number of users whose permissions are cached; 0 disables the cache
+		ttl  time.Duration // How long a user's permissions are cached for
 	}
 	limiter struct { // Rate limiter settings
-		enabled bool    // Enable rate limiter
-		rps     float64 // Maximum requests per second
-		burst   int     // Maximum burst size
+		enabled   bool    // Enable rate limiter
+		rps       float64 // Maximum requests per second
+		burst     int     // Maximum burst size
+		authRPS   float64 // Maximum requests per second for the authentication and password endpoints
+		authBurst int     // Maximum burst size for the authentication and password endpoints
 	}
 	smtp struct { // SMTP settings for sending emails
-		host     string // SMTP host
-		port     int    // SMTP port
-		username string // SMTP username
-		password string // SMTP password
-		sender   string // SMTP sender email address
+		host        string // SMTP host
+		port        int    // SMTP port
+		username    string // SMTP username
+		password    string // SMTP password
+		sender      string // SMTP sender email address
+		templateDir string // Directory of email templates overriding the embedded ones with the same filename; empty for none
+	}
+	genres struct { // Genre settings
+		strict bool // Reject movies with genres that are not in the canonical genres table
+	}
+	password   data.PasswordPolicy // Rules that new passwords must follow
+	bcryptCost int                 // Cost of new bcrypt password hashes; older hashes are upgraded on login
+	validation struct {            // Validation error settings
+		codes bool // Include a machine-readable code alongside each validation error message
+	}
+	trustedProxies []*net.IPNet // Networks of the proxies whose forwarded client IP headers are honored
+	ipFilter       struct {     // Client IP filtering, checked before any other work is done for a request
+		allowlist []*net.IPNet // Networks of the only clients that may use the API; empty to allow every client
+		denylist  []*net.IPNet // Networks of clients that are refused, even if they are in the allowlist
+		paths     []string     // Path prefixes the filter applies to; empty for the whole API
 	}
 	cors struct { // CORS settings
-		trustedOrigins []string // Trusted origins for CORS
+		trustedOrigins   []string // Trusted origins for CORS
+		maxAge           int      // Number of seconds browsers may cache preflight responses for; 0 to omit
+		allowCredentials bool     // Allow credentialed requests from trusted origins
+	}
+	metrics struct { // Settings for the /debug/vars and /metrics endpoints
+		enabled         bool         // Serve the metrics endpoints at all; off by default in production
+		allowedNetworks []*net.IPNet // Networks of clients that may read the metrics without the metrics:read permission
+	}
+	tls struct { // TLS settings; the server uses plain HTTP unless a certificate is given
+		certFile string // Path of the PEM-encoded certificate (chain) file
+		keyFile  string // Path of the PEM-encoded private key file
+	}
+	storage struct { // Settings for storing uploaded files such as movie posters
+		dir     string // Local directory that uploaded files are written to
+		baseURL string // URL prefix under which uploaded files are served
 	}
 	jwt struct { // JWT settings
-		secret string // Secret key for signing JWTs
+		secret     string            // Secret key for signing JWTs without a key ID, and verifying JWTs that have none
+		keys       map[string][]byte // Keyring of secret keys by key ID, for rotating keys without invalidating tokens
+		currentKID string            // ID of the key in the keyring that new JWTs are signed with; empty to use secret
+		ttl        time.Duration     // Lifetime of the JWTs issued as authentication tokens
+	}
+	tokens struct { // Settings for the tokens stored in the database, such as the one-time tokens sent by email
+		hashScheme     string        // Scheme new tokens are hashed with (sha256 or sha512)
+		activationTTL  time.Duration // Lifetime of account activation tokens
+		resetTTL       time.Duration // Lifetime of password reset tokens
+		magicLinkTTL   time.Duration // Lifetime of passwordless login tokens
+		refreshTTL     time.Duration // Lifetime of refresh tokens
+		emailChangeTTL time.Duration // Lifetime of email change confirmation tokens
+		emailInterval  time.Duration // Minimum time between activation, password reset, or login link emails to the same address
+	}
+	jobs struct { // Background job settings
+		workers   int // Number of workers running background jobs such as sending emails
+		queueSize int // Number of jobs that can wait for a free worker before new jobs block
+	}
+	totp struct { // Two-factor authentication settings
+		key []byte // 32-byte AES key for encrypting TOTP secrets at rest; two-factor authentication is unavailable without it
 	}
 }
 
 // application struct holds all dependencies for the application, including configuration, logger, models, mailer, and wait group.
 type application struct {
-	config config          // Application configuration
-	logger *jsonlog.Logger // Custom logger for structured JSON logging
-	models data.Models     // Data models for interacting with the database
-	mailer mailer.Mailer   // Mailer for sending emails
-	wg     sync.WaitGroup  // Wait group for managing background goroutines
+	config  config          // Application configuration
+	logger  *jsonlog.Logger // Custom logger for structured JSON logging
+	db      *sql.DB         // Database connection pool, used directly by the healthcheck
+	replica *sql.DB         // Read replica connection pool, used directly by the healthcheck, or nil if there is none
+	models  data.Models     // Data models for interacting with the database
+	mailer  mailer.Mailer   // Mailer for sending emails
+	storage storage.Storage // Storage backend for uploaded files such as movie posters
+	jobs    chan func()     // Queue of background jobs waiting for a worker
+	wg      sync.WaitGroup  // Wait group for tracking queued and running background jobs
+	pending atomic.Int64    // Number of queued and running background jobs, as counted by wg
+
+	statsCache    statsCache    // Most recently computed figures reported by the stats endpoint
+	smtpCheck     smtpCheck     // Result of the most recent check that the SMTP server can be reached
+	emailThrottle emailThrottle // Times token emails were last sent to each address
+	shuttingDown  atomic.Bool   // Set once a shutdown signal has been received
 }
 
 // main is the entry point for the application.
@@ -68,34 +161,167 @@ func main() {
 
 	// Command-line flags for configuration settings
 	flag.IntVar(&cfg.port, "port", 4000, "API server port")
-	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+	flag.StringVar(&cfg.env, "env", "production", "Environment (development|staging|production); development exposes error details to clients")
+	flag.Int64Var(&cfg.maxBodyBytes, "max-body-bytes", 1_048_576, "Default maximum size of JSON request bodies in bytes")
+	flag.DurationVar(&cfg.requestTimeout, "request-timeout", 10*time.Second, "Deadline for handling each request (0 to disable)")
+	flag.DurationVar(&cfg.shutdownTimeout, "shutdown-timeout", 5*time.Second, "How long to wait for in-flight requests and background jobs during a graceful shutdown")
+	flag.BoolVar(&cfg.jsonIndent, "json-indent", false, "Pretty-print JSON responses (default true in development)")
+	flag.BoolVar(&cfg.envelope, "envelope", true, "Wrap JSON responses in an envelope object, unless a request sends X-Envelope: false")
+	flag.BoolVar(&cfg.autoActivate, "auto-activate-users", false, "Create new users already activated, skipping the activation email")
+	flag.BoolVar(&cfg.movieUUIDs, "movie-uuids", false, "Identify movies by UUID rather than sequential ID in URLs and responses")
+	flag.StringVar(&cfg.runtimeFormat, "runtime-format", "string", "Default format of movie runtimes in responses (string|int)")
+
+	// TLS settings
+	flag.StringVar(&cfg.tls.certFile, "tls-cert", "", "TLS certificate file, to serve HTTPS (requires -tls-key)")
+	flag.StringVar(&cfg.tls.keyFile, "tls-key", "", "TLS private key file, to serve HTTPS (requires -tls-cert)")
+
+	// Logging settings
+	flag.Func("log-format", "Log output format (json|text)", func(val string) error {
+		format, err := jsonlog.ParseFormat(val)
+		if err != nil {
+			return err
+		}
+		cfg.log.format = format
+		return nil
+	})
+	flag.StringVar(&cfg.log.file, "log-file", "", "Write logs to this file instead of stdout")
+	flag.IntVar(&cfg.log.maxSize, "log-max-size", 100, "Rotate the log file when it exceeds this many megabytes (0 for no limit)")
+	flag.BoolVar(&cfg.log.daily, "log-rotate-daily", false, "Rotate the log file when the day changes")
+	flag.BoolVar(&cfg.log.access, "access-log", false, "Log every completed request")
 
 	// Database connection settings
 	flag.StringVar(&cfg.db.dsn, "db-dsn", "", "PostgreSQL DSN")
+	flag.StringVar(&cfg.db.replicaDSN, "db-replica-dsn", "", "PostgreSQL read replica DSN (optional)")
 	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
 	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
 	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
+	flag.IntVar(&cfg.db.slowQueryMS, "db-slow-query-ms", 200, "Log queries taking at least this many milliseconds (0 to disable)")
+
+	// Movie cache settings
+	flag.IntVar(&cfg.movieCache.size, "movie-cache-size", 0, "Maximum number of movies to cache in memory (0 to disable)")
+	flag.DurationVar(&cfg.movieCache.ttl, "movie-cache-ttl", time.Minute, "How long to cache each movie for (0 to cache until evicted)")
+
+	// Permission cache settings
+	flag.IntVar(&cfg.permissionCache.size, "permission-cache-size", 10_000, "Maximum number of users whose permissions are cached in memory (0 to disable)")
+	flag.DurationVar(&cfg.permissionCache.ttl, "permission-cache-ttl", 30*time.Second, "How long to cache each user's permissions for")
 
 	// Rate limiter settings
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
 	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
+	flag.Float64Var(&cfg.limiter.authRPS, "limiter-auth-rps", 0.2, "Rate limiter maximum requests per second for token and password endpoints")
+	flag.IntVar(&cfg.limiter.authBurst, "limiter-auth-burst", 3, "Rate limiter maximum burst for token and password endpoints")
 
 	// SMTP settings for sending emails
-	flag.StringVar(&cfg.smtp.host, "smtp-host", "smtp.mailtrap.io", "SMTP host")
+	flag.StringVar(&cfg.smtp.host, "smtp-host", "smtp.mailtrap.io", "SMTP host (empty to log emails instead of sending them)")
 	flag.IntVar(&cfg.smtp.port, "smtp-port", 2525, "SMTP port")
 	flag.StringVar(&cfg.smtp.username, "smtp-username", "8e3787e43c2023", "SMTP username")
 	flag.StringVar(&cfg.smtp.password, "smtp-password", "f5539d047c69f7", "SMTP password")
 	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Cinevault <no-reply@cinevault.interimme.net>", "SMTP sender")
+	flag.StringVar(&cfg.smtp.templateDir, "smtp-template-dir", "", "Directory of email templates overriding the built-in ones with the same filename")
+
+	// Genre settings
+	flag.BoolVar(&cfg.genres.strict, "genres-strict", false, "Only allow movie genres from the canonical genres table")
 
-	// CORS trusted origins setting
+	// Password policy settings
+	flag.IntVar(&cfg.bcryptCost, "bcrypt-cost", 12, "Cost of bcrypt password hashes (4-31)")
+	flag.IntVar(&cfg.password.MinLength, "password-min-length", 8, "Minimum password length in bytes (at least 8)")
+	flag.BoolVar(&cfg.password.RequireMixedCase, "password-require-mixed-case", false, "Require passwords to contain upper and lower case letters")
+	flag.BoolVar(&cfg.password.RequireDigit, "password-require-digit", false, "Require passwords to contain a digit")
+	flag.BoolVar(&cfg.password.RequireSymbol, "password-require-symbol", false, "Require passwords to contain a symbol")
+	flag.BoolVar(&cfg.password.DenyCommon, "password-deny-common", true, "Reject commonly used passwords")
+
+	// Validation error settings
+	flag.BoolVar(&cfg.validation.codes, "validation-error-codes", false, "Include machine-readable codes in validation error responses")
+
+	// CORS settings
 	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated)", func(val string) error {
 		cfg.cors.trustedOrigins = strings.Fields(val)
 		return nil
 	})
+	flag.Func("trusted-proxies", "Trusted proxy IP addresses or CIDR ranges (space separated)", func(val string) error {
+		networks, err := parseNetworks(val)
+		cfg.trustedProxies = append(cfg.trustedProxies, networks...)
+		return err
+	})
+	flag.Func("ip-allowlist", "IP addresses or CIDR ranges of the only clients allowed to use the API (space separated; default all)", func(val string) error {
+		networks, err := parseNetworks(val)
+		cfg.ipFilter.allowlist = append(cfg.ipFilter.allowlist, networks...)
+		return err
+	})
+	flag.Func("ip-denylist", "IP addresses or CIDR ranges of clients refused access to the API (space separated)", func(val string) error {
+		networks, err := parseNetworks(val)
+		cfg.ipFilter.denylist = append(cfg.ipFilter.denylist, networks...)
+		return err
+	})
+	flag.Func("ip-filter-paths", "Path prefixes that -ip-allowlist and -ip-denylist apply to (space separated; default the whole API)", func(val string) error {
+		cfg.ipFilter.paths = append(cfg.ipFilter.paths, strings.Fields(val)...)
+		return nil
+	})
+	flag.IntVar(&cfg.cors.maxAge, "cors-max-age", 600, "Seconds browsers may cache CORS preflight responses (0 to omit)")
+	flag.BoolVar(&cfg.cors.allowCredentials, "cors-allow-credentials", false, "Allow credentialed CORS requests from trusted origins")
+
+	// Metrics endpoint settings
+	flag.BoolVar(&cfg.metrics.enabled, "metrics-enabled", true, "Serve the /debug/vars and /metrics endpoints (default false in production)")
+	cfg.metrics.allowedNetworks, _ = parseNetworks("127.0.0.0/8 ::1")
+	metricsAllowedIPsSet := false
+	flag.Func("metrics-allowed-ips", "IP addresses or CIDR ranges that may read the metrics without the metrics:read permission (space separated; default loopback)", func(val string) error {
+		// Replace the loopback default rather than adding to it the first time the flag is given.
+		if !metricsAllowedIPsSet {
+			cfg.metrics.allowedNetworks = nil
+			metricsAllowedIPsSet = true
+		}
+		networks, err := parseNetworks(val)
+		cfg.metrics.allowedNetworks = append(cfg.metrics.allowedNetworks, networks...)
+		return err
+	})
+
+	// Upload storage settings
+	flag.StringVar(&cfg.storage.dir, "storage-dir", "./uploads", "Directory for uploaded files")
+	flag.StringVar(&cfg.storage.baseURL, "storage-base-url", "/uploads", "URL prefix for uploaded files")
 
 	// JWT secret setting
 	flag.StringVar(&cfg.jwt.secret, "jwt-secret", "", "JWT secret")
+	flag.Func("jwt-keys", "Keyring of JWT secrets as kid=secret pairs (space separated)", func(val string) error {
+		cfg.jwt.keys = make(map[string][]byte)
+		for i, field := range strings.Fields(val) {
+			// Report a malformed key by its position only, since the field may hold nothing but the secret.
+			kid, secret, ok := strings.Cut(field, "=")
+			if !ok || kid == "" || secret == "" {
+				return fmt.Errorf("invalid key #%d, must be kid=secret", i+1)
+			}
+			if _, exists := cfg.jwt.keys[kid]; exists {
+				return fmt.Errorf("duplicate key ID %q", kid)
+			}
+			cfg.jwt.keys[kid] = []byte(secret)
+		}
+		return nil
+	})
+	flag.StringVar(&cfg.jwt.currentKID, "jwt-current-kid", "", "ID of the key in jwt-keys to sign new JWTs with")
+	flag.DurationVar(&cfg.jwt.ttl, "jwt-ttl", 24*time.Hour, "Lifetime of JWT authentication tokens")
+
+	// Token lifetime settings
+	flag.DurationVar(&cfg.tokens.activationTTL, "token-activation-ttl", 3*24*time.Hour, "Lifetime of account activation tokens")
+	flag.DurationVar(&cfg.tokens.resetTTL, "token-reset-ttl", 45*time.Minute, "Lifetime of password reset tokens")
+	flag.StringVar(&cfg.tokens.hashScheme, "token-hash", data.TokenHashSHA256, "Scheme to hash new tokens with (sha256|sha512)")
+	flag.DurationVar(&cfg.tokens.magicLinkTTL, "token-magic-link-ttl", 15*time.Minute, "Lifetime of passwordless login tokens")
+	flag.DurationVar(&cfg.tokens.refreshTTL, "token-refresh-ttl", 30*24*time.Hour, "Lifetime of refresh tokens")
+	flag.DurationVar(&cfg.tokens.emailChangeTTL, "token-email-change-ttl", 24*time.Hour, "Lifetime of email change confirmation tokens")
+	flag.DurationVar(&cfg.tokens.emailInterval, "token-email-interval", time.Minute, "Minimum time between activation, password reset, or login link emails to the same address (0 to disable)")
+
+	// Background job settings
+	flag.IntVar(&cfg.jobs.workers, "jobs-workers", 4, "Number of background job workers")
+	flag.IntVar(&cfg.jobs.queueSize, "jobs-queue-size", 100, "Maximum number of queued background jobs")
+
+	// Two-factor authentication settings
+	flag.Func("totp-key", "Hex-encoded 32-byte key for encrypting TOTP secrets", func(val string) error {
+		key, err := hex.DecodeString(val)
+		if err != nil || len(key) != 32 {
+			return errors.New("must be 64 hexadecimal characters")
+		}
+		cfg.totp.key = key
+		return nil
+	})
 
 	// Display version flag
 	displayVersion := flag.Bool("version", false, "Display version and exit")
@@ -103,6 +329,24 @@ func main() {
 	// Parse command-line flags
 	flag.Parse()
 
+	// Pretty-print JSON responses in development, unless the json-indent flag says otherwise.
+	// Likewise, don't serve the metrics endpoints in production unless the metrics-enabled flag is given.
+	jsonIndentSet, metricsEnabledSet := false, false
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "json-indent":
+			jsonIndentSet = true
+		case "metrics-enabled":
+			metricsEnabledSet = true
+		}
+	})
+	if !jsonIndentSet {
+		cfg.jsonIndent = cfg.env == "development"
+	}
+	if !metricsEnabledSet {
+		cfg.metrics.enabled = cfg.env != "production"
+	}
+
 	// Display version and exit if the version flag is set
 	if *displayVersion {
 		fmt.Printf("Version:\t%s\n", version)
@@ -110,11 +354,27 @@ func main() {
 		os.Exit(0)
 	}
 
-	// Initialize logger
-	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+	// Initialize logger, writing to stdout unless a log file is configured
+	var logOut io.Writer = os.Stdout
+	if cfg.log.file != "" {
+		logFile, err := jsonlog.NewRotatingFile(cfg.log.file, int64(cfg.log.maxSize)*1024*1024, cfg.log.daily)
+		if err != nil {
+			jsonlog.NewWithFormat(os.Stderr, jsonlog.LevelInfo, cfg.log.format).PrintFatal(err, nil)
+		}
+		defer logFile.Close()
+		logOut = logFile
+	}
+	logger := jsonlog.NewWithFormat(logOut, jsonlog.LevelInfo, cfg.log.format)
+
+	// Check the configuration before connecting to anything, reporting every problem at once rather than only
+	// the first, so that misconfiguration is caught at boot instead of mid-request.
+	v := validator.New()
+	if validateConfig(v, cfg); !v.Valid() {
+		logger.PrintFatal(errors.New("invalid configuration"), v.Errors)
+	}
 
 	// Open database connection
-	db, err := openDB(cfg)
+	db, err := openDB(cfg, cfg.db.dsn)
 	if err != nil {
 		logger.PrintFatal(err, nil)
 	}
@@ -122,6 +382,21 @@ func main() {
 
 	logger.PrintInfo("database connection pool established", nil)
 
+	// Open a second connection pool for the read replica, if one is configured.
+	slowQuery := time.Duration(cfg.db.slowQueryMS) * time.Millisecond
+	var replica *sql.DB
+	var readDB *data.DB
+	if cfg.db.replicaDSN != "" {
+		replica, err = openDB(cfg, cfg.db.replicaDSN)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		defer replica.Close()
+
+		readDB = data.NewDB(replica, logger, slowQuery)
+		logger.PrintInfo("read replica connection pool established", nil)
+	}
+
 	// Publish application metrics using expvar
 	expvar.NewString("version").Set(version)
 	expvar.Publish("goroutines", expvar.Func(func() interface{} {
@@ -134,14 +409,69 @@ func main() {
 		return time.Now().Unix()
 	}))
 
+	// Create the movie and permission caches, publishing their hit and miss counts if they are enabled.
+	movieCache := data.NewCache[*data.Movie](cfg.movieCache.size, cfg.movieCache.ttl)
+	if movieCache != nil {
+		expvar.Publish("movie_cache_hits", movieCache.Hits)
+		expvar.Publish("movie_cache_misses", movieCache.Misses)
+		expvar.Publish("movie_cache_size", expvar.Func(func() interface{} {
+			return movieCache.Len()
+		}))
+	}
+	permissionCache := data.NewCache[data.Permissions](cfg.permissionCache.size, cfg.permissionCache.ttl)
+	if permissionCache != nil {
+		expvar.Publish("permission_cache_hits", permissionCache.Hits)
+		expvar.Publish("permission_cache_misses", permissionCache.Misses)
+		expvar.Publish("permission_cache_size", expvar.Func(func() interface{} {
+			return permissionCache.Len()
+		}))
+	}
+
+	// Initialize the local storage backend for uploaded files.
+	store, err := storage.NewLocal(cfg.storage.dir, cfg.storage.baseURL)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	// Initialize the mailer, reporting failed send attempts to the logger. Without an SMTP host, emails are written
+	// to the logger instead of being sent.
+	mail := mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender)
+	mail.Logger = logger
+	if cfg.smtp.templateDir != "" {
+		info, err := os.Stat(cfg.smtp.templateDir)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		if !info.IsDir() {
+			logger.PrintFatal(errors.New("smtp-template-dir must be a directory"), nil)
+		}
+		mail.Templates = os.DirFS(cfg.smtp.templateDir)
+	}
+	if mail.LogOnly() {
+		logger.PrintWarn("no SMTP host configured, emails will be logged instead of sent", nil)
+	}
+
 	// Initialize the application struct with dependencies
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:  cfg,
+		logger:  logger,
+		db:      db,
+		replica: replica,
+		models:  data.NewModels(data.NewDB(db, logger, slowQuery), readDB, movieCache, permissionCache, cfg.tokens.hashScheme),
+		mailer:  mail,
+		storage: store,
+		jobs:    make(chan func(), cfg.jobs.queueSize),
 	}
 
+	// Start the workers that run background jobs.
+	app.startWorkers(cfg.jobs.workers)
+
+	// Check that the SMTP server can be reached, in the background so as not to hold up startup. A failure is only
+	// logged, so that a misconfigured relay is noticed before users miss their emails.
+	app.background(func() {
+		app.checkSMTP()
+	})
+
 	// Start the server
 	err = app.serve()
 	if err != nil {
@@ -149,10 +479,76 @@ func main() {
 	}
 }
 
-// openDB establishes a new database connection using the configuration settings and returns a sql.DB instance.
-// It also verifies the connection is available by pinging the database.
-func openDB(cfg config) (*sql.DB, error) {
-	db, err := sql.Open("postgres", cfg.db.dsn) // Open a new database connection using the PostgreSQL driver
+// minJWTSecretLength is the minimum length in bytes of the secrets JWTs are signed with, which is the size of the
+// HMAC-SHA256 output, as shorter secrets can be brute forced from a single token.
+const minJWTSecretLength = 32
+
+// validateConfig checks the configuration parsed from the command-line flags, adding an error for each invalid
+// setting to the validator, keyed by the name of its flag.
+func validateConfig(v *validator.Validator, cfg config) {
+	// Check the server settings.
+	v.Checkf(cfg.port >= 1 && cfg.port <= 65535, "port", "must be between %d and %d", 1, 65535)
+	v.Check(validator.In(cfg.env, "development", "staging", "production"), "env", "must be development, staging, or production")
+	v.Check(cfg.maxBodyBytes > 0, "max-body-bytes", "must be positive")
+	v.Check(cfg.requestTimeout >= 0, "request-timeout", "must not be negative") // Zero disables the timeout.
+	v.Check(cfg.shutdownTimeout > 0, "shutdown-timeout", "must be positive")    // A graceful shutdown needs some time to finish.
+	v.Check(validator.In(cfg.runtimeFormat, runtimeFormats...), "runtime-format", "must be string or int")
+	v.Check((cfg.tls.certFile == "") == (cfg.tls.keyFile == ""), "tls-cert", "must be given together with tls-key")
+
+	// Check the database settings. The idle time is parsed again when opening the pool.
+	v.Check(cfg.db.dsn != "", "db-dsn", "must be provided")
+	v.Check(cfg.db.maxOpenConns > 0, "db-max-open-conns", "must be positive")
+	v.Check(cfg.db.maxIdleConns > 0, "db-max-idle-conns", "must be positive")
+	_, err := time.ParseDuration(cfg.db.maxIdleTime)
+	v.Check(err == nil, "db-max-idle-time", "must be a duration such as 15m")
+
+	// Check that the bcrypt cost is one that the bcrypt package accepts.
+	v.Checkf(cfg.bcryptCost >= bcrypt.MinCost && cfg.bcryptCost <= bcrypt.MaxCost, "bcrypt-cost", "must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+
+	// Check the JWT secrets. The plain secret can only be left out if there is a keyring to sign tokens with, and
+	// new tokens must then be signed with a key that is in it.
+	v.Check(cfg.jwt.secret != "" || len(cfg.jwt.keys) > 0, "jwt-secret", "must be provided")
+	if cfg.jwt.secret != "" {
+		v.Checkf(len(cfg.jwt.secret) >= minJWTSecretLength, "jwt-secret", "must be at least %d bytes long", minJWTSecretLength)
+	}
+	for _, key := range cfg.jwt.keys {
+		v.Checkf(len(key) >= minJWTSecretLength, "jwt-keys", "must each be at least %d bytes long", minJWTSecretLength)
+	}
+	if len(cfg.jwt.keys) > 0 || cfg.jwt.currentKID != "" {
+		_, ok := cfg.jwt.keys[cfg.jwt.currentKID]
+		v.Check(ok, "jwt-current-kid", "must be the ID of one of the jwt-keys")
+	}
+
+	// Check that the token lifetimes are positive.
+	v.Check(cfg.jwt.ttl > 0, "jwt-ttl", "must be positive")
+	v.Check(cfg.tokens.activationTTL > 0, "token-activation-ttl", "must be positive")
+	v.Check(cfg.tokens.resetTTL > 0, "token-reset-ttl", "must be positive")
+	v.Check(cfg.tokens.magicLinkTTL > 0, "token-magic-link-ttl", "must be positive")
+	v.Check(cfg.tokens.refreshTTL > 0, "token-refresh-ttl", "must be positive")
+	v.Check(cfg.tokens.emailChangeTTL > 0, "token-email-change-ttl", "must be positive")
+	v.Check(validator.In(cfg.tokens.hashScheme, data.TokenHashSchemes...), "token-hash", "must be sha256 or sha512")
+
+	// Check that the IP filter paths are paths, since a prefix without the leading slash would never match.
+	for _, path := range cfg.ipFilter.paths {
+		v.Check(strings.HasPrefix(path, "/"), "ip-filter-paths", "must each start with /")
+	}
+
+	// Check that there is at least one worker to run background jobs, and that the queue size is usable.
+	v.Check(cfg.jobs.workers >= 1, "jobs-workers", "must be at least 1")
+	v.Check(cfg.jobs.queueSize >= 0, "jobs-queue-size", "must not be negative")
+
+	// Check the cache settings. The permission cache TTL must be positive, since grants and revocations made by
+	// other instances are only seen once the cached permissions expire.
+	v.Check(cfg.movieCache.size >= 0, "movie-cache-size", "must not be negative")
+	v.Check(cfg.movieCache.ttl >= 0, "movie-cache-ttl", "must not be negative")
+	v.Check(cfg.permissionCache.size >= 0, "permission-cache-size", "must not be negative")
+	v.Check(cfg.permissionCache.ttl > 0, "permission-cache-ttl", "must be positive")
+}
+
+// openDB establishes a new database connection to dsn using the pool settings in the configuration and returns a
+// sql.DB instance. It also verifies the connection is available by pinging the database.
+func openDB(cfg config, dsn string) (*sql.DB, error) {
+	db, err := sql.Open("postgres", dsn) // Open a new database connection using the PostgreSQL driver
 	if err != nil {
 		return nil, err
 	}
@@ -182,3 +578,24 @@ func openDB(cfg config) (*sql.DB, error) {
 
 	return db, nil
 }
+
+// parseNetworks parses a space-separated list of IP addresses and CIDR ranges, treating a bare IP address as a
+// network containing just that address.
+func parseNetworks(val string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, field := range strings.Fields(val) {
+		if !strings.Contains(field, "/") {
+			if ip := net.ParseIP(field); ip != nil && ip.To4() != nil {
+				field += "/32"
+			} else {
+				field += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(field)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
@@ -1,21 +1,39 @@
 package main
 
 import (
+	"bytes"
 	"cinevault.interimme.net/internal/data"
 	"cinevault.interimme.net/internal/validator"
+	"context"
+	"encoding/json"
 	"errors"
 	"expvar"
 	"fmt"
 	"github.com/felixge/httpsnoop"
-	"github.com/tomasen/realip"
 	"golang.org/x/time/rate"
+	"io"
+	"net"
 	"net/http"
+	"regexp"
+	"runtime/debug"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 )
 
+// panicError is the error that recoverPanic reports a recovered panic as, holding the stack of the goroutine that
+// panicked so that serverErrorResponse can show it in development.
+type panicError struct {
+	value interface{} // Value passed to panic.
+	stack []byte      // Stack trace taken when the panic was recovered.
+}
+
+// Error returns the panic value formatted as a string.
+func (e *panicError) Error() string {
+	return fmt.Sprintf("%s", e.value)
+}
+
 // recoverPanic is a middleware that recovers from any panic that occurs during the HTTP request handling.
 // It logs the panic and returns a 500 Internal Server Error response to the client.
 func (app *application) recoverPanic(next http.Handler) http.Handler {
@@ -24,17 +42,342 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 			if err := recover(); err != nil {
 				// Set the Connection header to close to prevent the client from reusing the connection.
 				w.Header().Set("Connection", "close")
-				// Log the error and send a generic server error response.
-				app.serverErrorResponse(w, r, fmt.Errorf("%s", err))
+				// Log the error and send a server error response, capturing the stack while it still leads to
+				// the panic, unless the timeout middleware already did in the goroutine that panicked.
+				pe, ok := err.(*panicError)
+				if !ok {
+					pe = &panicError{value: err, stack: debug.Stack()}
+				}
+				app.serverErrorResponse(w, r, pe)
 			}
 		}()
 		next.ServeHTTP(w, r)
 	})
 }
 
-// rateLimit is a middleware that implements rate limiting for incoming HTTP requests based on the client's IP address.
-// It uses a token bucket algorithm to control the rate of requests.
-func (app *application) rateLimit(next http.Handler) http.Handler {
+// rejectDuringShutdown is a middleware that responds with a 503 Service Unavailable to any request that arrives
+// after the server has started shutting down, such as one sent on a connection that was already open, so that the
+// client retries it against another instance instead of it being cut off during the shutdown.
+func (app *application) rejectDuringShutdown(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.shuttingDown.Load() {
+			app.shuttingDownResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// untimedPaths lists the paths that the timeout middleware leaves alone, because their responses are streamed for
+// as long as the client keeps reading.
+var untimedPaths = []string{"/v1/movies/export"}
+
+// timeout is a middleware that gives each request an overall deadline of config.requestTimeout, on the request
+// context that is passed down to the models, so that a handler chaining several queries can't run indefinitely.
+// The deadline cancels any query in progress when it passes, and serverErrorResponse then reports the failure as
+// a 503 Service Unavailable rather than a 500. Handlers that don't watch the context, such as while encoding a
+// large response, are run in their own goroutine with their response buffered, so that the 503 is sent at the
+// deadline whatever they are doing; whatever they write afterwards is discarded.
+func (app *application) timeout(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.config.requestTimeout <= 0 || validator.In(r.URL.Path, untimedPaths...) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), app.config.requestTimeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+
+		tw := &timeoutWriter{header: make(http.Header)}
+		done := make(chan struct{})
+		panics := make(chan *panicError, 1)
+		go func() {
+			// Pass a panic on to the request's goroutine, where recoverPanic can handle it, with the stack of
+			// this goroutine, which is the one that leads to the panic.
+			defer func() {
+				if err := recover(); err != nil {
+					panics <- &panicError{value: err, stack: debug.Stack()}
+				}
+			}()
+			next.ServeHTTP(tw, r)
+			close(done)
+		}()
+
+		select {
+		case pe := <-panics:
+			panic(pe)
+		case <-done:
+			tw.flushTo(w)
+		case <-ctx.Done():
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			tw.timedOut = true
+			// Only report a timeout; a client that went away is not waiting for a response.
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				app.requestTimeoutResponse(w, r)
+			}
+		}
+	})
+}
+
+// timeoutWriter is the http.ResponseWriter that the timeout middleware gives a handler, holding on to the response
+// until the handler returns, so that it can be replaced with a 503 response if the deadline passes first.
+type timeoutWriter struct {
+	mu       sync.Mutex   // Guards the fields below, which the handler and the middleware use from different goroutines.
+	header   http.Header  // Headers set by the handler.
+	body     bytes.Buffer // Body written by the handler.
+	status   int          // Status code written by the handler, or 0 if none has been.
+	timedOut bool         // Set once the deadline has passed, after which writes are refused.
+}
+
+// Header returns the headers of the buffered response.
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+// Write adds to the buffered body, returning http.ErrHandlerTimeout once the request has timed out.
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.status == 0 {
+		tw.status = http.StatusOK
+	}
+	return tw.body.Write(b)
+}
+
+// WriteHeader records the status code of the buffered response, ignoring any after the first, as net/http does.
+func (tw *timeoutWriter) WriteHeader(status int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.status != 0 {
+		return
+	}
+	tw.status = status
+}
+
+// flushTo writes the buffered response to w, once the handler has returned.
+func (tw *timeoutWriter) flushTo(w http.ResponseWriter) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	for key, values := range tw.header {
+		w.Header()[key] = values
+	}
+	if tw.status == 0 {
+		tw.status = http.StatusOK
+	}
+	w.WriteHeader(tw.status)
+	w.Write(tw.body.Bytes())
+}
+
+// requestIDRX matches the incoming X-Request-ID header values that are safe to reuse and echo into the logs.
+var requestIDRX = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// requestID is a middleware that assigns an ID to every request, storing it in the request context and
+// returning it in the X-Request-ID response header. A well-formed incoming X-Request-ID header is honored
+// so that IDs generated by a proxy or client can be correlated with the server logs.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDRX.MatchString(id) {
+			var err error
+			id, err = newUUID()
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+
+		// Set the response header and add the ID to the request context.
+		w.Header().Set("X-Request-ID", id)
+		r = app.contextSetRequestID(r, id)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitOptions configures an independent rate limiter that can be applied to a group of routes.
+type rateLimitOptions struct {
+	rps   float64                                             // Maximum requests per second for each key
+	burst int                                                 // Maximum burst size for each key
+	key   func(w http.ResponseWriter, r *http.Request) string // Function deriving the key that requests are limited by
+}
+
+// clientIP returns the IP address of the client that made the request. The X-Forwarded-For and X-Real-IP headers
+// are only honored when the request comes directly from one of the trusted proxies, since anyone else could set
+// them to anything. X-Forwarded-For is read from right to left, skipping the addresses of trusted proxies, so that
+// the result is the first address that wasn't added by our own infrastructure.
+func (app *application) clientIP(r *http.Request) string {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	if !app.isTrustedProxy(peer) {
+		return peer
+	}
+
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		hops := strings.Split(forwarded, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break // A malformed entry means the rest of the header can't be relied on.
+			}
+			if i == 0 || !app.isTrustedProxy(hop) {
+				return hop
+			}
+		}
+	}
+
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+	return peer
+}
+
+// isTrustedProxy reports whether ip is within one of the configured trusted proxy networks.
+func (app *application) isTrustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range app.config.trustedProxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// requireMetricsAccess is a middleware restricting the metrics endpoints to clients whose IP address is in one of
+// the networks allowed by the configuration, and otherwise to activated users with the metrics:read permission.
+func (app *application) requireMetricsAccess(next http.Handler) http.HandlerFunc {
+	withPermission := app.requirePermission("metrics:read", next.ServeHTTP)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ip := net.ParseIP(app.clientIP(r)); ip != nil {
+			for _, network := range app.config.metrics.allowedNetworks {
+				if network.Contains(ip) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+		withPermission(w, r)
+	}
+}
+
+// filterIPs is a middleware that refuses requests from clients whose IP address, as found by clientIP, is in one of
+// the networks of the denylist, or, if there is an allowlist, in none of its networks. Only requests for paths under
+// the configured prefixes are checked, if any are given, so that the filter can guard just part of the API such as
+// the admin endpoints. The probe paths are never filtered, so that an orchestrator's probes always reach their
+// handlers.
+func (app *application) filterIPs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.ipFiltered(r) && !validator.In(r.URL.Path, probePaths...) && !app.ipAllowed(net.ParseIP(app.clientIP(r))) {
+			app.ipNotAllowedResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ipFiltered reports whether a request is for a path that the IP filter applies to.
+func (app *application) ipFiltered(r *http.Request) bool {
+	if len(app.config.ipFilter.paths) == 0 {
+		return true
+	}
+	for _, prefix := range app.config.ipFilter.paths {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipAllowed reports whether a client IP address gets past the IP filter. The denylist takes precedence over the
+// allowlist, so that a single address can be refused from within an allowed range. An address that couldn't be
+// parsed is only allowed if there is no allowlist.
+func (app *application) ipAllowed(ip net.IP) bool {
+	if ip == nil {
+		return len(app.config.ipFilter.allowlist) == 0
+	}
+	for _, network := range app.config.ipFilter.denylist {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	if len(app.config.ipFilter.allowlist) == 0 {
+		return true
+	}
+	for _, network := range app.config.ipFilter.allowlist {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRuntimeFormat is a middleware that rejects requests with an unknown runtime_format query parameter. It
+// checks the parameter before the handler runs, rather than when the movies in the response are prepared by
+// presentMovies, so that a request which changes data fails before making the change.
+func (app *application) checkRuntimeFormat(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if format := r.URL.Query().Get("runtime_format"); format != "" && !validator.In(format, runtimeFormats...) {
+			v := validator.New()
+			v.AddError("runtime_format", "invalid runtime_format value")
+			app.failedValidationResponse(w, r, v)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitIPKey limits requests by the client's IP address.
+func (app *application) rateLimitIPKey(w http.ResponseWriter, r *http.Request) string {
+	return app.clientIP(r)
+}
+
+// rateLimitIPEmailKey limits requests by the client's IP address combined with the "email" field of the
+// JSON request body, if there is one. The body is restored afterwards so the handler can still read it. No more of
+// the body is read than the handlers behind the limiter accept, maxAuthBodyBytes; if reading fails, such as when
+// the body is larger, the handler is given what was read followed by the same error, so that it responds as it
+// would have had it read the body itself.
+func (app *application) rateLimitIPEmailKey(w http.ResponseWriter, r *http.Request) string {
+	key := app.clientIP(r)
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxAuthBodyBytes))
+	if err != nil {
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), errorReader{err}))
+		return key
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var input struct {
+		Email string `json:"email"`
+	}
+	if json.Unmarshal(body, &input) == nil && input.Email != "" {
+		key += "|" + strings.ToLower(input.Email)
+	}
+	return key
+}
+
+// errorReader is an io.Reader that always fails with err.
+type errorReader struct {
+	err error
+}
+
+// Read returns the reader's error.
+func (er errorReader) Read(p []byte) (int, error) {
+	return 0, er.err
+}
+
+// rateLimit returns a middleware that implements rate limiting for incoming HTTP requests, using a token bucket
+// per key as derived by opts.key. Each call creates its own set of limiters, so different route groups can be
+// given different limits. Requests to the probe paths are never limited.
+func (app *application) rateLimit(opts rateLimitOptions) func(http.Handler) http.Handler {
 	type client struct {
 		limiter  *rate.Limiter // Rate limiter for the client
 		lastSeen time.Time     // Timestamp of the last request from the client
@@ -42,7 +385,7 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 
 	var (
 		mu      sync.Mutex                 // Mutex to protect the clients map
-		clients = make(map[string]*client) // Map to store rate limiter instances per client IP
+		clients = make(map[string]*client) // Map to store rate limiter instances per client key
 	)
 
 	// Background goroutine to periodically clean up old clients from the map.
@@ -50,66 +393,145 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 		for {
 			time.Sleep(time.Minute)
 			mu.Lock()
-			for ip, client := range clients {
+			for key, client := range clients {
 				// Remove clients that haven't been seen in the last 3 minutes.
 				if time.Since(client.lastSeen) > 3*time.Minute {
-					delete(clients, ip)
+					delete(clients, key)
 				}
 			}
 			mu.Unlock()
 		}
 	}()
 
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if app.config.limiter.enabled {
-			// Extract the client's IP address from the request.
-			ip := realip.FromRequest(r)
-			mu.Lock()
-			// Initialize a new rate limiter for the client if it doesn't exist.
-			if _, found := clients[ip]; !found {
-				clients[ip] = &client{
-					limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst),
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if app.config.limiter.enabled && !validator.In(r.URL.Path, probePaths...) {
+				// Derive the key that this request is limited by.
+				key := opts.key(w, r)
+				mu.Lock()
+				// Initialize a new rate limiter for the client if it doesn't exist.
+				if _, found := clients[key]; !found {
+					clients[key] = &client{
+						limiter: rate.NewLimiter(rate.Limit(opts.rps), opts.burst),
+					}
+				}
+				clients[key].lastSeen = time.Now()
+				// Check if the client is allowed to make a request.
+				if !clients[key].limiter.Allow() {
+					mu.Unlock()
+					// Suggest retrying after the time it takes the bucket to refill by one token. A limiter with
+					// no rate never refills, so the client is just asked to wait a minute.
+					retryAfter := time.Minute
+					if opts.rps > 0 {
+						retryAfter = time.Duration(float64(time.Second) / opts.rps)
+					}
+					app.rateLimitExceededResponse(w, r, retryAfter)
+					return
 				}
-			}
-			clients[ip].lastSeen = time.Now()
-			// Check if the client is allowed to make a request.
-			if !clients[ip].limiter.Allow() {
 				mu.Unlock()
-				app.rateLimitExceededResponse(w, r)
-				return
 			}
-			mu.Unlock()
-		}
-		next.ServeHTTP(w, r)
-	})
+			next.ServeHTTP(w, r)
+		})
+	}
 }
 
 // authenticate is a middleware that checks for a valid authentication token in the request headers.
-// If a valid token is found, the corresponding user is loaded into the request context.
+// If a valid token is found, the corresponding user is loaded into the request context. Both the JWTs issued by
+// createAuthenticationTokenHandler and opaque tokens from the tokens table are accepted with the Bearer scheme, and
+// API keys with the ApiKey scheme, in which case the key is loaded into the context too, for requirePermission to
+// limit the request to its scopes. Requests to the probe paths are always treated as anonymous, so that a probe sent
+// with a stale token still succeeds.
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Set Vary header to ensure clients cache different responses based on the Authorization header.
-		w.Header().Set("Vary", "Authorization")
+		w.Header().Add("Vary", "Authorization")
 
 		// Retrieve the Authorization header from the request.
 		authorizationHeader := r.Header.Get("Authorization")
 
-		if authorizationHeader == "" {
-			// No Authorization header, proceed with an anonymous user.
+		if authorizationHeader == "" || validator.In(r.URL.Path, probePaths...) {
+			// No Authorization header, or a probe, proceed with an anonymous user.
 			r = app.contextSetUser(r, data.AnonymousUser)
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		// Split the header into its components.
-		headerParts := strings.Split(authorizationHeader, " ")
-		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+		// If the header holds an API key, load the key and the user it belongs to.
+		if key, ok := apiKeyCredentials(r); ok {
+			v := validator.New()
+			if data.ValidateAPIKeyPlaintext(v, key); !v.Valid() {
+				app.invalidAPIKeyResponse(w, r)
+				return
+			}
+
+			apiKey, err := app.models.APIKeys.GetForKey(r.Context(), key)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					// The key doesn't exist or has been revoked.
+					app.invalidAPIKeyResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+
+			user, err := app.models.Users.Get(r.Context(), apiKey.UserID)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					app.invalidAPIKeyResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+
+			r = app.contextSetUser(r, user)
+			r = app.contextSetAPIKey(r, apiKey)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Extract the token from the header.
+		token, ok := bearerToken(r)
+		if !ok {
 			// Invalid Authorization header format.
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
 
-		token := headerParts[1]
+		// If the token is a JWT, verify it and load the user it was issued to.
+		if isJWT(token) {
+			_, userID, err := app.verifyJWT(token)
+			if err != nil {
+				switch {
+				case errors.Is(err, errInvalidJWT):
+					app.invalidAuthenticationTokenResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+
+			user, err := app.models.Users.Get(r.Context(), userID)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					// The user has been deleted since the token was issued.
+					app.invalidAuthenticationTokenResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+
+			r = app.contextSetUser(r, user)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Otherwise, treat it as an opaque token stored in the tokens table.
 
 		v := validator.New()
 
@@ -120,7 +542,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		}
 
 		// Fetch the user associated with the token from the database.
-		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		user, err := app.models.Users.GetForToken(r.Context(), data.ScopeAuthentication, token)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -170,13 +592,35 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 	return app.requireAuthenticatedUser(fn)
 }
 
+// requireUserSession is a middleware that refuses requests made with an API key, so that only a user logged in with
+// a session can access the next handler. It guards the endpoints for managing the account itself, so that a key
+// can't be used to widen its own access, for example by minting a key with more scopes, and sits inside
+// requireAuthenticatedUser or requireActivatedUser.
+func (app *application) requireUserSession(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetAPIKey(r) != nil {
+			// The request was authenticated with an API key.
+			app.apiKeyNotAllowedResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 // requirePermission is a middleware that checks if the user has the necessary permission to access the next handler.
+// If the request was made with an API key, the permission must also be one of the key's scopes.
 func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
 	fn := func(w http.ResponseWriter, r *http.Request) {
+		// Check the key's scopes first, which needs no database query.
+		if key := app.contextGetAPIKey(r); key != nil && !key.Scopes.Include(code) {
+			app.apiKeyScopeResponse(w, r)
+			return
+		}
+
 		// Retrieve the user from the request context.
 		user := app.contextGetUser(r)
-		// Fetch all permissions for the user from the database.
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		// Fetch all permissions for the user, from the permission cache if it holds them or the database otherwise.
+		permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
@@ -193,24 +637,27 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 }
 
 // enableCORS is a middleware that adds the necessary headers to support Cross-Origin Resource Sharing (CORS).
+// Preflight requests are passed on to the router, which answers them with preflightHandler, as only the router
+// knows which methods are registered for the path.
 func (app *application) enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Add Vary headers to ensure clients cache different responses based on Origin and Access-Control-Request-Method headers.
+		// Add Vary headers to ensure clients cache different responses based on the Origin and preflight request headers.
 		w.Header().Add("Vary", "Origin")
 		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
 		origin := r.Header.Get("Origin")
-		if origin != "" {
+		if origin != "" && origin != "*" {
 			// Check if the request origin is in the list of trusted origins.
 			for i := range app.config.cors.trustedOrigins {
 				if origin == app.config.cors.trustedOrigins[i] {
 					// Set the Access-Control-Allow-Origin header to allow the origin.
 					w.Header().Set("Access-Control-Allow-Origin", origin)
-					// Handle preflight requests.
-					if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
-						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
-						w.WriteHeader(http.StatusOK)
-						return
+					// Let the browser show scripts the ETag header, which they need for conditional requests.
+					w.Header().Set("Access-Control-Expose-Headers", "ETag")
+					// Allow credentials if configured. This is safe because the origin has been matched exactly,
+					// rather than allowed with a wildcard.
+					if app.config.cors.allowCredentials {
+						w.Header().Set("Access-Control-Allow-Credentials", "true")
 					}
 					break
 				}
@@ -220,14 +667,44 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 	})
 }
 
+// preflightHandler answers the OPTIONS requests that the router handles automatically, for paths without an
+// OPTIONS route of their own. The router sets the Allow header to the methods registered for the path before
+// calling it, so that a CORS preflight from a trusted origin is told exactly which methods the endpoint accepts.
+// Every OPTIONS request gets a 204 No Content response, as there is never a body.
+func (app *application) preflightHandler(w http.ResponseWriter, r *http.Request) {
+	// Only preflight requests from an origin that enableCORS has allowed get the preflight headers.
+	if w.Header().Get("Access-Control-Allow-Origin") != "" && r.Header.Get("Access-Control-Request-Method") != "" {
+		w.Header().Set("Access-Control-Allow-Methods", w.Header().Get("Allow"))
+		// Allow the headers the browser asked for, falling back to the ones the API uses.
+		headers := r.Header.Get("Access-Control-Request-Headers")
+		if headers == "" {
+			headers = "Authorization, Content-Type, If-Match, If-None-Match, X-Envelope"
+		}
+		w.Header().Set("Access-Control-Allow-Headers", headers)
+		// Let the browser cache the preflight result, so it doesn't repeat it for every request.
+		if app.config.cors.maxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(app.config.cors.maxAge))
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sensitivePathPrefixes are the paths whose query strings are left out of the access log, since requests to them
+// may carry tokens or email addresses. Request bodies are never logged, for any path.
+var sensitivePathPrefixes = []string{"/v1/tokens/", "/v1/users"}
+
 // metrics is a middleware that tracks application metrics such as total requests received, total responses sent,
-// and the processing time for each request.
+// and the processing time for each request. The metrics are published via expvar, from which the Prometheus
+// endpoint also reads them. When the access log is enabled, each completed request is also logged from the same
+// captured metrics, so that the response is only wrapped once.
 func (app *application) metrics(next http.Handler) http.Handler {
 	// Define expvar variables to hold the metrics.
 	totalRequestsReceived := expvar.NewInt("total_requests_received")
 	totalResponsesSent := expvar.NewInt("total_responses_sent")
 	totalProcessingTimeMicroseconds := expvar.NewInt("total_processing_time_μs")
 	totalResponsesSentByStatus := expvar.NewMap("total_responses_sent_by_status")
+	requestDurationSeconds := newHistogram(0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10)
+	expvar.Publish("request_duration_seconds", requestDurationSeconds)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Increment the total number of requests received.
@@ -238,9 +715,36 @@ func (app *application) metrics(next http.Handler) http.Handler {
 
 		// Increment the total number of responses sent.
 		totalResponsesSent.Add(1)
-		// Add the processing time for the request to the total processing
-
+		// Add the processing time for the request to the total processing time and the duration histogram.
 		totalProcessingTimeMicroseconds.Add(metrics.Duration.Microseconds())
 		totalResponsesSentByStatus.Add(strconv.Itoa(metrics.Code), 1)
+		requestDurationSeconds.Observe(metrics.Duration.Seconds())
+
+		// Log the request, if the access log is enabled.
+		if app.config.log.access {
+			app.logAccess(w, r, metrics)
+		}
+	})
+}
+
+// logAccess writes an access log entry for a completed request, with its method, path, status code, response size,
+// client IP, duration, and request ID. The query string is logged too, except for sensitive paths.
+func (app *application) logAccess(w http.ResponseWriter, r *http.Request, metrics httpsnoop.Metrics) {
+	path := r.URL.RequestURI()
+	for _, prefix := range sensitivePathPrefixes {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			path = r.URL.EscapedPath()
+			break
+		}
+	}
+
+	app.logger.PrintInfo("request completed", map[string]string{
+		"method":      r.Method,
+		"path":        path,
+		"status":      strconv.Itoa(metrics.Code),
+		"bytes":       strconv.FormatInt(metrics.Written, 10),
+		"client_ip":   app.clientIP(r),
+		"duration_ms": strconv.FormatFloat(float64(metrics.Duration.Microseconds())/1000, 'f', 3, 64),
+		"request_id":  w.Header().Get("X-Request-ID"),
 	})
 }
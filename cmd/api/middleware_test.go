@@ -0,0 +1,116 @@
+package main
+
+import (
+	"cinevault.interimme.net/internal/jsonlog"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestVaryHeaders checks that every middleware that makes the response depend on a request header adds to the Vary
+// header through the full chain, rather than one of them replacing the values set by the others.
+func TestVaryHeaders(t *testing.T) {
+	app := &application{logger: jsonlog.New(io.Discard, jsonlog.LevelError)}
+	app.config.envelope = true
+	app.config.cors.trustedOrigins = []string{"https://example.com"}
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/livez", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	r.Header.Set("Origin", "https://example.com")
+	w := httptest.NewRecorder()
+	app.routes().ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d; want %d", w.Code, http.StatusOK)
+	}
+	vary := w.Header().Values("Vary")
+	for _, want := range []string{"Accept-Encoding", "Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers", "Authorization", "X-Envelope"} {
+		if !slices.Contains(vary, want) {
+			t.Errorf("Vary header %q is missing %q", vary, want)
+		}
+	}
+}
+
+// TestTimeout checks that a request which outlasts config.requestTimeout gets a 503 response at the deadline, even
+// when its handler ignores the request context, and that one which finishes in time gets its own response.
+func TestTimeout(t *testing.T) {
+	tests := []struct {
+		name       string
+		delay      time.Duration
+		wantStatus int
+	}{
+		{"fast handler", 0, http.StatusTeapot},
+		{"slow handler ignoring context", time.Second, http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &application{logger: jsonlog.New(io.Discard, jsonlog.LevelError)}
+			app.config.requestTimeout = 50 * time.Millisecond
+
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(tt.delay)
+				w.WriteHeader(http.StatusTeapot)
+			})
+			w := httptest.NewRecorder()
+			start := time.Now()
+			app.timeout(next).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/movies", nil))
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d; want %d", w.Code, tt.wantStatus)
+			}
+			if elapsed := time.Since(start); elapsed >= time.Second {
+				t.Errorf("response took %s; want it by the deadline", elapsed)
+			}
+		})
+	}
+}
+
+// TestRateLimitIPEmailKey checks the key that the auth endpoints are limited by, and that the handler can still read
+// the body afterwards, getting its usual error when the body is larger than it accepts.
+func TestRateLimitIPEmailKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantKey string
+		wantErr string
+	}{
+		{name: "email", body: `{"email": "Alice@Example.com"}`, wantKey: "192.0.2.1|alice@example.com"},
+		{name: "no email", body: `{"token": "abc"}`, wantKey: "192.0.2.1"},
+		{name: "not JSON", body: `email=alice@example.com`, wantKey: "192.0.2.1", wantErr: "body contains badly-formed JSON (at character 1)"},
+		{
+			name:    "oversized body",
+			body:    `{"email": "alice@example.com", "padding": "` + strings.Repeat("x", 2<<20) + `"}`,
+			wantKey: "192.0.2.1",
+			wantErr: "body must not be larger than 4096 bytes",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &application{logger: jsonlog.New(io.Discard, jsonlog.LevelError)}
+			r := httptest.NewRequest(http.MethodPost, "/v1/tokens/authentication", strings.NewReader(tt.body))
+			r.RemoteAddr = "192.0.2.1:1234"
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			if key := app.rateLimitIPEmailKey(w, r); key != tt.wantKey {
+				t.Errorf("got key %q; want %q", key, tt.wantKey)
+			}
+
+			var input map[string]string
+			err := app.readJSONLenient(w, r, &input, maxAuthBodyBytes)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("reading body after the key: %v", err)
+				}
+			} else if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("got error %v; want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -3,28 +3,69 @@ package main
 import (
 	"cinevault.interimme.net/internal/data"
 	"cinevault.interimme.net/internal/validator"
+	"context"
+	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// maxBatchSize is the maximum number of movies accepted by a single batch request.
+const maxBatchSize = 100
+
+// maxPosterBytes is the maximum size of an uploaded poster image.
+const maxPosterBytes = 5 << 20
+
+// maxSimilarMovies is the maximum number of similar movies returned for a single movie.
+const maxSimilarMovies = 20
+
+// maxTrendingMovies is the maximum number of movies returned by the trending endpoint.
+const maxTrendingMovies = 100
+
+// exportFlushInterval is the number of movies written by an export between flushes to the client.
+const exportFlushInterval = 100
+
+// movieFieldSafelist lists the movie fields that can be selected with the fields query parameter.
+var movieFieldSafelist = []string{"id", "title", "year", "release_date", "runtime", "genres", "tags", "average_rating", "poster_url", "cast", "version"}
+
+// posterExtensions maps the content types accepted for poster images to their file extensions.
+var posterExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+}
+
 // createMovieHandler handles requests to create a new movie record.
 func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
 	// Define a struct to hold the input data from the request body.
 	var input struct {
-		Title   string       `json:"title"`
-		Year    int32        `json:"year"`
-		Runtime data.Runtime `json:"runtime"`
-		Genres  []string     `json:"genres"`
+		Title       string       `json:"title"`
+		Year        int32        `json:"year"`
+		ReleaseDate *data.Date   `json:"release_date"`
+		Runtime     data.Runtime `json:"runtime"`
+		Genres      []string     `json:"genres"`
+		Tags        []string     `json:"tags"`
 	}
 
-	// Parse the JSON request body into the input struct.
-	err := app.readJSON(w, r, &input)
+	// Initialize a new validator instance.
+	v := validator.New()
+
+	// Parse the JSON request body into the input struct, collecting every field with the wrong type or format.
+	err := app.readJSONFields(w, r, &input, v)
 	if err != nil {
-		// If there's an error, respond with a 400 Bad Request error.
+		// If the body can't be parsed at all, respond with a 400 Bad Request error.
 		app.badRequestResponse(w, r, err)
 		return
 	}
+	if !v.Valid() {
+		// If any fields are malformed, respond with a 422 Unprocessable Entity error listing all of them.
+		app.failedValidationResponse(w, r, v)
+		return
+	}
 
 	// Create a new Movie struct using the input data.
 	movie := &data.Movie{
@@ -32,32 +73,149 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		Year:    input.Year,
 		Runtime: input.Runtime,
 		Genres:  input.Genres,
+		Tags:    input.Tags,
 	}
-
-	// Initialize a new validator instance.
-	v := validator.New()
+	setReleaseDate(movie, input.ReleaseDate, input.Year == 0)
 
 	// Validate the movie data.
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	err = app.validateMovie(r.Context(), v, movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
 		// If validation fails, respond with a 422 Unprocessable Entity error.
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
 	// Insert the movie record into the database.
-	err = app.models.Movies.Insert(movie)
+	err = app.models.Movies.Insert(r.Context(), movie)
 	if err != nil {
-		// If there's a server error, respond with a 500 Internal Server Error.
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrDuplicateMovie):
+			// If the movie already exists, respond with a 422 Unprocessable Entity error.
+			v.AddCodedError("title", validator.CodeDuplicate, "a movie with this title and year already exists")
+			app.failedValidationResponse(w, r, v)
+		default:
+			// If there's a server error, respond with a 500 Internal Server Error.
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
+	// Record the new movie in the audit log.
+	app.recordAudit(r, data.AuditActionCreate, data.AuditEntityMovie, movie.ID, nil, movie)
+
 	// Set the Location header for the new movie resource.
+	app.presentMovies(r, movie)
 	headers := make(http.Header)
-	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+	headers.Set("Location", "/v1/movies/"+movie.ExternalID())
 
 	// Respond with a 201 Created status and the movie data in JSON format.
-	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createMoviesBatchHandler handles requests to create several movie records in a single transaction. Every movie
+// in the batch is checked before any is inserted, and the problems with each are reported together, keyed by the
+// index of the movie in the request.
+func (app *application) createMoviesBatchHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse the JSON request body into a slice of raw objects, so that each movie can be decoded on its own and a
+	// field with the wrong type is reported against its movie rather than failing the whole request.
+	var input []map[string]json.RawMessage
+	err := app.readJSONLimited(w, r, &input, maxBatchBodyBytes)
+	if err != nil {
+		// If there's an error, respond with a 400 Bad Request error.
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Reject empty or excessively large batches.
+	if len(input) == 0 || len(input) > maxBatchSize {
+		app.badRequestResponse(w, r, fmt.Errorf("body must contain between 1 and %d movies", maxBatchSize))
+		return
+	}
+
+	// Decode, create, and validate each of the movies, collecting the errors by index.
+	movies := make([]*data.Movie, len(input))
+	batchErrors := make(map[int]*validator.Validator)
+	seen := make(map[string]bool, len(input))
+	for i, raw := range input {
+		var in struct {
+			Title       string       `json:"title"`
+			Year        int32        `json:"year"`
+			ReleaseDate *data.Date   `json:"release_date"`
+			Runtime     data.Runtime `json:"runtime"`
+			Genres      []string     `json:"genres"`
+			Tags        []string     `json:"tags"`
+		}
+
+		v := validator.New()
+		if raw == nil {
+			v.AddError("movie", "must be an object")
+			batchErrors[i] = v
+			continue
+		}
+		decodeJSONFields(raw, &in, v)
+
+		movies[i] = &data.Movie{
+			Title:   in.Title,
+			Year:    in.Year,
+			Runtime: in.Runtime,
+			Genres:  in.Genres,
+			Tags:    in.Tags,
+		}
+		setReleaseDate(movies[i], in.ReleaseDate, in.Year == 0)
+		err = app.validateMovie(r.Context(), v, movies[i])
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// Report a movie with the same title and year as an earlier one in the batch against the later one, since
+		// the database would reject it anyway.
+		key := fmt.Sprintf("%s|%d", strings.ToLower(in.Title), movies[i].Year)
+		v.CheckCoded(!seen[key], "title", validator.CodeDuplicate, "is repeated in the batch")
+		seen[key] = true
+
+		if !v.Valid() {
+			batchErrors[i] = v
+		}
+	}
+
+	if len(batchErrors) > 0 {
+		// If validation fails for any movie, respond with a 422 Unprocessable Entity error.
+		app.failedBatchValidationResponse(w, r, batchErrors)
+		return
+	}
+
+	// Insert all the movie records into the database in a single transaction.
+	err = app.models.Movies.InsertMany(r.Context(), movies)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateMovie):
+			// If any of the movies already exists, respond with a 422 Unprocessable Entity error.
+			v := validator.New()
+			v.AddCodedError("movies", validator.CodeDuplicate, "a movie with the same title and year as one of these movies already exists")
+			app.failedValidationResponse(w, r, v)
+		default:
+			// If there's a server error, respond with a 500 Internal Server Error.
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Record each new movie in the audit log.
+	for _, movie := range movies {
+		app.recordAudit(r, data.AuditActionCreate, data.AuditEntityMovie, movie.ID, nil, movie)
+	}
+
+	// Respond with a 201 Created status and the created movies, in the same order as the request.
+	app.presentMovies(r, movies...)
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"movies": movies}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -66,15 +224,28 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 // showMovieHandler handles requests to retrieve a specific movie by ID.
 func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request) {
 	// Extract the movie ID from the URL parameters.
-	id, err := app.readIDParam(r)
+	id, ok := app.readMovieIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	// Read the fields to include in the response, responding with a 400 Bad Request error if any are unknown.
+	fields, err := app.readFields(r.URL.Query(), movieFieldSafelist)
 	if err != nil {
-		// If the ID is invalid, respond with a 404 Not Found error.
-		app.notFoundResponse(w, r)
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Check whether the cast should be embedded in the response.
+	v := validator.New()
+	includeCast := app.readIncludeCast(r, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
 	// Retrieve the movie from the database.
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -87,8 +258,166 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// Count the view in the background, so that the response isn't held up by the write. The request context is
+	// cancelled once the response has been sent, so the update runs without it.
+	app.background(func() {
+		err := app.models.Movies.IncrementViews(context.Background(), movie.ID)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"movie_id": strconv.FormatInt(movie.ID, 10)})
+		}
+	})
+
+	// Embed the cast if it was requested. Otherwise, set the ETag header, and respond with 304 Not Modified if the
+	// client already has this version. Changes to the cast don't change the movie's version, so no ETag is sent
+	// when the cast is included.
+	headers := make(http.Header)
+	if includeCast {
+		err = app.loadCast(r.Context(), movie)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	} else {
+		if !app.checkPrecondition(w, r, movie.Version, movie.ID) {
+			return
+		}
+		headers.Set("ETag", etagFor(movie.Version, movie.ID))
+	}
+
+	// Reduce the movie to the requested fields.
+	app.presentMovies(r, movie)
+	selected, err := selectFields(movie, fields)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	// Respond with a 200 OK status and the movie data in JSON format.
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"movie": selected}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// countMoviesHandler handles requests for the number of movies matching the same title, genre, and year filters as
+// listMoviesHandler, so that clients can size a paginator without fetching a page of movies.
+func (app *application) countMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	// Define a struct to hold the input data from the URL query string.
+	var input struct {
+		Title      string
+		TitleMatch string
+		Genres     []string
+		data.Filters
+	}
+
+	// Initialize a new validator instance.
+	v := validator.New()
+	qs := r.URL.Query()
+
+	// Read query parameters for filtering. Nothing is paginated or sorted, so the page, page size, and sort are
+	// fixed at values that satisfy ValidateFilters.
+	input.Title = app.readString(qs, "title", "")
+	input.TitleMatch = app.readString(qs, "title_match", data.TitleMatchFulltext)
+	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.Filters.Page = 1
+	input.Filters.PageSize = 1
+	input.Filters.Sort = "id"
+	input.Filters.YearMin = app.readInt(qs, "year_min", 0, v)
+	input.Filters.YearMax = app.readInt(qs, "year_max", 0, v)
+	input.Filters.Tags = app.readCSV(qs, "tags", []string{})
+	input.Filters.Search = app.readString(qs, "q", "")
+	input.Filters.SortSafelist = data.MovieSortSafelist
+
+	// Validate the title matching mode, the length of the title search term, and the filters.
+	v.Check(validator.In(input.TitleMatch, data.TitleMatchSafelist...), "title_match", "invalid title_match value")
+	data.ValidateTitleSearch(v, input.Title)
+	data.ValidateSearch(v, input.Filters.Search)
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Count the matching movies in the database.
+	count, err := app.models.Movies.Count(r.Context(), input.Title, input.TitleMatch, input.Genres, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Respond with a 200 OK status and the count in JSON format.
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"count": count}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listTrendingMoviesHandler handles requests to list the most viewed movies in the catalog.
+func (app *application) listTrendingMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	// Read and validate the optional limit query string parameter.
+	v := validator.New()
+	limit := app.readInt(r.URL.Query(), "limit", 10, v)
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Checkf(limit <= maxTrendingMovies, "limit", "must be a maximum of %d", maxTrendingMovies)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Retrieve the most viewed movies.
+	movies, err := app.models.Movies.GetTrending(r.Context(), limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Respond with a 200 OK status and the list of trending movies in JSON format.
+	app.presentMovies(r, movies...)
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"movies": movies}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listSimilarMoviesHandler handles requests to list the movies that share the most genres with a specific movie.
+func (app *application) listSimilarMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	// Extract the movie ID from the URL parameters.
+	id, ok := app.readMovieIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	// Read and validate the optional limit query string parameter.
+	v := validator.New()
+	limit := app.readInt(r.URL.Query(), "limit", 10, v)
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Checkf(limit <= maxSimilarMovies, "limit", "must be a maximum of %d", maxSimilarMovies)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Make sure the movie exists, so that an unknown movie is a 404 rather than an empty list.
+	_, err := app.models.Movies.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Retrieve the similar movies.
+	movies, err := app.models.Movies.GetSimilar(r.Context(), id, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Respond with a 200 OK status and the list of similar movies in JSON format.
+	app.presentMovies(r, movies...)
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"movies": movies}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -97,15 +426,13 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 // updateMovieHandler handles requests to update an existing movie record.
 func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Request) {
 	// Extract the movie ID from the URL parameters.
-	id, err := app.readIDParam(r)
-	if err != nil {
-		// If the ID is invalid, respond with a 404 Not Found error.
-		app.notFoundResponse(w, r)
+	id, ok := app.readMovieIDParam(w, r)
+	if !ok {
 		return
 	}
 
 	// Retrieve the existing movie from the database.
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -118,53 +445,84 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Define a struct to hold the input data from the request body.
+	// If the client sent an If-Match header, make sure it refers to the current version of the movie.
+	if !app.checkPrecondition(w, r, movie.Version, movie.ID) {
+		return
+	}
+
+	// Keep a copy of the movie as it was before the update, for the audit log.
+	original := *movie
+
+	// Define a struct to hold the input data from the request body. The request is a JSON merge patch: omitted
+	// fields are left nil and stay unchanged, while fields set to null are cleared, which readJSONFields turns into
+	// pointers to zero values and, for genres and tags, an empty slice. Apart from the release date and tags, every
+	// movie field is required, so clearing one then fails validation.
 	var input struct {
-		Title   *string       `json:"title"`
-		Year    *int32        `json:"year"`
-		Runtime *data.Runtime `json:"runtime"`
-		Genres  []string      `json:"genres"`
+		Title       *string       `json:"title"`
+		Year        *int32        `json:"year"`
+		ReleaseDate *data.Date    `json:"release_date"`
+		Runtime     *data.Runtime `json:"runtime"`
+		Genres      []string      `json:"genres"`
+		Tags        []string      `json:"tags"`
 	}
 
-	// Parse the JSON request body into the input struct.
-	err = app.readJSON(w, r, &input)
+	// Parse the JSON request body into the input struct, collecting every field with the wrong type or format.
+	v := validator.New()
+	err = app.readJSONFields(w, r, &input, v)
 	if err != nil {
-		// If there's an error, respond with a 400 Bad Request error.
+		// If the body can't be parsed at all, respond with a 400 Bad Request error.
 		app.badRequestResponse(w, r, err)
 		return
 	}
+	if !v.Valid() {
+		// If any fields are malformed, respond with a 422 Unprocessable Entity error listing all of them.
+		app.failedValidationResponse(w, r, v)
+		return
+	}
 
-	// Update the movie fields if the input data is provided.
+	// Update the movie fields that are present in the input, whether set to a value or cleared with null.
 	if input.Title != nil {
 		movie.Title = *input.Title
 	}
 	if input.Year != nil {
 		movie.Year = *input.Year
 	}
+	if input.ReleaseDate != nil {
+		setReleaseDate(movie, input.ReleaseDate, input.Year == nil)
+	}
 	if input.Runtime != nil {
 		movie.Runtime = *input.Runtime
 	}
 	if input.Genres != nil {
 		movie.Genres = input.Genres
 	}
-
-	// Initialize a new validator instance.
-	v := validator.New()
+	if input.Tags != nil {
+		movie.Tags = input.Tags
+	}
 
 	// Validate the updated movie data.
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	err = app.validateMovie(r.Context(), v, movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
 		// If validation fails, respond with a 422 Unprocessable Entity error.
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
 	// Update the movie record in the database.
-	err = app.models.Movies.Update(movie)
+	err = app.models.Movies.Update(r.Context(), movie)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
 			// If there is an edit conflict, respond with a 409 Conflict error.
 			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateMovie):
+			// If another movie has the same title and year, respond with a 422 Unprocessable Entity error.
+			v.AddCodedError("title", validator.CodeDuplicate, "a movie with this title and year already exists")
+			app.failedValidationResponse(w, r, v)
 		default:
 			// For any other errors, respond with a 500 Internal Server Error.
 			app.serverErrorResponse(w, r, err)
@@ -172,25 +530,178 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// Record the change in the audit log.
+	app.recordAudit(r, data.AuditActionUpdate, data.AuditEntityMovie, movie.ID, &original, movie)
+
+	headers := make(http.Header)
+	headers.Set("ETag", etagFor(movie.Version, movie.ID))
+
+	// Respond with a 200 OK status and the updated movie data in JSON format.
+	app.presentMovies(r, movie)
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// uploadMoviePosterHandler handles multipart requests to upload a poster image for a specific movie. The image is
+// read from the "poster" form field, and must be a JPEG or PNG file no larger than maxPosterBytes.
+func (app *application) uploadMoviePosterHandler(w http.ResponseWriter, r *http.Request) {
+	// Extract the movie ID from the URL parameters.
+	id, ok := app.readMovieIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	// Retrieve the existing movie from the database.
+	movie, err := app.models.Movies.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// If the client sent an If-Match header, make sure it refers to the current version of the movie.
+	if !app.checkPrecondition(w, r, movie.Version, movie.ID) {
+		return
+	}
+
+	v := validator.New()
+
+	// Limit the size of the request body, leaving some room for the multipart headers.
+	r.Body = http.MaxBytesReader(w, r.Body, maxPosterBytes+1_048_576)
+
+	// Retrieve the uploaded file from the "poster" form field.
+	file, header, err := r.FormFile("poster")
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			v.AddErrorf("poster", "must not be larger than %d bytes", maxPosterBytes)
+			app.failedValidationResponse(w, r, v)
+		case errors.Is(err, http.ErrMissingFile):
+			v.AddError("poster", "must be provided")
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	defer file.Close()
+
+	v.Checkf(header.Size <= maxPosterBytes, "poster", "must not be larger than %d bytes", maxPosterBytes)
+
+	// Sniff the content type from the first 512 bytes rather than trusting the client-supplied header.
+	sniff := make([]byte, 512)
+	n, err := io.ReadFull(file, sniff)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	extension, ok := posterExtensions[http.DetectContentType(sniff[:n])]
+	v.Check(ok, "poster", "must be a JPEG or PNG image")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Rewind the file, since the sniffed bytes have already been consumed.
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Store the poster under a random name so that a replaced poster is never served from a stale cache.
+	name, err := newUUID()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	posterURL, err := app.storage.Save(r.Context(), fmt.Sprintf("movie-%d-%s%s", movie.ID, name, extension), file)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Record the poster URL against the movie, keeping a copy of the movie as it was for the audit log.
+	original := *movie
+	movie.PosterURL = posterURL
+	err = app.models.Movies.Update(r.Context(), movie)
+	if err != nil {
+		// The movie doesn't refer to the new file, so remove it again, even if the request has been cancelled.
+		app.deletePoster(r, posterURL)
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Now that no movie refers to it any more, remove the poster that was replaced.
+	if original.PosterURL != "" {
+		app.deletePoster(r, original.PosterURL)
+	}
+
+	// Record the change in the audit log.
+	app.recordAudit(r, data.AuditActionUpdate, data.AuditEntityMovie, movie.ID, &original, movie)
+
+	headers := make(http.Header)
+	headers.Set("ETag", etagFor(movie.Version, movie.ID))
+
 	// Respond with a 200 OK status and the updated movie data in JSON format.
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	app.presentMovies(r, movie)
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"movie": movie}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// deletePoster removes a poster file from storage, logging rather than reporting a failure, since by then the
+// movie itself has been dealt with and the leftover file only takes up space.
+func (app *application) deletePoster(r *http.Request, posterURL string) {
+	err := app.storage.Delete(context.WithoutCancel(r.Context()), posterURL)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
 // deleteMovieHandler handles requests to delete a specific movie by ID.
 func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Request) {
 	// Extract the movie ID from the URL parameters.
-	id, err := app.readIDParam(r)
+	id, ok := app.readMovieIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	// Retrieve the movie before deleting it, so that its final state can be recorded in the audit log.
+	movie, err := app.models.Movies.Get(r.Context(), id)
 	if err != nil {
-		// If the ID is invalid, respond with a 404 Not Found error.
-		app.notFoundResponse(w, r)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			// If the movie is not found, respond with a 404 Not Found error.
+			app.notFoundResponse(w, r)
+		default:
+			// For any other errors, respond with a 500 Internal Server Error.
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// If the client sent an If-Match header, make sure it refers to the current version of the movie.
+	if !app.checkPrecondition(w, r, movie.Version, movie.ID) {
 		return
 	}
 
 	// Delete the movie from the database.
-	err = app.models.Movies.Delete(id)
+	err = app.models.Movies.Delete(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -203,19 +714,107 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// Record the deletion in the audit log.
+	app.recordAudit(r, data.AuditActionDelete, data.AuditEntityMovie, movie.ID, movie, nil)
+
 	// Respond with a 200 OK status and a message indicating successful deletion.
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteMoviesHandler handles requests to delete several movies at once, listed by ID in the request body, in a
+// single transaction. IDs with no movie are counted rather than failing the request, so that the response reports
+// how many of the movies were deleted and how many were not found. With movie UUIDs enabled, the IDs are UUIDs.
+func (app *application) deleteMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	// Read the IDs, which are strings when movies are identified by UUID, resolving each UUID to its movie's ID.
+	var ids []int64
+	requested := 0
+	if app.config.movieUUIDs {
+		var input struct {
+			IDs []string `json:"ids"`
+		}
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		if v := validateMovieIDList(input.IDs); !v.Valid() {
+			app.failedValidationResponse(w, r, v)
+			return
+		}
+		requested = len(input.IDs)
+		for _, uuid := range input.IDs {
+			id, err := app.models.Movies.GetIDForUUID(r.Context(), uuid)
+			if err != nil {
+				if errors.Is(err, data.ErrRecordNotFound) {
+					continue // Counted as not found below.
+				}
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			ids = append(ids, id)
+		}
+	} else {
+		var input struct {
+			IDs []int64 `json:"ids"`
+		}
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		if v := validateMovieIDList(input.IDs); !v.Valid() {
+			app.failedValidationResponse(w, r, v)
+			return
+		}
+		requested = len(input.IDs)
+		ids = input.IDs
+	}
+
+	// Delete the movies in a single statement, getting back those that existed.
+	movies, err := app.models.Movies.DeleteMany(r.Context(), ids)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Record each deletion in the audit log.
+	for _, movie := range movies {
+		app.recordAudit(r, data.AuditActionDelete, data.AuditEntityMovie, movie.ID, movie, nil)
+	}
+
+	// Respond with a 200 OK status and the numbers of movies deleted and not found.
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"deleted": len(movies), "not_found": requested - len(movies)}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// validateMovieIDList checks the list of movie IDs sent to deleteMoviesHandler, which must contain between 1 and
+// maxBatchSize distinct IDs.
+func validateMovieIDList[T comparable](ids []T) *validator.Validator {
+	v := validator.New()
+	v.CheckCoded(len(ids) >= 1, "ids", validator.CodeTooFew, "must contain at least 1 id")
+	v.CheckCodedf(len(ids) <= maxBatchSize, "ids", validator.CodeTooMany, "must not contain more than %d ids", maxBatchSize)
+	v.CheckCoded(validator.Unique(ids), "ids", validator.CodeDuplicate, "must not contain duplicate values")
+	return v
+}
+
 // listMoviesHandler handles requests to list all movies with optional filtering, sorting, and pagination.
 func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	app.listMovies(w, r, app.readCSV(r.URL.Query(), "genres", []string{}))
+}
+
+// listMovies responds with a page of the movies that have all of the given genres and match the filters in the
+// query string, for listMoviesHandler and listGenreMoviesHandler.
+func (app *application) listMovies(w http.ResponseWriter, r *http.Request, genres []string) {
 	// Define a struct to hold the input data from the URL query string.
 	var input struct {
-		Title  string
-		Genres []string
+		Title      string
+		TitleMatch string
+		Genres     []string
 		data.Filters
 	}
 
@@ -225,30 +824,250 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 
 	// Read query parameters for filtering and pagination.
 	input.Title = app.readString(qs, "title", "")
-	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.TitleMatch = app.readString(qs, "title_match", data.TitleMatchFulltext)
+	input.Genres = genres
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
-	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+	input.Filters.YearMin = app.readInt(qs, "year_min", 0, v)
+	input.Filters.YearMax = app.readInt(qs, "year_max", 0, v)
+	input.Filters.Tags = app.readCSV(qs, "tags", []string{})
+	input.Filters.Search = app.readString(qs, "q", "")
+	input.Filters.Sort = app.readString(qs, "sort", defaultMovieSort(input.Filters.Search))
+	input.Filters.SortSafelist = data.MovieSortSafelist
+
+	// Check the response format, which defaults to JSON.
+	format := app.readString(qs, "format", "json")
+	if format != "json" && format != "csv" {
+		app.badRequestResponse(w, r, fmt.Errorf("unsupported format %q, must be json or csv", format))
+		return
+	}
+
+	// Read the fields to include for each movie, responding with a 400 Bad Request error if any are unknown.
+	fields, err := app.readFields(qs, movieFieldSafelist)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Check whether the cast of each movie should be embedded in the response.
+	includeCast := app.readIncludeCast(r, v)
 
-	// Validate the filters.
+	// Validate the title matching mode, the length of the title search term, and the filters.
+	v.Check(validator.In(input.TitleMatch, data.TitleMatchSafelist...), "title_match", "invalid title_match value")
+	data.ValidateTitleSearch(v, input.Title)
+	data.ValidateSearch(v, input.Filters.Search)
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		// If validation fails, respond with a 422 Unprocessable Entity error.
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
 	// Retrieve the list of movies from the database using the filters.
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movies, metadata, err := app.models.Movies.GetAll(r.Context(), input.Title, input.TitleMatch, input.Genres, input.Filters)
 	if err != nil {
-		// For any server error, respond with a 500 Internal Server Error.
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrInvalidSort):
+			// If the sort parameter slipped past validation, respond with a 422 Unprocessable Entity error.
+			v.AddError("sort", "invalid sort value")
+			app.failedValidationResponse(w, r, v)
+		case errors.Is(err, data.ErrInvalidCursor):
+			v.AddError("cursor", "invalid cursor value")
+			app.failedValidationResponse(w, r, v)
+		default:
+			// For any other error, respond with a 500 Internal Server Error.
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Identify the movies by UUID if movie UUIDs are enabled, in either format.
+	app.presentMovies(r, movies...)
+
+	// If CSV was requested, write the page of movies as a spreadsheet-friendly attachment instead of JSON.
+	if format == "csv" {
+		err = writeMoviesCSV(w, movies)
+		if err != nil {
+			app.logError(r, err)
+		}
 		return
 	}
 
+	// Embed the casts of the movies if they were requested.
+	if includeCast {
+		err = app.loadCast(r.Context(), movies...)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// Reduce each movie to the requested fields.
+	selected := make([]interface{}, len(movies))
+	for i, movie := range movies {
+		selected[i], err = selectFields(movie, fields)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// Add links to the neighbouring pages, based on the URL of this request.
+	metadata.SetPageURLs(r.URL)
+
 	// Respond with a 200 OK status and the list of movies along with metadata in JSON format.
-	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"movies": selected, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// exportMoviesHandler handles requests to export every movie matching the filters as newline-delimited JSON, one
+// movie per line. The movies are streamed from the database to the client as they are read, so memory use does
+// not grow with the size of the catalog.
+func (app *application) exportMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	// Define a struct to hold the input data from the URL query string.
+	var input struct {
+		Title      string
+		TitleMatch string
+		Genres     []string
+		data.Filters
+	}
+
+	// Initialize a new validator instance.
+	v := validator.New()
+	qs := r.URL.Query()
+
+	// Read query parameters for filtering and sorting. The export isn't paginated, so the page and page size
+	// are fixed at values that satisfy ValidateFilters.
+	input.Title = app.readString(qs, "title", "")
+	input.TitleMatch = app.readString(qs, "title_match", data.TitleMatchFulltext)
+	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.Filters.Page = 1
+	input.Filters.PageSize = 1
+	input.Filters.YearMin = app.readInt(qs, "year_min", 0, v)
+	input.Filters.YearMax = app.readInt(qs, "year_max", 0, v)
+	input.Filters.Tags = app.readCSV(qs, "tags", []string{})
+	input.Filters.Search = app.readString(qs, "q", "")
+	input.Filters.Sort = app.readString(qs, "sort", defaultMovieSort(input.Filters.Search))
+	input.Filters.SortSafelist = data.MovieSortSafelist
+
+	// Validate the title matching mode, the length of the title search term, and the filters.
+	v.Check(validator.In(input.TitleMatch, data.TitleMatchSafelist...), "title_match", "invalid title_match value")
+	data.ValidateTitleSearch(v, input.Title)
+	data.ValidateSearch(v, input.Filters.Search)
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// The export can take longer than the server's write timeout allows for ordinary responses, so lift it for
+	// this response. This is not supported by every ResponseWriter, in which case the timeout still applies.
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	// Write each movie as a line of JSON, flushing periodically so that the client receives the export as it
+	// is produced. Nothing is written until the first movie arrives, so that errors from the query itself can
+	// still be reported with an ordinary error response.
+	enc := json.NewEncoder(w)
+	count := 0
+	err := app.models.Movies.Export(r.Context(), input.Title, input.TitleMatch, input.Genres, input.Filters, func(movie *data.Movie) error {
+		if count == 0 {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.WriteHeader(http.StatusOK)
+		}
+		count++
+
+		app.presentMovies(r, movie)
+		if err := enc.Encode(movie); err != nil {
+			return err
+		}
+		if count%exportFlushInterval == 0 {
+			return rc.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		if count > 0 {
+			// The response has already started, so the best we can do is log the error and cut the export short.
+			app.logError(r, err)
+			return
+		}
+		switch {
+		case errors.Is(err, data.ErrInvalidSort):
+			v.AddError("sort", "invalid sort value")
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// If no movies matched, send an empty body.
+	if count == 0 {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// defaultMovieSort returns the order movies are listed in when the client doesn't give one: by relevance, with the
+// best matches first, if there are general search terms, and by ID otherwise.
+func defaultMovieSort(search string) string {
+	if search != "" {
+		return "-relevance"
+	}
+	return "id"
+}
+
+// setReleaseDate sets the release date of a movie from the input, where a zero date, decoded from null, clears it.
+// If deriveYear is true, because the input has no year, the movie's year is set to the year of the release date, so
+// that clients can give either.
+func setReleaseDate(movie *data.Movie, date *data.Date, deriveYear bool) {
+	if date == nil || date.IsZero() {
+		movie.ReleaseDate = nil
+		return
+	}
+	movie.ReleaseDate = date
+	if deriveYear {
+		movie.Year = int32(date.Year())
+	}
+}
+
+// writeMoviesCSV writes movies to the response as a CSV attachment with the columns id, title, year, runtime, and
+// genres. The runtime is given in minutes, and genres are joined with a pipe so they don't clash with the commas
+// separating the columns.
+func writeMoviesCSV(w http.ResponseWriter, movies []*data.Movie) error {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="movies.csv"`)
+	w.WriteHeader(http.StatusOK)
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "title", "year", "runtime", "genres"})
+	for _, movie := range movies {
+		cw.Write([]string{
+			movie.ExternalID(),
+			movie.Title,
+			strconv.Itoa(int(movie.Year)),
+			strconv.Itoa(int(movie.Runtime)),
+			strings.Join(movie.Genres, "|"),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// validateMovie validates the movie data with data.ValidateMovie and, when strict genres are enabled, also checks
+// the genres against the canonical list. The returned error is only for failures loading that list; validation
+// failures are recorded in v.
+func (app *application) validateMovie(ctx context.Context, v *validator.Validator, movie *data.Movie) error {
+	data.ValidateMovie(v, movie)
+
+	if app.config.genres.strict {
+		canonical, err := app.models.Genres.GetAllNames(ctx)
+		if err != nil {
+			return err
+		}
+		data.ValidateMovieGenres(v, movie.Genres, canonical)
+	}
+	return nil
+}
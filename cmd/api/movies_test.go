@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"cinevault.interimme.net/internal/data"
+	"cinevault.interimme.net/internal/data/mock"
+	"cinevault.interimme.net/internal/jsonlog"
+	"cinevault.interimme.net/internal/storage"
+	"context"
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestApplication returns an application using the given models, with the settings the handlers need to run
+// outside of main.
+func newTestApplication(models data.Models) *application {
+	app := &application{
+		logger: jsonlog.New(io.Discard, jsonlog.LevelError),
+		models: models,
+		jobs:   make(chan func(), 10),
+	}
+	app.config.envelope = true
+	app.config.maxBodyBytes = 1 << 20
+	return app
+}
+
+// newMovieRequest returns a request for the movie with the given ID, as the router would pass it to a handler, made
+// by an activated user.
+func newMovieRequest(app *application, method, id, body string) *http.Request {
+	r := httptest.NewRequest(method, "/v1/movies/"+id, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	r = r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, httprouter.Params{{Key: "id", Value: id}}))
+	return app.contextSetUser(r, &data.User{ID: 1, Activated: true})
+}
+
+// testMovie returns a movie with every field set to a valid value.
+func testMovie() *data.Movie {
+	releaseDate := data.NewDate(2010, 7, 16)
+	return &data.Movie{
+		ID:          1,
+		Title:       "Inception",
+		Year:        2010,
+		ReleaseDate: &releaseDate,
+		Runtime:     148,
+		Genres:      []string{"sci-fi"},
+		Tags:        []string{"dreams"},
+		Version:     1,
+	}
+}
+
+func TestUpdateMovieHandlerNullFields(t *testing.T) {
+	tests := []struct {
+		field   string
+		status  int
+		message string // Expected validation error for the field, if any.
+	}{
+		{field: "title", status: http.StatusUnprocessableEntity, message: "must be provided"},
+		{field: "year", status: http.StatusUnprocessableEntity, message: "must be provided"},
+		{field: "release_date", status: http.StatusOK},
+		{field: "runtime", status: http.StatusUnprocessableEntity, message: "must be provided"},
+		{field: "genres", status: http.StatusUnprocessableEntity, message: "must contain at least 1 genre"},
+		{field: "tags", status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			var updated *data.Movie
+			models := mock.NewModels()
+			models.Movies = mock.MovieModel{
+				GetFunc: func(ctx context.Context, id int64) (*data.Movie, error) {
+					return testMovie(), nil
+				},
+				UpdateFunc: func(ctx context.Context, movie *data.Movie) error {
+					updated = movie
+					return nil
+				},
+			}
+			app := newTestApplication(models)
+
+			w := httptest.NewRecorder()
+			app.updateMovieHandler(w, newMovieRequest(app, http.MethodPatch, "1", `{"`+tt.field+`": null}`))
+
+			if w.Code != tt.status {
+				t.Fatalf("got status %d; want %d; body %s", w.Code, tt.status, w.Body)
+			}
+			if tt.message != "" {
+				var body struct {
+					Error map[string]string `json:"error"`
+				}
+				err := json.Unmarshal(w.Body.Bytes(), &body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.Contains(body.Error[tt.field], tt.message) {
+					t.Errorf("got error %q for %s; want %q", body.Error[tt.field], tt.field, tt.message)
+				}
+				return
+			}
+
+			// Clearing an optional field removes its value, while leaving the other fields as they were.
+			switch tt.field {
+			case "release_date":
+				if updated.ReleaseDate != nil || updated.Year != 2010 {
+					t.Errorf("got release date %v and year %d; want none and 2010", updated.ReleaseDate, updated.Year)
+				}
+			case "tags":
+				if updated.Tags == nil || len(updated.Tags) != 0 {
+					t.Errorf("got tags %#v; want an empty list", updated.Tags)
+				}
+			}
+			if updated.Title != "Inception" {
+				t.Errorf("got title %q; want the title to stay unchanged", updated.Title)
+			}
+		})
+	}
+}
+
+func TestUpdateMovieHandlerOmittedFields(t *testing.T) {
+	var updated *data.Movie
+	models := mock.NewModels()
+	models.Movies = mock.MovieModel{
+		GetFunc: func(ctx context.Context, id int64) (*data.Movie, error) {
+			return testMovie(), nil
+		},
+		UpdateFunc: func(ctx context.Context, movie *data.Movie) error {
+			updated = movie
+			return nil
+		},
+	}
+	app := newTestApplication(models)
+
+	w := httptest.NewRecorder()
+	app.updateMovieHandler(w, newMovieRequest(app, http.MethodPatch, "1", `{"title": "Inception (Director's Cut)"}`))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d; want %d; body %s", w.Code, http.StatusOK, w.Body)
+	}
+	want := testMovie()
+	want.Title = "Inception (Director's Cut)"
+	got, _ := json.Marshal(updated)
+	wantJSON, _ := json.Marshal(want)
+	if !bytes.Equal(got, wantJSON) {
+		t.Errorf("got movie %s; want %s", got, wantJSON)
+	}
+}
+
+func TestShowMovieHandler(t *testing.T) {
+	models := mock.NewModels()
+	models.Movies = mock.MovieModel{
+		GetFunc: func(ctx context.Context, id int64) (*data.Movie, error) {
+			if id != 1 {
+				return nil, data.ErrRecordNotFound
+			}
+			return testMovie(), nil
+		},
+	}
+	app := newTestApplication(models)
+
+	tests := []struct {
+		name   string
+		id     string
+		status int
+		title  string
+	}{
+		{name: "existing movie", id: "1", status: http.StatusOK, title: "Inception"},
+		{name: "missing movie", id: "2", status: http.StatusNotFound},
+		{name: "invalid ID", id: "abc", status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			app.showMovieHandler(w, newMovieRequest(app, http.MethodGet, tt.id, ""))
+
+			if w.Code != tt.status {
+				t.Fatalf("got status %d; want %d; body %s", w.Code, tt.status, w.Body)
+			}
+			if tt.title == "" {
+				return
+			}
+			var body struct {
+				Movie data.Movie `json:"movie"`
+			}
+			err := json.Unmarshal(w.Body.Bytes(), &body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if body.Movie.Title != tt.title {
+				t.Errorf("got title %q; want %q", body.Movie.Title, tt.title)
+			}
+			if etag := w.Header().Get("ETag"); etag != `W/"1-1"` {
+				t.Errorf("got ETag %q; want %q", etag, `W/"1-1"`)
+			}
+		})
+	}
+}
+
+// TestUploadMoviePosterHandlerCleanup checks that the poster files left in storage are those the movie refers to:
+// the new file is removed if the movie can't be updated, and the replaced one once it has been.
+func TestUploadMoviePosterHandlerCleanup(t *testing.T) {
+	tests := []struct {
+		name      string
+		updateErr error
+		status    int
+		wantOld   bool
+	}{
+		{name: "update succeeds", status: http.StatusOK},
+		{name: "edit conflict", updateErr: data.ErrEditConflict, status: http.StatusConflict, wantOld: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			store, err := storage.NewLocal(dir, "/uploads")
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = os.WriteFile(filepath.Join(dir, "old.png"), []byte("old"), 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var newURL string
+			models := mock.NewModels()
+			models.Movies = mock.MovieModel{
+				GetFunc: func(ctx context.Context, id int64) (*data.Movie, error) {
+					movie := testMovie()
+					movie.PosterURL = "/uploads/old.png"
+					return movie, nil
+				},
+				UpdateFunc: func(ctx context.Context, movie *data.Movie) error {
+					newURL = movie.PosterURL
+					return tt.updateErr
+				},
+			}
+			app := newTestApplication(models)
+			app.storage = store
+
+			var body bytes.Buffer
+			mw := multipart.NewWriter(&body)
+			part, err := mw.CreateFormFile("poster", "poster.png")
+			if err != nil {
+				t.Fatal(err)
+			}
+			part.Write([]byte("\x89PNG\r\n\x1a\n poster"))
+			mw.Close()
+
+			r := newMovieRequest(app, http.MethodPut, "1", "")
+			r.Body = io.NopCloser(&body)
+			r.Header.Set("Content-Type", mw.FormDataContentType())
+			w := httptest.NewRecorder()
+			app.uploadMoviePosterHandler(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("got status %d; want %d; body %s", w.Code, tt.status, w.Body)
+			}
+			want := []string{filepath.Base(newURL)}
+			if tt.wantOld {
+				want = []string{"old.png"}
+			}
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, entry := range entries {
+				got = append(got, entry.Name())
+			}
+			if strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("got files %q; want %q", got, want)
+			}
+		})
+	}
+}
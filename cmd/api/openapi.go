@@ -0,0 +1,48 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// openAPISpec holds the hand-maintained OpenAPI 3 description of the API. It must be kept up to date as routes
+// are added or changed in routes.go.
+//
+//go:embed "openapi.json"
+var openAPISpec []byte
+
+// openAPIHandler returns a handler that serves the OpenAPI description, with info.version set to the version
+// of the running binary. The document is prepared on first use and reused for every request after that.
+func (app *application) openAPIHandler() http.HandlerFunc {
+	var (
+		once sync.Once
+		js   []byte
+		err  error
+	)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() {
+			var spec map[string]interface{}
+			err = json.Unmarshal(openAPISpec, &spec)
+			if err != nil {
+				return
+			}
+
+			// Inject the build version, when there is one, into the info object.
+			if info, ok := spec["info"].(map[string]interface{}); ok && version != "" {
+				info["version"] = version
+			}
+
+			js, err = json.MarshalIndent(spec, "", "\t")
+		})
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(js)
+	}
+}
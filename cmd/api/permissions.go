@@ -0,0 +1,88 @@
+package main
+
+import (
+	"cinevault.interimme.net/internal/data"
+	"cinevault.interimme.net/internal/validator"
+	"context"
+	"errors"
+	"net/http"
+)
+
+// grantUserPermissionsHandler handles requests from administrators to grant permission codes to a user.
+func (app *application) grantUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	app.changeUserPermissions(w, r, app.models.Permissions.AddForUser)
+}
+
+// revokeUserPermissionsHandler handles requests from administrators to revoke permission codes from a user.
+func (app *application) revokeUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	app.changeUserPermissions(w, r, app.models.Permissions.RemoveForUser)
+}
+
+// changeUserPermissions reads a list of permission codes from the request body, checks that each of them exists,
+// and applies change to the user identified by the URL. It responds with the user's permissions afterwards.
+func (app *application) changeUserPermissions(w http.ResponseWriter, r *http.Request, change func(ctx context.Context, userID int64, codes ...string) error) {
+	// Extract the user ID from the URL parameters.
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Define a struct to hold the permission codes from the request body.
+	var input struct {
+		Permissions []string `json:"permissions"`
+	}
+
+	// Parse the JSON request body into the input struct.
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Retrieve the permission codes defined in the database, to validate the input against.
+	known, err := app.models.Permissions.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Check that at least one code was given, and that every code is a known permission.
+	v := validator.New()
+	if data.ValidatePermissionCodes(v, input.Permissions, known); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Make sure the user exists, so that an unknown user is a 404 rather than a silent no-op.
+	_, err = app.models.Users.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Grant or revoke the permissions.
+	err = change(r.Context(), id, input.Permissions...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Retrieve the user's permissions as they are now.
+	permissions, err := app.userPermissions(r, id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Respond with a 200 OK status and the user's permission codes in JSON format.
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// histogram is a minimal cumulative histogram of observed values, in the style of a Prometheus histogram. It
+// implements expvar.Var so that it can be published alongside the other expvar metrics.
+type histogram struct {
+	mu      sync.Mutex // Mutex to protect the fields below
+	bounds  []float64  // Upper bounds of the buckets, in ascending order
+	buckets []uint64   // Number of observations less than or equal to each bound
+	sum     float64    // Sum of all observed values
+	count   uint64     // Total number of observations
+}
+
+// newHistogram returns a histogram with the given bucket upper bounds, which must be in ascending order.
+func newHistogram(bounds ...float64) *histogram {
+	return &histogram{
+		bounds:  bounds,
+		buckets: make([]uint64, len(bounds)),
+	}
+}
+
+// Observe records a single value in the histogram.
+func (h *histogram) Observe(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, bound := range h.bounds {
+		if value <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+// snapshot returns a consistent copy of the histogram's buckets, sum, and count.
+func (h *histogram) snapshot() ([]uint64, float64, uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	buckets := make([]uint64, len(h.buckets))
+	copy(buckets, h.buckets)
+	return buckets, h.sum, h.count
+}
+
+// String implements the expvar.Var interface, rendering the histogram as a JSON object.
+func (h *histogram) String() string {
+	buckets, sum, count := h.snapshot()
+
+	counts := make(map[string]uint64, len(buckets))
+	for i, bound := range h.bounds {
+		counts[strconv.FormatFloat(bound, 'g', -1, 64)] = buckets[i]
+	}
+
+	js, _ := json.Marshal(map[string]interface{}{
+		"buckets": counts,
+		"sum":     sum,
+		"count":   count,
+	})
+	return string(js)
+}
+
+// prometheusHandler returns a handler that exposes the request metrics collected by the metrics middleware in
+// the Prometheus text exposition format. The values are read from the published expvar variables, so both
+// endpoints always report the same numbers.
+func (app *application) prometheusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+
+		// Write the simple counters.
+		counters := []struct {
+			name string // Name of the Prometheus metric
+			help string // Description of the metric
+			key  string // Name of the expvar variable holding the value
+		}{
+			{"cinevault_requests_received_total", "Total number of HTTP requests received.", "total_requests_received"},
+			{"cinevault_responses_sent_total", "Total number of HTTP responses sent.", "total_responses_sent"},
+			{"cinevault_processing_time_microseconds_total", "Total time spent processing requests, in microseconds.", "total_processing_time_μs"},
+			{"cinevault_movie_cache_hits_total", "Total number of movie lookups answered from the cache.", "movie_cache_hits"},
+			{"cinevault_movie_cache_misses_total", "Total number of movie lookups that missed the cache.", "movie_cache_misses"},
+			{"cinevault_permission_cache_hits_total", "Total number of permission lookups answered from the cache.", "permission_cache_hits"},
+			{"cinevault_permission_cache_misses_total", "Total number of permission lookups that missed the cache.", "permission_cache_misses"},
+		}
+		for _, c := range counters {
+			if v, ok := expvar.Get(c.key).(*expvar.Int); ok {
+				fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", c.name, c.help, c.name, c.name, v.Value())
+			}
+		}
+
+		// Write the per-status response counts, in a stable order.
+		if m, ok := expvar.Get("total_responses_sent_by_status").(*expvar.Map); ok {
+			name := "cinevault_responses_sent_by_status_total"
+			fmt.Fprintf(&buf, "# HELP %s Total number of HTTP responses sent, by status code.\n# TYPE %s counter\n", name, name)
+
+			var lines []string
+			m.Do(func(kv expvar.KeyValue) {
+				lines = append(lines, fmt.Sprintf("%s{status=%q} %s\n", name, kv.Key, kv.Value.String()))
+			})
+			sort.Strings(lines)
+			for _, line := range lines {
+				buf.WriteString(line)
+			}
+		}
+
+		// Write the request duration histogram.
+		if h, ok := expvar.Get("request_duration_seconds").(*histogram); ok {
+			name := "cinevault_request_duration_seconds"
+			fmt.Fprintf(&buf, "# HELP %s Duration of HTTP requests, in seconds.\n# TYPE %s histogram\n", name, name)
+
+			buckets, sum, count := h.snapshot()
+			for i, bound := range h.bounds {
+				fmt.Fprintf(&buf, "%s_bucket{le=\"%s\"} %d\n", name, strconv.FormatFloat(bound, 'g', -1, 64), buckets[i])
+			}
+			fmt.Fprintf(&buf, "%s_bucket{le=\"+Inf\"} %d\n", name, count)
+			fmt.Fprintf(&buf, "%s_sum %s\n", name, strconv.FormatFloat(sum, 'g', -1, 64))
+			fmt.Fprintf(&buf, "%s_count %d\n", name, count)
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(buf.Bytes())
+	})
+}
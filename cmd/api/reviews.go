@@ -0,0 +1,284 @@
+package main
+
+import (
+	"cinevault.interimme.net/internal/data"
+	"cinevault.interimme.net/internal/validator"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// createReviewHandler handles requests to add the authenticated user's review to a movie.
+func (app *application) createReviewHandler(w http.ResponseWriter, r *http.Request) {
+	// Extract the movie ID from the URL parameters.
+	movieID, ok := app.readMovieIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	// Make sure the movie exists before accepting a review for it.
+	_, err := app.models.Movies.Get(r.Context(), movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Define a struct to hold the input data from the request body.
+	var input struct {
+		Rating int32  `json:"rating"`
+		Body   string `json:"body"`
+	}
+
+	// Parse the JSON request body into the input struct.
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Create a new Review struct for the current user using the input data.
+	review := &data.Review{
+		UserID:  app.contextGetUser(r).ID,
+		MovieID: movieID,
+		Rating:  input.Rating,
+		Body:    input.Body,
+	}
+
+	// Initialize a new validator instance.
+	v := validator.New()
+
+	// Validate the review data.
+	if data.ValidateReview(v, review); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Insert the review record into the database.
+	err = app.models.Reviews.Insert(r.Context(), review)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateReview):
+			// If the user has already reviewed this movie, respond with a validation error.
+			v.AddError("movie_id", "you have already reviewed this movie")
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Set the Location header for the new review resource.
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/reviews/%d", review.ID))
+
+	// Respond with a 201 Created status and the review data in JSON format.
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"review": review}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listReviewsHandler handles requests to list all reviews of a specific movie.
+func (app *application) listReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	// Extract the movie ID from the URL parameters.
+	movieID, ok := app.readMovieIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	// Make sure the movie exists, so that an unknown movie is a 404 rather than an empty list.
+	_, err := app.models.Movies.Get(r.Context(), movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Retrieve the reviews for the movie.
+	reviews, err := app.models.Reviews.GetAllForMovie(r.Context(), movieID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Respond with a 200 OK status and the list of reviews in JSON format.
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"reviews": reviews}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showReviewHandler handles requests to retrieve a single review by its ID.
+func (app *application) showReviewHandler(w http.ResponseWriter, r *http.Request) {
+	// Extract the review ID from the URL parameters.
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Retrieve the review from the database.
+	review, err := app.models.Reviews.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Respond with 304 Not Modified if the client already has this version of the review.
+	if !app.checkPrecondition(w, r, review.Version, review.ID) {
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("ETag", etagFor(review.Version, review.ID))
+
+	// Respond with a 200 OK status and the review data in JSON format.
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"review": review}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateReviewHandler handles requests to update the authenticated user's own review.
+func (app *application) updateReviewHandler(w http.ResponseWriter, r *http.Request) {
+	// Extract the review ID from the URL parameters.
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Retrieve the existing review from the database.
+	review, err := app.models.Reviews.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Only the author of a review may change it.
+	if review.UserID != app.contextGetUser(r).ID {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	// If the client sent an If-Match header, make sure it refers to the current version of the review.
+	if !app.checkPrecondition(w, r, review.Version, review.ID) {
+		return
+	}
+
+	// Define a struct to hold the input data from the request body.
+	var input struct {
+		Rating *int32  `json:"rating"`
+		Body   *string `json:"body"`
+	}
+
+	// Parse the JSON request body into the input struct.
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Update the review fields if the input data is provided.
+	if input.Rating != nil {
+		review.Rating = *input.Rating
+	}
+	if input.Body != nil {
+		review.Body = *input.Body
+	}
+
+	// Initialize a new validator instance.
+	v := validator.New()
+
+	// Validate the updated review data.
+	if data.ValidateReview(v, review); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Update the review record in the database.
+	err = app.models.Reviews.Update(r.Context(), review)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etagFor(review.Version, review.ID))
+
+	// Respond with a 200 OK status and the updated review data in JSON format.
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"review": review}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteReviewHandler handles requests to delete the authenticated user's own review.
+func (app *application) deleteReviewHandler(w http.ResponseWriter, r *http.Request) {
+	// Extract the review ID from the URL parameters.
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Retrieve the review, so that its version can be checked against an If-Match header. Other users' reviews are
+	// reported as not found, as the delete below would.
+	review, err := app.models.Reviews.Get(r.Context(), id)
+	if err == nil && review.UserID != app.contextGetUser(r).ID {
+		err = data.ErrRecordNotFound
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if !app.checkPrecondition(w, r, review.Version, review.ID) {
+		return
+	}
+
+	// Delete the review from the database, provided that it belongs to the current user.
+	err = app.models.Reviews.Delete(r.Context(), id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Respond with a 200 OK status and a message indicating successful deletion.
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "review successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"cinevault.interimme.net/internal/storage"
 	"expvar"
 	"github.com/julienschmidt/httprouter"
 	"net/http"
@@ -11,37 +12,168 @@ func (app *application) routes() http.Handler {
 	// Initialize a new httprouter router instance.
 	router := httprouter.New()
 
+	// Create a general rate limiter keyed by client IP, and a much stricter one for the endpoints that accept
+	// credentials or send emails, keyed by client IP and email address.
+	rateLimit := app.rateLimit(rateLimitOptions{
+		rps:   app.config.limiter.rps,
+		burst: app.config.limiter.burst,
+		key:   app.rateLimitIPKey,
+	})
+	authRateLimit := app.rateLimit(rateLimitOptions{
+		rps:   app.config.limiter.authRPS,
+		burst: app.config.limiter.authBurst,
+		key:   app.rateLimitIPEmailKey,
+	})
+
 	// Set custom handlers for "Not Found" and "Method Not Allowed" responses.
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
-	// Register route for the healthcheck endpoint.
+	// Answer OPTIONS requests, including CORS preflight requests, with the methods registered for the path.
+	router.GlobalOPTIONS = http.HandlerFunc(app.preflightHandler)
+
+	// Register routes for the liveness and readiness probes. The healthcheck endpoint is the same readiness probe.
+	router.HandlerFunc(http.MethodGet, "/v1/livez", app.livezHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/readyz", app.healthcheckHandler)
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
 
+	// Register the route serving the OpenAPI description of the API.
+	router.HandlerFunc(http.MethodGet, "/v1/openapi.json", app.openAPIHandler())
+
 	// Register routes for movie-related endpoints with permission checks.
 	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMoviesHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id", app.dispatchParam("id", map[string]http.HandlerFunc{
+		"batch": app.requirePermission("movies:write", app.createMoviesBatchHandler),
+	}, app.methodNotAllowedResponse))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.dispatchParam("id", map[string]http.HandlerFunc{
+		"count":    app.requirePermission("movies:read", app.countMoviesHandler),
+		"export":   app.requirePermission("movies:export", app.exportMoviesHandler),
+		"trending": app.requirePermission("movies:read", app.listTrendingMoviesHandler),
+	}, app.requirePermission("movies:read", app.showMovieHandler)))
 	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies", app.requirePermission("movies:write", app.deleteMoviesHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/similar", app.requirePermission("movies:read", app.listSimilarMoviesHandler))
+
+	// Register the route for uploading movie posters, and serve locally-stored uploads.
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/poster", app.requirePermission("movies:write", app.uploadMoviePosterHandler))
+	if local, ok := app.storage.(*storage.Local); ok {
+		router.ServeFiles("/uploads/*filepath", http.Dir(local.Dir()))
+	}
+
+	// Register routes for genre-related endpoints.
+	router.HandlerFunc(http.MethodGet, "/v1/genres", app.requirePermission("movies:read", app.listGenresHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/genres", app.requirePermission("movies:write", app.createGenreHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/genres/:genre", app.requirePermission("movies:write", app.deleteGenreHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/genres/:genre/movies", app.requirePermission("movies:read", app.listGenreMoviesHandler))
+
+	// Register routes for actors and the casts of movies.
+	router.HandlerFunc(http.MethodGet, "/v1/actors", app.requirePermission("movies:read", app.listActorsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/actors", app.requirePermission("movies:write", app.createActorHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/actors/:id", app.requirePermission("movies:read", app.showActorHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/actors/:id/movies", app.requirePermission("movies:read", app.listActorMoviesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/cast", app.requirePermission("movies:write", app.addCastMemberHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/cast/:actor_id", app.requirePermission("movies:write", app.removeCastMemberHandler))
+
+	// Register routes for review-related endpoints.
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/reviews", app.requirePermission("movies:read", app.listReviewsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/reviews", app.requirePermission("movies:read", app.createReviewHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/reviews/:id", app.requirePermission("movies:read", app.showReviewHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/reviews/:id", app.requirePermission("movies:read", app.updateReviewHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/reviews/:id", app.requirePermission("movies:read", app.deleteReviewHandler))
+
+	// Register routes for the current user's watchlist.
+	router.HandlerFunc(http.MethodGet, "/v1/watchlist", app.requireActivatedUser(app.listWatchlistHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/watchlist/:id", app.requirePermission("watchlist:write", app.addToWatchlistHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/watchlist/:id", app.requirePermission("watchlist:write", app.removeFromWatchlistHandler))
+
+	// Register the route for reading the audit log.
+	router.HandlerFunc(http.MethodGet, "/v1/audit", app.requirePermission("audit:read", app.listAuditLogHandler))
+
+	// Register the route for reading aggregate figures about the database.
+	router.HandlerFunc(http.MethodGet, "/v1/stats", app.requirePermission("stats:read", app.showStatsHandler))
 
 	// Register routes for user-related endpoints without permission checks.
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
-	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
-	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/users", app.requirePermission("users:read", app.listUsersHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me", app.requireAuthenticatedUser(app.showCurrentUserHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me", app.requireAuthenticatedUser(app.requireUserSession(app.updateCurrentUserHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:id", app.dispatchParam("id", map[string]http.HandlerFunc{
+		"me": app.requireAuthenticatedUser(app.requireUserSession(app.deleteCurrentUserHandler)),
+	}, app.methodNotAllowedResponse))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/permissions", app.requireAuthenticatedUser(app.showCurrentUserPermissionsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/tokens", app.requireAuthenticatedUser(app.listSessionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:id/tokens/:token_id", app.dispatchParam("id", map[string]http.HandlerFunc{
+		"me": app.requireAuthenticatedUser(app.requireUserSession(app.revokeSessionHandler)),
+	}, app.notFoundResponse))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/api-keys", app.requireActivatedUser(app.requireUserSession(app.createAPIKeyHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/api-keys", app.requireAuthenticatedUser(app.listAPIKeysHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:id/api-keys/:key_id", app.dispatchParam("id", map[string]http.HandlerFunc{
+		"me": app.requireAuthenticatedUser(app.requireUserSession(app.revokeAPIKeyHandler)),
+	}, app.notFoundResponse))
+	router.HandlerFunc(http.MethodPut, "/v1/users/:id/email", app.dispatchParam("id", map[string]http.HandlerFunc{
+		"me": app.requireActivatedUser(app.requireUserSession(app.requestEmailChangeHandler)),
+	}, app.notFoundResponse))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/2fa/enable", app.requireActivatedUser(app.requireUserSession(app.enableTwoFactorHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/2fa/verify", app.requireActivatedUser(app.requireUserSession(app.verifyTwoFactorHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/2fa/disable", app.requireActivatedUser(app.requireUserSession(app.disableTwoFactorHandler)))
+	router.HandlerFunc(http.MethodPut, "/v1/users/:id", app.dispatchParam("id", map[string]http.HandlerFunc{
+		"email":     app.confirmEmailChangeHandler,
+		"activated": app.activateUserHandler,
+		"password":  authRateLimit(http.HandlerFunc(app.updateUserPasswordHandler)).ServeHTTP,
+	}, app.methodNotAllowedResponse))
+
+	// Register the routes for administrators to grant and revoke the permissions of users.
+	router.HandlerFunc(http.MethodPut, "/v1/users/:id/permissions", app.requirePermission("permissions:write", app.grantUserPermissionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:id/permissions", app.requirePermission("permissions:write", app.revokeUserPermissionsHandler))
 
 	// Register routes for token-related endpoints for authentication and activation.
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+	router.Handler(http.MethodPost, "/v1/tokens/authentication", authRateLimit(http.HandlerFunc(app.createAuthenticationTokenHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/tokens/verify", app.verifyAuthenticationTokenHandler)
+	router.Handler(http.MethodPost, "/v1/tokens/refresh", authRateLimit(http.HandlerFunc(app.refreshAuthenticationTokenHandler)))
+	router.Handler(http.MethodPost, "/v1/tokens/logout", authRateLimit(http.HandlerFunc(app.logoutHandler)))
+	router.Handler(http.MethodPost, "/v1/tokens/activation", authRateLimit(http.HandlerFunc(app.createActivationTokenHandler)))
+	router.Handler(http.MethodPost, "/v1/tokens/password-reset", authRateLimit(http.HandlerFunc(app.createPasswordResetTokenHandler)))
+	router.Handler(http.MethodPost, "/v1/tokens/magic-link", authRateLimit(http.HandlerFunc(app.createMagicLinkTokenHandler)))
+	router.Handler(http.MethodPost, "/v1/tokens/magic-link/verify", authRateLimit(http.HandlerFunc(app.verifyMagicLinkTokenHandler)))
 
-	// Register the /debug/vars endpoint to expose expvar metrics.
-	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
+	// Register the /debug/vars endpoint to expose expvar metrics, and the /metrics endpoint to expose the same
+	// metrics in the Prometheus text format, unless they are disabled. Both are limited to allowed client networks
+	// and users with the metrics:read permission.
+	if app.config.metrics.enabled {
+		router.HandlerFunc(http.MethodGet, "/debug/vars", app.requireMetricsAccess(expvar.Handler()))
+		router.HandlerFunc(http.MethodGet, "/metrics", app.requireMetricsAccess(app.prometheusHandler()))
+	}
 
-	// Chain middleware in the desired order: recover from panics, enable CORS, apply rate limiting, authenticate users, and collect metrics.
+	// Chain middleware in the desired order: collect metrics, compress responses, assign a request ID, recover from
+	// panics, filter client IPs, refuse requests during shutdown, apply the request timeout, enable CORS, apply the
+	// general rate limit, authenticate users, and check the runtime format. Compression sits inside metrics so that
+	// the status code it passes on is still captured, and the IP filter comes before anything that does work for
+	// the client.
 	return app.metrics(
-		app.recoverPanic(
-			app.enableCORS(
-				app.rateLimit(
-					app.authenticate(router)))))
+		app.compress(
+			app.requestID(
+				app.recoverPanic(
+					app.filterIPs(
+						app.rejectDuringShutdown(
+							app.timeout(
+								app.enableCORS(
+									rateLimit(
+										app.authenticate(
+											app.checkRuntimeFormat(router)))))))))))
+}
+
+// dispatchParam returns a handler for a route where static path segments share a position with a named
+// parameter, which httprouter does not allow to be registered as separate routes. Requests whose parameter
+// value matches a key in static are served by the corresponding handler, and all others by next.
+func (app *application) dispatchParam(param string, static map[string]http.HandlerFunc, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		value := httprouter.ParamsFromContext(r.Context()).ByName(param)
+		if handler, ok := static[value]; ok {
+			handler(w, r)
+			return
+		}
+		next(w, r)
+	}
 }
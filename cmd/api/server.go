@@ -2,16 +2,19 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 )
 
-// serve starts the HTTP server and manages graceful shutdowns.
+// serve starts the HTTP server and manages graceful shutdowns. If a TLS certificate is configured, the server
+// serves HTTPS, with HTTP/2 enabled and connections below TLS 1.2 refused; otherwise it serves plain HTTP.
 func (app *application) serve() error {
 	// Configure the HTTP server with settings from the application configuration.
 	srv := &http.Server{
@@ -21,6 +24,13 @@ func (app *application) serve() error {
 		ReadTimeout:  10 * time.Second,                    // Maximum duration for reading the entire request.
 		WriteTimeout: 30 * time.Second,                    // Maximum duration before timing out writes of the response.
 	}
+	useTLS := app.config.tls.certFile != ""
+	if useTLS {
+		srv.TLSConfig = &tls.Config{
+			MinVersion: tls.VersionTLS12,           // Refuse the older, insecure protocol versions.
+			NextProtos: []string{"h2", "http/1.1"}, // Offer HTTP/2, falling back to HTTP/1.1.
+		}
+	}
 
 	// Channel to receive errors during server shutdown.
 	shutdownError := make(chan error)
@@ -40,8 +50,12 @@ func (app *application) serve() error {
 			"signal": s.String(),
 		})
 
-		// Create a context with a timeout for the shutdown process.
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		// Refuse any further requests, so that clients know to retry them elsewhere.
+		app.shuttingDown.Store(true)
+
+		// Create a context with a timeout for the shutdown process, which bounds both the wait for in-flight
+		// requests and the wait for background jobs.
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.shutdownTimeout)
 		defer cancel() // Ensure the cancel function is called to free resources.
 
 		// Attempt to gracefully shutdown the server.
@@ -56,8 +70,20 @@ func (app *application) serve() error {
 			"addr": srv.Addr,
 		})
 
-		// Wait for any background goroutines to finish.
-		app.wg.Wait()
+		// Wait for any queued or running background jobs to finish, until the shutdown deadline. Jobs still
+		// queued or running then, such as slow email sends, are abandoned when the process exits.
+		done := make(chan struct{})
+		go func() {
+			app.wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			app.logger.PrintWarn("abandoning background tasks", map[string]string{
+				"tasks": strconv.FormatInt(app.pending.Load(), 10),
+			})
+		}
 
 		// Indicate that shutdown has completed without errors.
 		shutdownError <- nil
@@ -67,10 +93,17 @@ func (app *application) serve() error {
 	app.logger.PrintInfo("starting server", map[string]string{
 		"addr": srv.Addr,
 		"env":  app.config.env,
+		"tls":  strconv.FormatBool(useTLS),
 	})
 
-	// Start the HTTP server.
-	err := srv.ListenAndServe()
+	// Start the HTTP server, over TLS if a certificate is configured. Both return http.ErrServerClosed once
+	// Shutdown is called, so the graceful shutdown works the same way for either.
+	var err error
+	if useTLS {
+		err = srv.ListenAndServeTLS(app.config.tls.certFile, app.config.tls.keyFile)
+	} else {
+		err = srv.ListenAndServe()
+	}
 	// If the error is not http.ErrServerClosed (which indicates a graceful shutdown), return the error.
 	if !errors.Is(err, http.ErrServerClosed) {
 		return err
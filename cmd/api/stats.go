@@ -0,0 +1,53 @@
+package main
+
+import (
+	"cinevault.interimme.net/internal/data"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// statsCacheTTL is how long the figures reported by the stats endpoint are reused for before they are computed
+// again. The aggregate queries scan whole tables, and dashboards don't need the figures to be up to the second.
+const statsCacheTTL = time.Minute
+
+// stats holds the aggregate figures reported by the stats endpoint.
+type stats struct {
+	Movies     data.MovieStats `json:"movies"`      // Figures about the movies.
+	Users      data.UserStats  `json:"users"`       // Figures about the user accounts.
+	ComputedAt time.Time       `json:"computed_at"` // When the figures were computed.
+}
+
+// statsCache holds the most recently computed stats, so that they can be reused until they expire.
+type statsCache struct {
+	mu    sync.Mutex // Guards stats, and is held while they are computed so that only one request computes them.
+	stats *stats     // The cached stats, or nil if they have not been computed yet.
+}
+
+// showStatsHandler handles requests for headline figures about the movies and users in the database, such as
+// their totals and the number of movies in each genre. The figures are cached for statsCacheTTL.
+func (app *application) showStatsHandler(w http.ResponseWriter, r *http.Request) {
+	app.statsCache.mu.Lock()
+	defer app.statsCache.mu.Unlock()
+
+	// Compute the stats again if they have not been computed yet or have expired.
+	if app.statsCache.stats == nil || time.Since(app.statsCache.stats.ComputedAt) >= statsCacheTTL {
+		movies, err := app.models.Movies.GetStats(r.Context())
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		users, err := app.models.Users.GetStats(r.Context())
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.statsCache.stats = &stats{Movies: movies, Users: users, ComputedAt: time.Now().UTC()}
+	}
+
+	// Respond with a 200 OK status and the stats in JSON format.
+	err := app.writeJSON(w, r, http.StatusOK, envelope{"stats": app.statsCache.stats}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
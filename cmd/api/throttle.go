@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// emailThrottle limits how often the token emails that anyone can request for an address, such as activation and
+// password reset emails, are sent to the same address. Unlike the rate limiter, which is keyed by the requester, it
+// is keyed by the recipient, so that requests spread over many IP addresses can't be used to flood an inbox.
+type emailThrottle struct {
+	mu        sync.Mutex           // Guards the fields below.
+	sent      map[string]time.Time // Maps scopes and addresses to the time an email was last sent.
+	lastSweep time.Time            // When entries older than the interval were last dropped from sent.
+}
+
+// allow reports whether an email with the given scope may be sent to the address, given that at most one is sent
+// every interval, and records the email as sent if so. If not, it also returns how long to wait until one may be
+// sent. An interval of 0 or less allows every email. A caller that is allowed to send an email but then fails to
+// must call release, so that the failure doesn't hold back the next request.
+func (t *emailThrottle) allow(scope, email string, interval time.Duration) (time.Duration, bool) {
+	if interval <= 0 {
+		return 0, true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if t.sent == nil {
+		t.sent = make(map[string]time.Time)
+	}
+
+	// Drop the entries that no longer hold anything back, at most once per interval, so that the map stays small
+	// without being scanned on every request.
+	if now.Sub(t.lastSweep) >= interval {
+		for key, sent := range t.sent {
+			if now.Sub(sent) >= interval {
+				delete(t.sent, key)
+			}
+		}
+		t.lastSweep = now
+	}
+
+	key := throttleKey(scope, email)
+	if sent, ok := t.sent[key]; ok {
+		if wait := interval - now.Sub(sent); wait > 0 {
+			return wait, false
+		}
+	}
+	t.sent[key] = now
+	return 0, true
+}
+
+// release forgets the email with the given scope recorded as sent to the address by allow, for when it couldn't be
+// sent after all.
+func (t *emailThrottle) release(scope, email string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.sent, throttleKey(scope, email))
+}
+
+// throttleKey returns the key of the emails with the given scope sent to an address, which is matched regardless of
+// case.
+func throttleKey(scope, email string) string {
+	return scope + "|" + strings.ToLower(email)
+}
@@ -3,23 +3,33 @@ package main
 import (
 	"cinevault.interimme.net/internal/data"
 	"cinevault.interimme.net/internal/validator"
+	"context"
 	"errors"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
 	"github.com/pascaldekloe/jwt"
 	"net/http"
 	"strconv"
 	"time"
 )
 
+// jwtIssuer is the issuer and audience of the JWTs issued by the API.
+const jwtIssuer = "cinevault.interimme.net"
+
+// errInvalidJWT is returned by verifyJWT when a token's signature or claims don't check out.
+var errInvalidJWT = errors.New("invalid JWT")
+
 // createAuthenticationTokenHandler handles requests to generate a new authentication token.
 func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
 	// Struct to hold the input email and password from the request.
 	var input struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		TOTP     string `json:"totp"` // Required only for users with two-factor authentication enabled.
 	}
 
 	// Read JSON request body into the input struct.
-	err := app.readJSON(w, r, &input)
+	err := app.readJSONLimited(w, r, &input, maxAuthBodyBytes)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
@@ -34,12 +44,12 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 
 	if !v.Valid() {
 		// Respond with validation errors if input is invalid.
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
 	// Retrieve the user by email.
-	user, err := app.models.Users.GetByEmail(input.Email)
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -65,24 +75,463 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
+	// If the user has two-factor authentication enabled, check the TOTP code too.
+	if !app.validateLoginTOTP(w, r, user, input.TOTP) {
+		return
+	}
+
+	// Upgrade the stored password hash if it was made at a lower cost than is now configured.
+	if user.Password.NeedsRehash(app.config.bcryptCost) {
+		app.rehashPassword(r, user, input.Password)
+	}
+
+	// Issue a new JWT and refresh token pair for the user.
+	env, err := app.issueAuthenticationTokens(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Respond with the generated JWT and refresh token.
+	err = app.writeJSON(w, r, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// rehashPassword replaces the stored password hash of a user who has just logged in with a new hash of their
+// plaintext password at the configured bcrypt cost. A failure doesn't affect the login, since the old hash still
+// works, so it is only logged, and the upgrade is retried at the next login.
+func (app *application) rehashPassword(r *http.Request, user *data.User, plaintext string) {
+	err := user.Password.Set(plaintext, app.config.bcryptCost)
+	if err == nil {
+		err = app.models.Users.Update(r.Context(), user)
+	}
+	if err != nil {
+		app.logError(r, fmt.Errorf("rehash password: %w", err))
+	}
+}
+
+// refreshAuthenticationTokenHandler handles requests to exchange a refresh token for a new JWT. The refresh
+// token is rotated: the one presented is deleted and a new one is issued alongside the JWT.
+func (app *application) refreshAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	// Struct to hold the input refresh token from the request.
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	// Read JSON request body into the input struct, ignoring any fields other than the refresh token.
+	err := app.readJSONLenient(w, r, &input, maxAuthBodyBytes)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Initialize a new validator instance.
+	v := validator.New()
+
+	// Validate the refresh token format.
+	if data.ValidateTokenPlaintext(v, input.RefreshToken); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Retrieve the user associated with the refresh token.
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopeRefresh, input.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			// Respond with an invalid token error if the refresh token is unknown or expired.
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			// Respond with a server error for other types of errors.
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Delete the refresh token so that it can only be used once. If another request consumed it first,
+	// treat this one as presenting an invalid token.
+	err = app.models.Tokens.Delete(r.Context(), data.ScopeRefresh, input.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Issue a new JWT and refresh token pair for the user.
+	env, err := app.issueAuthenticationTokens(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Respond with the generated JWT and refresh token.
+	err = app.writeJSON(w, r, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// logoutHandler handles requests to revoke all refresh tokens belonging to the owner of the given refresh token.
+func (app *application) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	// Struct to hold the input refresh token from the request.
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	// Read JSON request body into the input struct, ignoring any fields other than the refresh token.
+	err := app.readJSONLenient(w, r, &input, maxAuthBodyBytes)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Initialize a new validator instance.
+	v := validator.New()
+
+	// Validate the refresh token format.
+	if data.ValidateTokenPlaintext(v, input.RefreshToken); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Retrieve the user associated with the refresh token.
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopeRefresh, input.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Delete all refresh tokens for the user, ending every session.
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), data.ScopeRefresh, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Respond with a confirmation message.
+	env := envelope{"message": "you have been successfully logged out"}
+	err = app.writeJSON(w, r, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createMagicLinkTokenHandler handles requests to log in without a password by emailing a short-lived, single-use
+// login token to the given address. To avoid revealing which addresses have accounts, it responds the same way
+// whether or not an email is sent: no email is sent if there is no activated user with the address, or if one was
+// sent to the address less than the email interval ago.
+func (app *application) createMagicLinkTokenHandler(w http.ResponseWriter, r *http.Request) {
+	// Struct to hold the input email from the request.
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	// Read JSON request body into the input struct.
+	err := app.readJSONLimited(w, r, &input, maxAuthBodyBytes)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Initialize a new validator instance.
+	v := validator.New()
+
+	// Validate email field.
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		// Respond with validation errors if the email is invalid.
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	env := envelope{"message": "if an activated account with this email address exists, an email will be sent to it containing a login token"}
+
+	// Retrieve the user by email, responding as if the email had been sent if there is none.
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Only send a login token to an activated user, and at most one every interval, however many clients ask.
+	if user != nil && user.Activated {
+		if _, ok := app.emailThrottle.allow(data.ScopeMagicLink, user.Email, app.config.tokens.emailInterval); ok {
+			// Generate a new login token for the user.
+			token, err := app.models.Tokens.New(r.Context(), user.ID, app.config.tokens.magicLinkTTL, data.ScopeMagicLink)
+			if err != nil {
+				// Take back the send recorded by the throttle, since no email will go out.
+				app.emailThrottle.release(data.ScopeMagicLink, user.Email)
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			// Send the login email in the background.
+			app.background(func() {
+				data := map[string]interface{}{
+					"magicLinkToken": token.Plaintext,
+					"expiry":         formatExpiry(app.config.tokens.magicLinkTTL),
+				}
+
+				err := app.mailer.Send(user.Email, "token_magic_link.tmpl", data)
+				if err != nil {
+					app.logger.PrintError(err, nil)
+				}
+			})
+		}
+	}
+
+	// Respond with the same message whether or not an email will be sent.
+	err = app.writeJSON(w, r, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// verifyMagicLinkTokenHandler handles requests to exchange a login token sent by createMagicLinkTokenHandler for a
+// JWT and refresh token pair. The login token is deleted, so that it can only be used once. Users with two-factor
+// authentication enabled must give their TOTP code as well, as when logging in with a password.
+func (app *application) verifyMagicLinkTokenHandler(w http.ResponseWriter, r *http.Request) {
+	// Struct to hold the input login token from the request.
+	var input struct {
+		Token string `json:"token"`
+		TOTP  string `json:"totp"` // Required only for users with two-factor authentication enabled.
+	}
+
+	// Read JSON request body into the input struct.
+	err := app.readJSONLimited(w, r, &input, maxAuthBodyBytes)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Initialize a new validator instance.
+	v := validator.New()
+
+	// Validate the login token format.
+	if data.ValidateTokenPlaintext(v, input.Token); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Retrieve the user associated with the login token.
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopeMagicLink, input.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired login token")
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// If the user has two-factor authentication enabled, check the TOTP code before using up the login token, so
+	// that a mistyped code doesn't need a new email.
+	if !app.validateLoginTOTP(w, r, user, input.TOTP) {
+		return
+	}
+
+	// Delete the login token so that it can only be used once. If another request consumed it first, treat this one
+	// as presenting an invalid token.
+	err = app.models.Tokens.Delete(r.Context(), data.ScopeMagicLink, input.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired login token")
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Issue a new JWT and refresh token pair for the user.
+	env, err := app.issueAuthenticationTokens(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Respond with the generated JWT and refresh token.
+	err = app.writeJSON(w, r, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listSessionsHandler handles requests from an authenticated user to list their active sessions: the unexpired
+// authentication and refresh tokens issued to them. Neither the tokens nor their full hashes are included.
+func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	sessions, err := app.models.Tokens.GetAllForUser(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Respond with a 200 OK status and the sessions in JSON format.
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"tokens": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revokeSessionHandler handles requests from an authenticated user to revoke one of their sessions, identified by
+// the token ID shown by listSessionsHandler. Revoking a refresh token doesn't invalidate JWTs already issued with it,
+// which remain valid until they expire.
+func (app *application) revokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	// Extract the token ID from the URL parameters.
+	id, err := strconv.ParseInt(httprouter.ParamsFromContext(r.Context()).ByName("token_id"), 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Delete the token, provided it is one of the current user's sessions.
+	err = app.models.Tokens.DeleteForUser(r.Context(), id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Respond with a 200 OK status and a message confirming the revocation.
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "token successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// issueAuthenticationTokens mints a signed JWT for the user along with a new refresh token, and returns both
+// wrapped in an envelope ready to be written to the client.
+func (app *application) issueAuthenticationTokens(ctx context.Context, userID int64) (envelope, error) {
 	// Define JWT claims.
 	var claims jwt.Claims
-	claims.Subject = strconv.FormatInt(user.ID, 10)
+	claims.Subject = strconv.FormatInt(userID, 10)
 	claims.Issued = jwt.NewNumericTime(time.Now())
 	claims.NotBefore = jwt.NewNumericTime(time.Now())
-	claims.Expires = jwt.NewNumericTime(time.Now().Add(24 * time.Hour))
-	claims.Issuer = "cinevault.interimme.net"
-	claims.Audiences = []string{"cinevault.interimme.net"}
+	claims.Expires = jwt.NewNumericTime(time.Now().Add(app.config.jwt.ttl))
+	claims.Issuer = jwtIssuer
+	claims.Audiences = []string{jwtIssuer}
+
+	// Sign the JWT claims using HMAC SHA-256 with the current key, identifying the key in the kid header.
+	var secret []byte
+	claims.KeyID, secret = app.jwtSigningKey()
+	jwtBytes, err := claims.HMACSign(jwt.HS256, secret)
+	if err != nil {
+		return nil, err
+	}
 
-	// Sign the JWT claims using HMAC SHA-256.
-	jwtBytes, err := claims.HMACSign(jwt.HS256, []byte(app.config.jwt.secret))
+	// Generate a long-lived refresh token, stored hashed in the tokens table.
+	refreshToken, err := app.models.Tokens.New(ctx, userID, app.config.tokens.refreshTTL, data.ScopeRefresh)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		return nil, err
+	}
+
+	return envelope{"authentication_token": string(jwtBytes), "refresh_token": refreshToken}, nil
+}
+
+// jwtSigningKey returns the ID and secret of the key that new JWTs are signed with: the current key in the keyring
+// if one is configured, and the JWT secret, with an empty ID, otherwise.
+func (app *application) jwtSigningKey() (string, []byte) {
+	if app.config.jwt.currentKID != "" {
+		return app.config.jwt.currentKID, app.config.jwt.keys[app.config.jwt.currentKID]
+	}
+	return "", []byte(app.config.jwt.secret)
+}
+
+// jwtVerificationKey returns the secret that a JWT with the given key ID must be signed with: the key with that ID
+// in the keyring, or the JWT secret for tokens without a key ID, which were signed before the keyring was set up.
+// Keeping retired keys in the keyring until the tokens signed with them expire lets the current key be changed
+// without logging everyone out.
+func (app *application) jwtVerificationKey(kid string) ([]byte, bool) {
+	if kid == "" {
+		return []byte(app.config.jwt.secret), app.config.jwt.secret != ""
+	}
+	secret, ok := app.config.jwt.keys[kid]
+	return secret, ok
+}
+
+// verifyJWT checks that a JWT issued by issueAuthenticationTokens has a valid HS256 signature and is currently
+// valid for this API, returning its claims and the ID of the user it was issued to. The signature is checked with
+// the key named by the token's kid header. Any problem with the token is reported as an error wrapping
+// errInvalidJWT.
+func (app *application) verifyJWT(token string) (*jwt.Claims, int64, error) {
+	// Read the key ID from the header, without trusting anything else in the token yet.
+	unverified, err := jwt.ParseWithoutCheck([]byte(token))
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %s", errInvalidJWT, err)
+	}
+	secret, ok := app.jwtVerificationKey(unverified.KeyID)
+	if !ok {
+		return nil, 0, fmt.Errorf("%w: unknown key ID %q", errInvalidJWT, unverified.KeyID)
+	}
+
+	// Check the signature, which must use HS256 with the key the token names.
+	hmac, err := jwt.NewHMAC(jwt.HS256, secret)
+	if err != nil {
+		return nil, 0, err
+	}
+	claims, err := hmac.Check([]byte(token))
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %s", errInvalidJWT, err)
+	}
+
+	// Check the expiry and not-before times, and that the token was issued by and for this API.
+	if !claims.Valid(time.Now()) {
+		return nil, 0, fmt.Errorf("%w: expired or not yet valid", errInvalidJWT)
+	}
+	if claims.Issuer != jwtIssuer || !claims.AcceptAudience(jwtIssuer) {
+		return nil, 0, fmt.Errorf("%w: wrong issuer or audience", errInvalidJWT)
+	}
+
+	// Extract the user ID from the subject claim.
+	userID, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil || userID < 1 {
+		return nil, 0, fmt.Errorf("%w: invalid subject", errInvalidJWT)
+	}
+	return claims, userID, nil
+}
+
+// verifyAuthenticationTokenHandler handles requests to check whether the JWT in the Authorization header is still
+// valid, so that clients can find out without making a request to a protected endpoint.
+func (app *application) verifyAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	// Read the token from the Authorization header.
+	token, ok := bearerToken(r)
+	if !ok {
+		app.invalidAuthenticationTokenResponse(w, r)
 		return
 	}
 
-	// Respond with the generated JWT.
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": string(jwtBytes)}, nil)
+	// Verify the token's signature and claims.
+	claims, userID, err := app.verifyJWT(token)
+	if err != nil {
+		switch {
+		case errors.Is(err, errInvalidJWT):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Respond with the token's expiry time and the user it was issued to.
+	env := envelope{"valid": true, "expires": claims.Expires.Time(), "user_id": userID}
+	err = app.writeJSON(w, r, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -96,7 +545,7 @@ func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r
 	}
 
 	// Read JSON request body into the input struct.
-	err := app.readJSON(w, r, &input)
+	err := app.readJSONLimited(w, r, &input, maxAuthBodyBytes)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
@@ -108,18 +557,18 @@ func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r
 	// Validate email field.
 	if data.ValidateEmail(v, input.Email); !v.Valid() {
 		// Respond with validation errors if the email is invalid.
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
 	// Retrieve the user by email.
-	user, err := app.models.Users.GetByEmail(input.Email)
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			// Respond with validation error if no user is found.
 			v.AddError("email", "no matching email address found")
-			app.failedValidationResponse(w, r, v.Errors)
+			app.failedValidationResponse(w, r, v)
 		default:
 			// Respond with a server error for other types of errors.
 			app.serverErrorResponse(w, r, err)
@@ -130,13 +579,21 @@ func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r
 	// Check if the user account is activated.
 	if !user.Activated {
 		v.AddError("email", "user account must be activated")
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Send at most one password reset email to the address every interval, however many clients ask for one.
+	if retryAfter, ok := app.emailThrottle.allow(data.ScopePasswordReset, user.Email, app.config.tokens.emailInterval); !ok {
+		app.emailThrottledResponse(w, r, retryAfter)
 		return
 	}
 
 	// Generate a new password reset token for the user.
-	token, err := app.models.Tokens.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
+	token, err := app.models.Tokens.New(r.Context(), user.ID, app.config.tokens.resetTTL, data.ScopePasswordReset)
 	if err != nil {
+		// Take back the send recorded by the throttle, since no email will go out.
+		app.emailThrottle.release(data.ScopePasswordReset, user.Email)
 		app.serverErrorResponse(w, r, err)
 		return
 	}
@@ -145,6 +602,7 @@ func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r
 	app.background(func() {
 		data := map[string]interface{}{
 			"passwordResetToken": token.Plaintext,
+			"expiry":             formatExpiry(app.config.tokens.resetTTL),
 		}
 
 		err = app.mailer.Send(user.Email, "token_password_reset.tmpl", data)
@@ -155,7 +613,7 @@ func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r
 
 	// Respond with a message indicating that password reset instructions will be sent.
 	env := envelope{"message": "an email will be sent to you containing password reset instructions"}
-	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	err = app.writeJSON(w, r, http.StatusAccepted, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -169,7 +627,7 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 	}
 
 	// Read JSON request body into the input struct.
-	err := app.readJSON(w, r, &input)
+	err := app.readJSONLimited(w, r, &input, maxAuthBodyBytes)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
@@ -181,18 +639,18 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 	// Validate email field.
 	if data.ValidateEmail(v, input.Email); !v.Valid() {
 		// Respond with validation errors if the email is invalid.
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
 	// Retrieve the user by email.
-	user, err := app.models.Users.GetByEmail(input.Email)
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			// Respond with validation error if no user is found.
 			v.AddError("email", "no matching email address found")
-			app.failedValidationResponse(w, r, v.Errors)
+			app.failedValidationResponse(w, r, v)
 		default:
 			// Respond with a server error for other types of errors.
 			app.serverErrorResponse(w, r, err)
@@ -203,13 +661,21 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 	// Check if the user has already been activated.
 	if user.Activated {
 		v.AddError("email", "user has already been activated")
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Send at most one activation email to the address every interval, however many clients ask for one.
+	if retryAfter, ok := app.emailThrottle.allow(data.ScopeActivation, user.Email, app.config.tokens.emailInterval); !ok {
+		app.emailThrottledResponse(w, r, retryAfter)
 		return
 	}
 
 	// Generate a new activation token for the user.
-	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+	token, err := app.models.Tokens.New(r.Context(), user.ID, app.config.tokens.activationTTL, data.ScopeActivation)
 	if err != nil {
+		// Take back the send recorded by the throttle, since no email will go out.
+		app.emailThrottle.release(data.ScopeActivation, user.Email)
 		app.serverErrorResponse(w, r, err)
 		return
 	}
@@ -218,6 +684,7 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 	app.background(func() {
 		data := map[string]interface{}{
 			"activationToken": token.Plaintext,
+			"expiry":          formatExpiry(app.config.tokens.activationTTL),
 		}
 
 		err = app.mailer.Send(user.Email, "token_activation.tmpl", data)
@@ -228,7 +695,7 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 
 	// Respond with a message indicating that activation instructions will be sent.
 	env := envelope{"message": "an email will be sent to you containing activation instructions"}
-	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	err = app.writeJSON(w, r, http.StatusAccepted, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
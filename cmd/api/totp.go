@@ -0,0 +1,213 @@
+package main
+
+import (
+	"cinevault.interimme.net/internal/data"
+	"cinevault.interimme.net/internal/totp"
+	"cinevault.interimme.net/internal/validator"
+	"net/http"
+	"time"
+)
+
+// totpIssuer is the name that authenticator apps show alongside the codes for Cinevault accounts.
+const totpIssuer = "Cinevault"
+
+// enableTwoFactorHandler handles requests from an authenticated user to start enrolling in two-factor
+// authentication. A new TOTP secret is generated and returned along with an otpauth URI for authenticator apps.
+// Two-factor authentication is not enforced until the user confirms a code with verifyTwoFactorHandler.
+func (app *application) enableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if app.config.totp.key == nil {
+		app.twoFactorUnavailableResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	// Check that two-factor authentication isn't already enabled, so an existing secret isn't overwritten.
+	_, enabled, err := app.models.Users.GetTOTP(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if enabled {
+		v := validator.New()
+		v.AddError("totp", "two-factor authentication is already enabled")
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Generate a new secret and store it encrypted, without enabling it yet.
+	secret, err := totp.NewSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	encrypted, err := totp.Encrypt(app.config.totp.key, secret)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.Users.SetTOTP(r.Context(), user.ID, encrypted, false)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Respond with the secret and the URI to import it into an authenticator app.
+	env := envelope{"secret": secret, "otpauth_uri": totp.URI(totpIssuer, user.Email, secret)}
+	err = app.writeJSON(w, r, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// verifyTwoFactorHandler handles requests to finish enrolling in two-factor authentication, by confirming a code
+// generated from the secret returned by enableTwoFactorHandler.
+func (app *application) verifyTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	// Struct to hold the input code from the request body.
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	// Read the JSON request body into the input struct.
+	err := app.readJSONLimited(w, r, &input, maxAuthBodyBytes)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	// Check the code against the user's pending secret.
+	secret, _, ok := app.checkTOTPCode(w, r, user.ID, input.Code)
+	if !ok {
+		return
+	}
+
+	// Enable two-factor authentication with the confirmed secret.
+	err = app.models.Users.SetTOTP(r.Context(), user.ID, secret, true)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"message": "two-factor authentication successfully enabled"}
+	err = app.writeJSON(w, r, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// disableTwoFactorHandler handles requests to turn two-factor authentication off. A current code is required,
+// so that a stolen session alone can't be used to remove the second factor.
+func (app *application) disableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	// Struct to hold the input code from the request body.
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	// Read the JSON request body into the input struct.
+	err := app.readJSONLimited(w, r, &input, maxAuthBodyBytes)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	// Check the code against the user's secret, which must be enabled.
+	_, enabled, ok := app.checkTOTPCode(w, r, user.ID, input.Code)
+	if !ok {
+		return
+	}
+	if !enabled {
+		v := validator.New()
+		v.AddError("totp", "two-factor authentication is not enabled")
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Remove the secret and disable two-factor authentication.
+	err = app.models.Users.SetTOTP(r.Context(), user.ID, nil, false)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"message": "two-factor authentication successfully disabled"}
+	err = app.writeJSON(w, r, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// checkTOTPCode validates a code supplied by a user against their stored secret, returning the encrypted secret
+// and whether it is enabled. If the code can't be checked or is wrong, an error response is sent and ok is false.
+func (app *application) checkTOTPCode(w http.ResponseWriter, r *http.Request, userID int64, code string) (secret []byte, enabled bool, ok bool) {
+	if app.config.totp.key == nil {
+		app.twoFactorUnavailableResponse(w, r)
+		return nil, false, false
+	}
+
+	v := validator.New()
+	v.Check(code != "", "code", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return nil, false, false
+	}
+
+	secret, enabled, err := app.models.Users.GetTOTP(r.Context(), userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false, false
+	}
+	if secret == nil {
+		v.AddError("totp", "two-factor authentication has not been set up")
+		app.failedValidationResponse(w, r, v)
+		return nil, false, false
+	}
+
+	plaintext, err := totp.Decrypt(app.config.totp.key, secret)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false, false
+	}
+	if !totp.Validate(code, plaintext, time.Now()) {
+		v.AddError("code", "invalid two-factor authentication code")
+		app.failedValidationResponse(w, r, v)
+		return nil, false, false
+	}
+	return secret, enabled, true
+}
+
+// validateLoginTOTP checks the second factor for a user logging in. It reports whether the login may proceed,
+// sending the appropriate error response if not: a specific response when a code is required but missing, and
+// the usual invalid credentials response when the code is wrong.
+func (app *application) validateLoginTOTP(w http.ResponseWriter, r *http.Request, user *data.User, code string) bool {
+	secret, enabled, err := app.models.Users.GetTOTP(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	if !enabled {
+		return true
+	}
+	if app.config.totp.key == nil {
+		app.twoFactorUnavailableResponse(w, r)
+		return false
+	}
+	if code == "" {
+		app.totpRequiredResponse(w, r)
+		return false
+	}
+
+	plaintext, err := totp.Decrypt(app.config.totp.key, secret)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	if !totp.Validate(code, plaintext, time.Now()) {
+		app.invalidCredentialsResponse(w, r)
+		return false
+	}
+	return true
+}
@@ -5,7 +5,6 @@ import (
 	"cinevault.interimme.net/internal/validator"
 	"errors"
 	"net/http"
-	"time"
 )
 
 // registerUserHandler handles requests to register a new user.
@@ -18,7 +17,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 
 	// Read the JSON request body into the input struct.
-	err := app.readJSON(w, r, &input)
+	err := app.readJSONLimited(w, r, &input, maxAuthBodyBytes)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
@@ -28,11 +27,11 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	user := &data.User{
 		Name:      input.Name,
 		Email:     input.Email,
-		Activated: false, // New users start as not activated.
+		Activated: app.config.autoActivate, // New users start as not activated, unless auto-activation is enabled.
 	}
 
 	// Set the user's password.
-	err = user.Password.Set(input.Password)
+	err = user.Password.Set(input.Password, app.config.bcryptCost)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -41,21 +40,23 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	// Initialize a new validator instance.
 	v := validator.New()
 
-	// Validate the user's data.
-	if data.ValidateUser(v, user); !v.Valid() {
+	// Validate the user's data, checking the password against the password policy.
+	data.ValidateUser(v, user)
+	data.ValidatePassword(v, input.Password, app.config.password)
+	if !v.Valid() {
 		// If validation fails, respond with a 422 Unprocessable Entity error.
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
 	// Insert the new user into the database.
-	err = app.models.Users.Insert(user)
+	err = app.models.Users.Insert(r.Context(), user)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEmail):
 			// If the email already exists, respond with a validation error.
 			v.AddError("email", "a user with this email address already exists")
-			app.failedValidationResponse(w, r, v.Errors)
+			app.failedValidationResponse(w, r, v)
 		default:
 			// Respond with a server error for other types of errors.
 			app.serverErrorResponse(w, r, err)
@@ -64,14 +65,24 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 
 	// Add default permissions for the new user.
-	err = app.models.Permissions.AddForUser(user.ID, "movies:read")
+	err = app.models.Permissions.AddForUser(r.Context(), user.ID, "movies:read", "watchlist:write")
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	// When users are activated on registration there is nothing to wait for, so respond with a 201 Created
+	// status without creating an activation token or sending the welcome email.
+	if user.Activated {
+		err = app.writeJSON(w, r, http.StatusCreated, envelope{"user": user}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
 	// Generate an activation token for the new user.
-	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+	token, err := app.models.Tokens.New(r.Context(), user.ID, app.config.tokens.activationTTL, data.ScopeActivation)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -82,6 +93,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		data := map[string]interface{}{
 			"activationToken": token.Plaintext,
 			"userID":          user.ID,
+			"expiry":          formatExpiry(app.config.tokens.activationTTL),
 		}
 		err = app.mailer.Send(user.Email, "user_welcome.tmpl", data)
 		if err != nil {
@@ -90,7 +102,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	})
 
 	// Respond with a 202 Accepted status to indicate the registration was successful.
-	err = app.writeJSON(w, http.StatusAccepted, envelope{"user": user}, nil)
+	err = app.writeJSON(w, r, http.StatusAccepted, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -104,7 +116,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 
 	// Read the JSON request body into the input struct.
-	err := app.readJSON(w, r, &input)
+	err := app.readJSONLimited(w, r, &input, maxAuthBodyBytes)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
@@ -116,18 +128,18 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 	// Validate the token plaintext.
 	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
 		// If validation fails, respond with a 422 Unprocessable Entity error.
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
 	// Retrieve the user associated with the activation token.
-	user, err := app.models.Users.GetForToken(data.ScopeActivation, input.TokenPlaintext)
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopeActivation, input.TokenPlaintext)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			// If no user is found, respond with a validation error.
 			v.AddError("token", "invalid or expired activation token")
-			app.failedValidationResponse(w, r, v.Errors)
+			app.failedValidationResponse(w, r, v)
 		default:
 			// Respond with a server error for other types of errors.
 			app.serverErrorResponse(w, r, err)
@@ -139,7 +151,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 	user.Activated = true
 
 	// Update the user's status in the database.
-	err = app.models.Users.Update(user)
+	err = app.models.Users.Update(r.Context(), user)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -153,14 +165,17 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 
 	// Delete all activation tokens for the user since they are now activated.
-	err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), data.ScopeActivation, user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	headers := make(http.Header)
+	headers.Set("ETag", etagFor(user.Version, user.ID))
+
 	// Respond with a 200 OK status and the updated user data in JSON format.
-	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"user": user}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -175,7 +190,7 @@ func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http
 	}
 
 	// Read the JSON request body into the input struct.
-	err := app.readJSON(w, r, &input)
+	err := app.readJSONLimited(w, r, &input, maxAuthBodyBytes)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
@@ -184,24 +199,24 @@ func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http
 	// Initialize a new validator instance.
 	v := validator.New()
 
-	// Validate the password and token plaintext.
-	data.ValidatePasswordPlaintext(v, input.Password)
+	// Validate the new password against the password policy, and the token plaintext.
+	data.ValidatePassword(v, input.Password, app.config.password)
 	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
 
 	if !v.Valid() {
 		// If validation fails, respond with a 422 Unprocessable Entity error.
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
 	// Retrieve the user associated with the password reset token.
-	user, err := app.models.Users.GetForToken(data.ScopePasswordReset, input.TokenPlaintext)
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopePasswordReset, input.TokenPlaintext)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			// If no user is found, respond with a validation error.
 			v.AddError("token", "invalid or expired password reset token")
-			app.failedValidationResponse(w, r, v.Errors)
+			app.failedValidationResponse(w, r, v)
 		default:
 			// Respond with a server error for other types of errors.
 			app.serverErrorResponse(w, r, err)
@@ -210,14 +225,14 @@ func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http
 	}
 
 	// Update the user's password.
-	err = user.Password.Set(input.Password)
+	err = user.Password.Set(input.Password, app.config.bcryptCost)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
 	// Update the user's data in the database.
-	err = app.models.Users.Update(user)
+	err = app.models.Users.Update(r.Context(), user)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -231,7 +246,7 @@ func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http
 	}
 
 	// Delete all password reset tokens for the user after a successful password reset.
-	err = app.models.Tokens.DeleteAllForUser(data.ScopePasswordReset, user.ID)
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), data.ScopePasswordReset, user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -239,7 +254,446 @@ func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http
 
 	// Respond with a confirmation message that the password was reset successfully.
 	env := envelope{"message": "your password was successfully reset"}
-	err = app.writeJSON(w, http.StatusOK, env, nil)
+	err = app.writeJSON(w, r, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showCurrentUserHandler handles requests from an authenticated user to retrieve their own account details.
+// The account does not need to be activated, so that a newly registered user can check its status.
+func (app *application) showCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	// Check whether the user's permissions should be included alongside the profile.
+	v := validator.New()
+	include := app.readCSV(r.URL.Query(), "include", []string{})
+	for _, value := range include {
+		v.Check(validator.In(value, "permissions"), "include", "must only contain permissions")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Add the permissions if they were requested. Otherwise, set the ETag header, and respond with 304 Not Modified if
+	// the client already has this version of the account. Changes to the permissions don't change the user's version,
+	// so no ETag is sent when they are included.
+	env := envelope{"user": user}
+	headers := make(http.Header)
+	if validator.In("permissions", include...) {
+		permissions, err := app.currentUserPermissions(r)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		env["permissions"] = permissions
+	} else {
+		if !app.checkPrecondition(w, r, user.Version, user.ID) {
+			return
+		}
+		headers.Set("ETag", etagFor(user.Version, user.ID))
+	}
+
+	// Respond with a 200 OK status and the user from the request context in JSON format.
+	err := app.writeJSON(w, r, http.StatusOK, env, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showCurrentUserPermissionsHandler handles requests from an authenticated user to list their own permission codes,
+// so that clients can tell which actions to offer.
+func (app *application) showCurrentUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	permissions, err := app.currentUserPermissions(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Respond with a 200 OK status and the permission codes in JSON format.
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// userPermissions retrieves the permission codes of a user, returning an empty slice rather than nil when they
+// have none, so that the codes are always encoded as a JSON array.
+func (app *application) userPermissions(r *http.Request, userID int64) (data.Permissions, error) {
+	permissions, err := app.models.Permissions.GetAllForUser(r.Context(), userID)
+	if err != nil {
+		return nil, err
+	}
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+	return permissions, nil
+}
+
+// currentUserPermissions retrieves the permission codes the request can use, which are those of the current user,
+// narrowed to the key's scopes if the request was made with an API key.
+func (app *application) currentUserPermissions(r *http.Request) (data.Permissions, error) {
+	permissions, err := app.userPermissions(r, app.contextGetUser(r).ID)
+	if err != nil {
+		return nil, err
+	}
+	key := app.contextGetAPIKey(r)
+	if key == nil {
+		return permissions, nil
+	}
+	scoped := data.Permissions{}
+	for _, code := range permissions {
+		if key.Scopes.Include(code) {
+			scoped = append(scoped, code)
+		}
+	}
+	return scoped, nil
+}
+
+// updateCurrentUserHandler handles requests from an authenticated user to update their own account details.
+// Only the name can be changed here; the email address and activation status have their own flows.
+func (app *application) updateCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	// Define a struct to hold the input data from the request body, using a pointer so that
+	// a missing field can be told apart from an empty one.
+	var input struct {
+		Name *string `json:"name"`
+	}
+
+	// Parse the JSON request body into the input struct.
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	// If the client sent an If-Match header, make sure it refers to the current version of the account.
+	if !app.checkPrecondition(w, r, user.Version, user.ID) {
+		return
+	}
+
+	// Update the user's name if it is provided.
+	if input.Name != nil {
+		user.Name = *input.Name
+	}
+
+	// Initialize a new validator instance.
+	v := validator.New()
+
+	// Validate the updated user data.
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Update the user record in the database.
+	err = app.models.Users.Update(r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etagFor(user.Version, user.ID))
+
+	// Respond with a 200 OK status and the updated user data in JSON format.
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"user": user}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteCurrentUserHandler handles requests from an authenticated user to permanently delete their own account,
+// along with their tokens, permissions, and reviews. The current password must be supplied to confirm the request.
+func (app *application) deleteCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	// Struct to hold the current password from the request body.
+	var input struct {
+		Password string `json:"password"`
+	}
+
+	// Read the JSON request body into the input struct.
+	err := app.readJSONLimited(w, r, &input, maxAuthBodyBytes)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Initialize a new validator instance.
+	v := validator.New()
+
+	// Validate the password.
+	if data.ValidatePasswordPlaintext(v, input.Password); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	// Check that the provided password matches the stored password.
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !match {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	// If the client sent an If-Match header, make sure it refers to the current version of the account.
+	if !app.checkPrecondition(w, r, user.Version, user.ID) {
+		return
+	}
+
+	// Delete the user and all their associated data.
+	err = app.models.Users.Delete(r.Context(), user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Respond with a confirmation message.
+	env := envelope{"message": "your account was successfully deleted"}
+	err = app.writeJSON(w, r, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// requestEmailChangeHandler handles requests from an authenticated user to change their email address. The
+// current password must be supplied, and the change is only made once it has been confirmed with the token that
+// is sent to the new address. The old address is notified that a change was requested.
+func (app *application) requestEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	// Struct to hold the new email address and current password from the request body.
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	// Read the JSON request body into the input struct.
+	err := app.readJSONLimited(w, r, &input, maxAuthBodyBytes)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Initialize a new validator instance.
+	v := validator.New()
+
+	// Validate the email and password.
+	data.ValidateEmail(v, input.Email)
+	data.ValidatePasswordPlaintext(v, input.Password)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	// Check that the provided password matches the stored password.
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !match {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	// Check that the new address is different and not already in use, so the user finds out now rather than
+	// when confirming. The unique constraint is still checked again when the change is committed.
+	existing, err := app.models.Users.GetByEmail(r.Context(), input.Email)
+	switch {
+	case err == nil && existing.ID == user.ID:
+		v.AddError("email", "must be different from your current email address")
+	case err == nil:
+		v.AddError("email", "a user with this email address already exists")
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Generate an email-change token recording the new address.
+	token, err := app.models.Tokens.NewEmailChange(r.Context(), user.ID, app.config.tokens.emailChangeTTL, input.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Send the confirmation token to the new address, and a notification to the old one, in the background.
+	app.background(func() {
+		err := app.mailer.Send(input.Email, "token_email_change.tmpl", map[string]interface{}{
+			"emailChangeToken": token.Plaintext,
+			"expiry":           formatExpiry(app.config.tokens.emailChangeTTL),
+		})
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+
+		err = app.mailer.Send(user.Email, "email_change_notification.tmpl", map[string]interface{}{
+			"newEmail": input.Email,
+		})
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	// Respond with a message indicating that confirmation instructions will be sent.
+	env := envelope{"message": "an email will be sent to your new address containing confirmation instructions"}
+	err = app.writeJSON(w, r, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirmEmailChangeHandler handles requests to commit a change of email address using the token sent to the
+// new address.
+func (app *application) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	// Struct to hold the input token from the request body.
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	// Read the JSON request body into the input struct.
+	err := app.readJSONLimited(w, r, &input, maxAuthBodyBytes)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Initialize a new validator instance.
+	v := validator.New()
+
+	// Validate the token plaintext.
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Retrieve the email-change token, which records the new address.
+	token, err := app.models.Tokens.Get(r.Context(), data.ScopeEmailChange, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Retrieve the user that the token belongs to.
+	user, err := app.models.Users.Get(r.Context(), token.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Update the user's email address in the database.
+	user.Email = token.NewEmail
+	err = app.models.Users.Update(r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			// If the address has been taken since the change was requested, respond with a validation error.
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Delete all email-change tokens for the user now that the change has been made.
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), data.ScopeEmailChange, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etagFor(user.Version, user.ID))
+
+	// Respond with a 200 OK status and the updated user data in JSON format.
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"user": user}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listUsersHandler handles requests from administrators to list user accounts, with optional filtering by email
+// substring and activation status, sorting, and pagination.
+func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	// Define a struct to hold the input data from the URL query string.
+	var input struct {
+		Email     string
+		Activated *bool
+		data.Filters
+	}
+
+	// Initialize a new validator instance.
+	v := validator.New()
+	qs := r.URL.Query()
+
+	// Read query parameters for filtering, sorting, and pagination.
+	input.Email = app.readString(qs, "email", "")
+	input.Activated = app.readBool(qs, "activated", v)
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = data.UserSortSafelist
+
+	// Validate the filters.
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Retrieve the matching users.
+	users, metadata, err := app.models.Users.GetAll(r.Context(), input.Email, input.Activated, input.Filters)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidSort):
+			v.AddError("sort", "invalid sort value")
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Add links to the neighbouring pages, based on the URL of this request.
+	metadata.SetPageURLs(r.URL)
+
+	// Respond with a 200 OK status and the list of users along with metadata in JSON format.
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"users": users, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
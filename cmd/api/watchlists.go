@@ -0,0 +1,114 @@
+package main
+
+import (
+	"cinevault.interimme.net/internal/data"
+	"cinevault.interimme.net/internal/validator"
+	"errors"
+	"net/http"
+)
+
+// addToWatchlistHandler handles requests to add a movie to the authenticated user's watchlist. Adding a movie
+// that is already on the watchlist succeeds without changing anything.
+func (app *application) addToWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	// Extract the movie ID from the URL parameters.
+	movieID, ok := app.readMovieIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	// Make sure the movie exists before adding it to the watchlist.
+	_, err := app.models.Movies.Get(r.Context(), movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Add the movie to the current user's watchlist.
+	err = app.models.Watchlists.Add(r.Context(), app.contextGetUser(r).ID, movieID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Respond with a 200 OK status and a message confirming the addition.
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "movie successfully added to watchlist"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// removeFromWatchlistHandler handles requests to remove a movie from the authenticated user's watchlist.
+func (app *application) removeFromWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	// Extract the movie ID from the URL parameters.
+	movieID, ok := app.readMovieIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	// Remove the movie from the current user's watchlist.
+	err := app.models.Watchlists.Remove(r.Context(), app.contextGetUser(r).ID, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Respond with a 200 OK status and a message confirming the removal.
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "movie successfully removed from watchlist"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listWatchlistHandler handles requests to list the movies on the authenticated user's watchlist.
+func (app *application) listWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	var filters data.Filters
+
+	// Initialize a new validator instance.
+	v := validator.New()
+	qs := r.URL.Query()
+
+	// Read query parameters for pagination and sorting. The most recently added movies come first by default.
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "-added_at")
+	filters.SortSafelist = data.WatchlistSortSafelist
+
+	// Validate the filters.
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Retrieve the movies on the current user's watchlist.
+	movies, metadata, err := app.models.Watchlists.GetAllForUser(r.Context(), app.contextGetUser(r).ID, filters)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidSort):
+			v.AddError("sort", "invalid sort value")
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Add links to the neighbouring pages, based on the URL of this request.
+	metadata.SetPageURLs(r.URL)
+
+	// Respond with a 200 OK status and the list of movies along with metadata in JSON format.
+	app.presentMovies(r, movies...)
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
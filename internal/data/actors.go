@@ -0,0 +1,231 @@
+package data
+
+import (
+	"cinevault.interimme.net/internal/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"time"
+)
+
+// Actor represents an actor who can be credited in the cast of movies.
+type Actor struct {
+	ID        int64     `json:"id"`      // Unique identifier for the actor.
+	CreatedAt time.Time `json:"-"`       // Timestamp when the actor was created. This field is not included in the JSON response.
+	Name      string    `json:"name"`    // The actor's name.
+	Version   int32     `json:"version"` // The version number of the actor record for optimistic concurrency control.
+}
+
+// CastMember represents an actor's role in a movie.
+type CastMember struct {
+	ActorID       int64  `json:"actor_id"`                 // ID of the actor playing the role.
+	Name          string `json:"name"`                     // The actor's name.
+	CharacterName string `json:"character_name,omitempty"` // The name of the character played. Omitted if not provided.
+	BillingOrder  int32  `json:"billing_order"`            // Position of the actor in the credits, starting from 1.
+}
+
+// ValidateActor validates the fields of an Actor struct to ensure they meet the required criteria.
+func ValidateActor(v *validator.Validator, actor *Actor) {
+	v.CheckCoded(actor.Name != "", "name", validator.CodeRequired, "must be provided")
+	v.CheckCoded(len(actor.Name) <= 500, "name", validator.CodeTooLong, "must not be more than 500 bytes long")
+}
+
+// ValidateCastMember validates the fields of a CastMember struct to ensure they meet the required criteria.
+func ValidateCastMember(v *validator.Validator, member *CastMember) {
+	v.CheckCoded(member.ActorID != 0, "actor_id", validator.CodeRequired, "must be provided")
+	v.CheckCoded(member.ActorID > 0, "actor_id", validator.CodeOutOfRange, "must be a positive integer")
+	v.CheckCoded(len(member.CharacterName) <= 500, "character_name", validator.CodeTooLong, "must not be more than 500 bytes long")
+	v.CheckCoded(member.BillingOrder != 0, "billing_order", validator.CodeRequired, "must be provided")
+	v.CheckCoded(member.BillingOrder > 0, "billing_order", validator.CodeOutOfRange, "must be a positive integer")
+}
+
+// ActorSortSafelist lists the sort values accepted when listing actors.
+var ActorSortSafelist = SortSafelist("id", "name")
+
+// ActorModel is the interface to the actors and movie casts in the database.
+type ActorModel interface {
+	Insert(ctx context.Context, actor *Actor) error
+	Get(ctx context.Context, id int64) (*Actor, error)
+	GetAll(ctx context.Context, name string, filters Filters) ([]*Actor, Metadata, error)
+	AddToCast(ctx context.Context, movieID int64, member *CastMember) error
+	RemoveFromCast(ctx context.Context, movieID, actorID int64) error
+	GetCastForMovies(ctx context.Context, movieIDs []int64) (map[int64][]*CastMember, error)
+}
+
+// actorModel is the PostgreSQL implementation of ActorModel.
+type actorModel struct {
+	DB     *DB // Database connection pool, used for writes.
+	ReadDB *DB // Connection pool used for reads, which is the read replica if one is configured.
+}
+
+// Insert adds a new actor record to the database.
+func (m actorModel) Insert(ctx context.Context, actor *Actor) error {
+	query := `
+INSERT INTO actors (name)
+VALUES ($1)
+RETURNING id, created_at, version`
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	// Execute the query and scan the returned id, created_at, and version into the actor struct.
+	return m.DB.QueryRowContext(ctx, query, actor.Name).Scan(&actor.ID, &actor.CreatedAt, &actor.Version)
+}
+
+// Get retrieves a specific actor record from the database by its ID.
+func (m actorModel) Get(ctx context.Context, id int64) (*Actor, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound // Return an error if the ID is invalid.
+	}
+
+	query := `
+SELECT id, created_at, name, version
+FROM actors
+WHERE id = $1`
+	var actor Actor
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	// Execute the query and scan the result into an actor struct.
+	err := m.ReadDB.QueryRowContext(ctx, query, id).Scan(&actor.ID, &actor.CreatedAt, &actor.Name, &actor.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound // Return a custom error if no rows are found.
+		default:
+			return nil, err
+		}
+	}
+	return &actor, nil
+}
+
+// GetAll retrieves actors whose name contains the name string (ignoring case), or all actors if it is empty,
+// applying pagination and sorting.
+func (m actorModel) GetAll(ctx context.Context, name string, filters Filters) ([]*Actor, Metadata, error) {
+	// Build the ORDER BY clause, which fails if the sort parameter contains a term outside the safelist.
+	orderBy, err := filters.orderBy()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), id, created_at, name, version
+FROM actors
+WHERE (name ILIKE '%%' || $1 || '%%' OR $1 = '')
+ORDER BY %s
+LIMIT $2 OFFSET $3`, orderBy)
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.ReadDB.QueryContext(ctx, query, escapeLike(name), filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	actors := []*Actor{}
+	// Loop through the result set and scan each row into an Actor struct.
+	for rows.Next() {
+		var actor Actor
+		err := rows.Scan(&totalRecords, &actor.ID, &actor.CreatedAt, &actor.Name, &actor.Version)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		actors = append(actors, &actor)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	// Calculate pagination metadata for the result set.
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return actors, metadata, nil
+}
+
+// AddToCast credits an actor in the cast of a movie. If the actor is already in the cast, their character name
+// and billing order are replaced. Both the movie and the actor must exist.
+func (m actorModel) AddToCast(ctx context.Context, movieID int64, member *CastMember) error {
+	query := `
+INSERT INTO movie_cast (movie_id, actor_id, character_name, billing_order)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (movie_id, actor_id) DO UPDATE
+SET character_name = EXCLUDED.character_name, billing_order = EXCLUDED.billing_order`
+	args := []interface{}{movieID, member.ActorID, member.CharacterName, member.BillingOrder}
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// RemoveFromCast removes an actor from the cast of a movie, returning ErrRecordNotFound if they weren't in it.
+func (m actorModel) RemoveFromCast(ctx context.Context, movieID, actorID int64) error {
+	query := `
+DELETE FROM movie_cast
+WHERE movie_id = $1 AND actor_id = $2`
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	// Execute the delete query.
+	result, err := m.DB.ExecContext(ctx, query, movieID, actorID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound // Return a custom error if no rows are affected.
+	}
+	return nil
+}
+
+// GetCastForMovies retrieves the casts of several movies in a single query, keyed by movie ID. Each cast is
+// ordered by billing order. Movies without a cast have no entry in the map.
+func (m actorModel) GetCastForMovies(ctx context.Context, movieIDs []int64) (map[int64][]*CastMember, error) {
+	query := `
+SELECT movie_cast.movie_id, actors.id, actors.name, movie_cast.character_name, movie_cast.billing_order
+FROM movie_cast
+INNER JOIN actors ON actors.id = movie_cast.actor_id
+WHERE movie_cast.movie_id = ANY($1)
+ORDER BY movie_cast.movie_id, movie_cast.billing_order, actors.id`
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.ReadDB.QueryContext(ctx, query, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	casts := make(map[int64][]*CastMember)
+	// Loop through the result set and add each row to the cast of its movie.
+	for rows.Next() {
+		var movieID int64
+		var member CastMember
+		err := rows.Scan(&movieID, &member.ActorID, &member.Name, &member.CharacterName, &member.BillingOrder)
+		if err != nil {
+			return nil, err
+		}
+		casts[movieID] = append(casts[movieID], &member)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return casts, nil
+}
@@ -0,0 +1,204 @@
+package data
+
+import (
+	"cinevault.interimme.net/internal/validator"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"github.com/lib/pq"
+	"time"
+)
+
+// APIKey represents a long-lived key that a machine client can authenticate with in place of a user's session.
+// Requests made with a key are limited to its scopes, which are permission codes, as well as to the permissions of
+// the user it belongs to; a scope the user doesn't hold grants nothing.
+type APIKey struct {
+	ID         int64       `json:"id"`            // Unique identifier of the key.
+	Plaintext  string      `json:"key,omitempty"` // Plaintext key, only set when the key is created.
+	Hash       []byte      `json:"-"`             // Hash of the plaintext key (not included in JSON output).
+	HashScheme string      `json:"-"`             // Scheme the hash was made with, such as sha256 (not included in JSON output).
+	Identifier string      `json:"identifier"`    // First bytes of the key's hash, in hexadecimal.
+	UserID     int64       `json:"-"`             // ID of the user the key belongs to (not included in JSON output).
+	Name       string      `json:"name"`          // Name given to the key by its owner, to tell their keys apart.
+	Scopes     Permissions `json:"scopes"`        // Permission codes the key may use.
+	CreatedAt  time.Time   `json:"created_at"`    // Timestamp when the key was created.
+	LastUsedAt *time.Time  `json:"last_used_at"`  // Timestamp when the key was last used, or nil if it never has been.
+}
+
+// apiKeyLength is the length of a plaintext API key: 32 random bytes encoded as base32 without padding, twice the
+// randomness of the opaque tokens, since keys don't expire.
+const apiKeyLength = 52
+
+// ValidateAPIKey checks the name and scopes of a new API key, requiring each scope to appear in known.
+func ValidateAPIKey(v *validator.Validator, key *APIKey, known Permissions) {
+	v.CheckCoded(key.Name != "", "name", validator.CodeRequired, "must be provided")
+	v.CheckCoded(len(key.Name) <= 100, "name", validator.CodeTooLong, "must not be more than 100 bytes long")
+
+	v.CheckCoded(len(key.Scopes) >= 1, "scopes", validator.CodeTooFew, "must contain at least 1 scope")
+	v.CheckCoded(validator.Unique(key.Scopes), "scopes", validator.CodeDuplicate, "must not contain duplicate values")
+	for _, scope := range key.Scopes {
+		if !known.Include(scope) {
+			v.AddError("scopes", "must only contain known permission codes")
+			return
+		}
+	}
+}
+
+// ValidateAPIKeyPlaintext validates that the provided API key plaintext meets the expected criteria.
+func ValidateAPIKeyPlaintext(v *validator.Validator, keyPlaintext string) {
+	v.Check(keyPlaintext != "", "key", "must be provided")
+	v.Check(len(keyPlaintext) == apiKeyLength, "key", "must be 52 bytes long")
+}
+
+// APIKeyModel is the interface to users' API keys in the database.
+type APIKeyModel interface {
+	Insert(ctx context.Context, key *APIKey) error
+	GetForKey(ctx context.Context, keyPlaintext string) (*APIKey, error)
+	GetAllForUser(ctx context.Context, userID int64) ([]*APIKey, error)
+	DeleteForUser(ctx context.Context, id, userID int64) error
+}
+
+// apiKeyModel is the PostgreSQL implementation of APIKeyModel.
+type apiKeyModel struct {
+	DB         *DB
+	ReadDB     *DB    // Connection pool used by GetAllForUser, which is the read replica if one is configured.
+	hashScheme string // Scheme new keys are hashed with, one of TokenHashSchemes.
+}
+
+// Insert generates a random key for the given API key, whose user ID, name, and scopes must be set, and adds it to
+// the database. The plaintext key is set on the struct so that it can be shown to the user, which is the only
+// time it is available; only its hash is stored.
+func (m apiKeyModel) Insert(ctx context.Context, key *APIKey) error {
+	// Create 32 random bytes and encode them as the plaintext key.
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+	key.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	key.HashScheme = m.hashScheme
+	key.Hash = hashToken(m.hashScheme, key.Plaintext)
+	key.Identifier = hex.EncodeToString(key.Hash[:4])
+
+	query := `
+INSERT INTO api_keys (user_id, name, hash, hash_scheme, scopes)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id, created_at`
+
+	args := []interface{}{key.UserID, key.Name, key.Hash, key.HashScheme, pq.Array([]string(key.Scopes))}
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+}
+
+// GetForKey retrieves the API key with the given plaintext value, returning ErrRecordNotFound if there is none. It
+// records the key as used, so that its owner can see which of their keys are still in use.
+func (m apiKeyModel) GetForKey(ctx context.Context, keyPlaintext string) (*APIKey, error) {
+	// Hash the plaintext key with each scheme to match the stored value.
+	schemes, hashes := tokenHashes(keyPlaintext)
+
+	query := `
+UPDATE api_keys
+SET last_used_at = NOW()
+WHERE (hash_scheme, hash) IN (SELECT * FROM unnest($1::text[], $2::bytea[]))
+RETURNING id, hash, hash_scheme, user_id, name, scopes, created_at, last_used_at`
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var key APIKey
+	err := m.DB.QueryRowContext(ctx, query, schemes, hashes).Scan(
+		&key.ID,
+		&key.Hash,
+		&key.HashScheme,
+		&key.UserID,
+		&key.Name,
+		(*pq.StringArray)(&key.Scopes),
+		&key.CreatedAt,
+		&key.LastUsedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	key.Identifier = hex.EncodeToString(key.Hash[:4])
+	return &key, nil
+}
+
+// GetAllForUser retrieves the API keys of a user, newest first. The plaintext keys are not available.
+func (m apiKeyModel) GetAllForUser(ctx context.Context, userID int64) ([]*APIKey, error) {
+	query := `
+SELECT id, substring(hash from 1 for 4), name, scopes, created_at, last_used_at
+FROM api_keys
+WHERE user_id = $1
+ORDER BY created_at DESC, id DESC`
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.ReadDB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		key := APIKey{UserID: userID}
+		var hashPrefix []byte
+		err := rows.Scan(
+			&key.ID,
+			&hashPrefix,
+			&key.Name,
+			(*pq.StringArray)(&key.Scopes),
+			&key.CreatedAt,
+			&key.LastUsedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		key.Identifier = hex.EncodeToString(hashPrefix)
+		keys = append(keys, &key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// DeleteForUser revokes the API key with the given ID, provided it belongs to the given user. It returns
+// ErrRecordNotFound if the user has no key with that ID, so that users can't probe each other's keys.
+func (m apiKeyModel) DeleteForUser(ctx context.Context, id, userID int64) error {
+	query := `
+DELETE FROM api_keys
+WHERE id = $1 AND user_id = $2`
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Define the actions and entity types recorded in the audit log.
+const (
+	AuditActionCreate = "create" // A record was created; the old value is null.
+	AuditActionUpdate = "update" // A record was changed.
+	AuditActionDelete = "delete" // A record was deleted; the new value is null.
+
+	AuditEntityMovie = "movie" // The entity is a movie, identified by its ID.
+)
+
+// AuditEntry represents a single change recorded in the audit log.
+type AuditEntry struct {
+	ID         int64           `json:"id"`          // Unique identifier for the entry.
+	CreatedAt  time.Time       `json:"created_at"`  // Timestamp when the change was made.
+	UserID     *int64          `json:"user_id"`     // ID of the user who made the change, or null if the user has since been deleted.
+	Action     string          `json:"action"`      // What was done, such as "create", "update", or "delete".
+	EntityType string          `json:"entity_type"` // The type of record that was changed, such as "movie".
+	EntityID   int64           `json:"entity_id"`   // The ID of the record that was changed.
+	OldValue   json.RawMessage `json:"old_value"`   // The record before the change, as it appears in API responses.
+	NewValue   json.RawMessage `json:"new_value"`   // The record after the change, as it appears in API responses.
+}
+
+// AuditSortSafelist lists the sort values accepted when listing the audit log.
+var AuditSortSafelist = SortSafelist("id")
+
+// AuditModel is the interface to the audit log in the database.
+type AuditModel interface {
+	Record(ctx context.Context, userID int64, action, entityType string, entityID int64, oldValue, newValue interface{}) error
+	GetAll(ctx context.Context, entityType string, entityID int64, filters Filters) ([]*AuditEntry, Metadata, error)
+}
+
+// auditModel is the PostgreSQL implementation of AuditModel.
+type auditModel struct {
+	DB     *DB // Database connection pool, used for writes.
+	ReadDB *DB // Connection pool used for reads, which is the read replica if one is configured.
+}
+
+// Record adds an entry to the audit log. The old and new values are marshalled with encoding/json, so they are
+// stored in the same form that the API returns them in; pass nil for a value that doesn't exist, such as the old
+// value of a newly created record.
+func (m auditModel) Record(ctx context.Context, userID int64, action, entityType string, entityID int64, oldValue, newValue interface{}) error {
+	oldJSON, err := marshalAuditValue(oldValue)
+	if err != nil {
+		return err
+	}
+	newJSON, err := marshalAuditValue(newValue)
+	if err != nil {
+		return err
+	}
+
+	query := `
+INSERT INTO audit_log (user_id, action, entity_type, entity_id, old_value, new_value)
+VALUES ($1, $2, $3, $4, $5, $6)`
+	args := []interface{}{userID, action, entityType, entityID, oldJSON, newJSON}
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// marshalAuditValue encodes a value for a JSONB column, returning nil (stored as NULL) for a nil value.
+func marshalAuditValue(value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	js, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return string(js), nil
+}
+
+// GetAll retrieves audit log entries, newest first, applying pagination and sorting. An empty entity type or a
+// zero entity ID matches every entry.
+func (m auditModel) GetAll(ctx context.Context, entityType string, entityID int64, filters Filters) ([]*AuditEntry, Metadata, error) {
+	// Build the ORDER BY clause, which fails if the sort parameter contains a term outside the safelist.
+	orderBy, err := filters.orderBy()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), id, created_at, user_id, action, entity_type, entity_id, old_value, new_value
+FROM audit_log
+WHERE (entity_type = $1 OR $1 = '')
+AND (entity_id = $2 OR $2 = 0)
+ORDER BY %s
+LIMIT $3 OFFSET $4`, orderBy)
+	args := []interface{}{entityType, entityID, filters.limit(), filters.offset()}
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.ReadDB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*AuditEntry{}
+	// Loop through the result set and scan each row into an AuditEntry struct.
+	for rows.Next() {
+		var entry AuditEntry
+		var oldValue, newValue []byte // Scanned separately, since either may be NULL.
+		err := rows.Scan(
+			&totalRecords,
+			&entry.ID,
+			&entry.CreatedAt,
+			&entry.UserID,
+			&entry.Action,
+			&entry.EntityType,
+			&entry.EntityID,
+			&oldValue,
+			&newValue,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		entry.OldValue, entry.NewValue = oldValue, newValue
+		entries = append(entries, &entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	// Calculate pagination metadata for the result set.
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return entries, metadata, nil
+}
@@ -0,0 +1,199 @@
+package data
+
+import (
+	"container/list"
+	"expvar"
+	"sync"
+	"time"
+)
+
+// Cache is a fixed-size, least recently used cache of values keyed by record ID, used by the models to avoid
+// querying the database for records that are read often, such as popular movies or the permissions of active users.
+// Entries expire after a time to live, so that changes made by other instances of the application are eventually
+// seen. A value that is invalidated leaves a marker behind, so that the next read can go to the primary database
+// instead of a read replica that may not have seen the change yet. It is safe for concurrent use, and a nil *Cache is
+// a valid cache that never holds anything.
+type Cache[V any] struct {
+	mu      sync.Mutex              // Guards the fields below, including the order of the list, which reads change.
+	size    int                     // Maximum number of values held.
+	ttl     time.Duration           // How long a value is held for, or 0 to hold it until it is evicted.
+	entries map[int64]*list.Element // Maps IDs to their elements in order.
+	order   *list.List              // Cache entries, most recently used first.
+
+	Hits   *expvar.Int // Number of lookups answered from the cache.
+	Misses *expvar.Int // Number of lookups that had to go to the database.
+}
+
+// cacheEntry is a value held in a Cache, along with its ID and the time it expires.
+type cacheEntry[V any] struct {
+	id          int64
+	value       V
+	expires     time.Time // Zero if the entry never expires.
+	invalidated bool      // Set if the value was invalidated, in which case the entry holds no value.
+}
+
+// NewCache returns a cache holding up to size values, each for at most ttl, or forever if ttl is 0. It returns nil,
+// which caches nothing, if size is 0 or less.
+func NewCache[V any](size int, ttl time.Duration) *Cache[V] {
+	if size <= 0 {
+		return nil
+	}
+	return &Cache[V]{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[int64]*list.Element),
+		order:   list.New(),
+		Hits:    new(expvar.Int),
+		Misses:  new(expvar.Int),
+	}
+}
+
+// get returns the cached value with the given ID, and whether there was one that had not expired. Values are
+// shared with the cache, so callers must copy them before handing them out.
+func (c *Cache[V]) get(id int64) (V, bool) {
+	var zero V
+	if c == nil {
+		return zero, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[id]
+	if ok && c.expired(element.Value.(*cacheEntry[V])) {
+		c.removeElement(element)
+		ok = false
+	}
+	if !ok || element.Value.(*cacheEntry[V]).invalidated {
+		c.Misses.Add(1)
+		return zero, false
+	}
+
+	c.Hits.Add(1)
+	c.order.MoveToFront(element)
+	return element.Value.(*cacheEntry[V]).value, true
+}
+
+// put caches a value under the given ID, evicting the least recently used value if the cache is full. If replace is
+// not nil and a value that has not expired is already cached, the new value is only stored if replace, called with
+// the cached value, returns true. Unless primary is set, meaning that the value was read from or written to the
+// primary database, a value invalidated since is not replaced either, since the value may have been read from a
+// replica that is behind the change.
+func (c *Cache[V]) put(id int64, value V, primary bool, replace func(cached V) bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &cacheEntry[V]{id: id, value: value}
+	if c.ttl > 0 {
+		entry.expires = time.Now().Add(c.ttl)
+	}
+
+	if element, ok := c.entries[id]; ok {
+		cached := element.Value.(*cacheEntry[V])
+		if !c.expired(cached) {
+			if cached.invalidated && !primary {
+				return
+			}
+			if !cached.invalidated && replace != nil && !replace(cached.value) {
+				return
+			}
+		}
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[id] = c.order.PushFront(entry)
+	if c.order.Len() > c.size {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// invalidate drops the value with the given ID from the cache, after a change to it in the database. Until the time
+// to live has passed, or a value from the primary database is put in its place, invalidated reports the ID, and
+// values read from a replica are not cached for it.
+func (c *Cache[V]) invalidate(id int64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &cacheEntry[V]{id: id, invalidated: true}
+	if c.ttl > 0 {
+		entry.expires = time.Now().Add(c.ttl)
+	}
+	if element, ok := c.entries[id]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[id] = c.order.PushFront(entry)
+	if c.order.Len() > c.size {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// invalidated reports whether the value with the given ID was invalidated and nothing has been cached for it since,
+// in which case it should be read from the primary database, as a replica may not have seen the change yet.
+func (c *Cache[V]) invalidated(id int64) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[id]
+	if !ok {
+		return false
+	}
+	entry := element.Value.(*cacheEntry[V])
+	return entry.invalidated && !c.expired(entry)
+}
+
+// Len returns the number of entries in the cache, including any that have expired but not yet been dropped and the
+// markers left by invalidated values.
+func (c *Cache[V]) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// expired reports whether a cache entry has outlived the time to live. The caller must hold c.mu.
+func (c *Cache[V]) expired(entry *cacheEntry[V]) bool {
+	return !entry.expires.IsZero() && time.Now().After(entry.expires)
+}
+
+// removeElement drops an element from the cache. The caller must hold c.mu.
+func (c *Cache[V]) removeElement(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*cacheEntry[V]).id)
+}
+
+// copyMovie returns a copy of the fields of a movie that Get loads, so that neither the cache nor its callers can
+// change the other's copy. The cast and view count, which Get does not load, are left out.
+func copyMovie(movie *Movie) *Movie {
+	c := *movie
+	c.Cast = nil
+	c.Views = 0
+	if movie.Genres != nil {
+		c.Genres = append([]string{}, movie.Genres...)
+	}
+	if movie.Tags != nil {
+		c.Tags = append([]string{}, movie.Tags...)
+	}
+	if movie.ReleaseDate != nil {
+		releaseDate := *movie.ReleaseDate
+		c.ReleaseDate = &releaseDate
+	}
+	if movie.AverageRating != nil {
+		rating := *movie.AverageRating
+		c.AverageRating = &rating
+	}
+	return &c
+}
@@ -0,0 +1,73 @@
+package data
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ErrInvalidDateFormat is an error that indicates the date format is invalid.
+var ErrInvalidDateFormat = errors.New("invalid date format")
+
+// DateLayout is the layout of dates in JSON, as accepted by time.Parse.
+const DateLayout = "2006-01-02"
+
+// Date is a custom type that represents a calendar date with no time of day, such as the release date of a movie.
+// It is stored in a DATE column and encoded in JSON as a "YYYY-MM-DD" string.
+type Date struct {
+	time.Time // Midnight UTC at the start of the date.
+}
+
+// NewDate returns the Date for the given year, month, and day.
+func NewDate(year int, month time.Month, day int) Date {
+	return Date{time.Date(year, month, day, 0, 0, 0, 0, time.UTC)}
+}
+
+// String returns the date in the format "YYYY-MM-DD".
+func (d Date) String() string {
+	return d.Format(DateLayout)
+}
+
+// MarshalJSON implements the json.Marshaler interface for the Date type.
+// It converts the Date value to a JSON-encoded string in the format "YYYY-MM-DD".
+func (d Date) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(d.String())), nil
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface for the Date type.
+// It parses a JSON-encoded string in the format "YYYY-MM-DD" and converts it to a Date value.
+func (d *Date) UnmarshalJSON(jsonValue []byte) error {
+	// Remove the surrounding quotes from the JSON string value.
+	unquotedJSONValue, err := strconv.Unquote(string(jsonValue))
+	if err != nil {
+		return ErrInvalidDateFormat // Return an error if the value is not a string.
+	}
+
+	// Parse the date, which must be a real date, so that "2023-02-30" is rejected.
+	t, err := time.Parse(DateLayout, unquotedJSONValue)
+	if err != nil {
+		return ErrInvalidDateFormat
+	}
+
+	*d = Date{t}
+	return nil
+}
+
+// Scan implements the sql.Scanner interface for the Date type, reading a DATE column. A NULL column should be
+// scanned into a *Date, which is left nil.
+func (d *Date) Scan(src interface{}) error {
+	t, ok := src.(time.Time)
+	if !ok {
+		return fmt.Errorf("cannot scan %T into a Date", src)
+	}
+	*d = NewDate(t.Date())
+	return nil
+}
+
+// Value implements the driver.Valuer interface for the Date type, so that a Date can be written to a DATE column.
+// A nil *Date is written as NULL.
+func (d Date) Value() (driver.Value, error) {
+	return d.String(), nil
+}
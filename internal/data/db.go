@@ -0,0 +1,85 @@
+package data
+
+import (
+	"cinevault.interimme.net/internal/jsonlog"
+	"context"
+	"database/sql"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DB wraps a sql.DB connection pool and times the queries run through it, logging any that take longer than
+// the slow query threshold. The models call QueryContext, QueryRowContext, and ExecContext on it exactly as they
+// would on a sql.DB. Queries run inside a transaction are not timed.
+type DB struct {
+	*sql.DB                       // Underlying database connection pool.
+	logger        *jsonlog.Logger // Logger that slow queries are reported to.
+	slowThreshold time.Duration   // Queries taking at least this long are logged; zero disables slow query logging.
+}
+
+// NewDB returns a DB that logs queries on db taking at least slowThreshold to logger at the WARN level.
+func NewDB(db *sql.DB, logger *jsonlog.Logger, slowThreshold time.Duration) *DB {
+	return &DB{
+		DB:            db,
+		logger:        logger,
+		slowThreshold: slowThreshold,
+	}
+}
+
+// QueryContext executes a query that returns rows, timing how long it takes for the first results to arrive.
+func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	db.observe(start)
+	return rows, err
+}
+
+// QueryRowContext executes a query that is expected to return at most one row, timing how long it takes.
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	start := time.Now()
+	row := db.DB.QueryRowContext(ctx, query, args...)
+	db.observe(start)
+	return row
+}
+
+// ExecContext executes a query that doesn't return rows, timing how long it takes.
+func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	result, err := db.DB.ExecContext(ctx, query, args...)
+	db.observe(start)
+	return result, err
+}
+
+// observe logs the query that started at start if it was slow. It must be called directly from one of the query
+// methods above, as the name of the model method that ran the query is taken from the call stack.
+func (db *DB) observe(start time.Time) {
+	duration := time.Since(start)
+	if db.logger == nil || db.slowThreshold <= 0 || duration < db.slowThreshold {
+		return
+	}
+
+	db.logger.PrintWarn("slow query", map[string]string{
+		"query":       queryName(3),
+		"duration_ms": strconv.FormatInt(duration.Milliseconds(), 10),
+	})
+}
+
+// queryName returns the name of the function skip frames up the call stack, such as "movieModel.Get", which
+// identifies the query without logging its SQL or arguments.
+func queryName(skip int) string {
+	pc, _, _, ok := runtime.Caller(skip)
+	if !ok {
+		return "unknown"
+	}
+	fn := runtime.FuncForPC(pc)
+	if fn == nil {
+		return "unknown"
+	}
+
+	// Strip the package path, e.g. "cinevault.interimme.net/internal/data.movieModel.Get" becomes "movieModel.Get".
+	name := fn.Name()
+	name = name[strings.LastIndex(name, "/")+1:]
+	return strings.TrimPrefix(name, "data.")
+}
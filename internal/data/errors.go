@@ -0,0 +1,56 @@
+package data
+
+import (
+	"errors"
+	"github.com/lib/pq"
+)
+
+// pgUniqueViolation is the SQLSTATE code PostgreSQL reports when a statement violates a unique constraint.
+const pgUniqueViolation pq.ErrorCode = "23505"
+
+// DBError is an error reported by PostgreSQL, wrapping the underlying *pq.Error along with its SQLSTATE code and
+// the name of the constraint involved, if any. Kind holds the sentinel error the model translated it to, such as
+// ErrDuplicateEmail, so that errors.Is matches the sentinel while errors.As can still reach the *pq.Error.
+type DBError struct {
+	Kind       error        // The sentinel error the failure corresponds to, or nil if there is none.
+	Code       pq.ErrorCode // The SQLSTATE code of the error, e.g. 23505 for a unique violation.
+	Constraint string       // The name of the constraint that was violated, if any.
+	Err        *pq.Error    // The underlying error returned by the driver.
+}
+
+// Error returns the message of the sentinel error if there is one, and of the underlying error otherwise.
+func (e *DBError) Error() string {
+	if e.Kind != nil {
+		return e.Kind.Error()
+	}
+	return e.Err.Error()
+}
+
+// Unwrap returns the sentinel error, if any, and the underlying *pq.Error, for use by errors.Is and errors.As.
+func (e *DBError) Unwrap() []error {
+	if e.Kind != nil {
+		return []error{e.Kind, e.Err}
+	}
+	return []error{e.Err}
+}
+
+// isViolation reports whether err is a PostgreSQL error with the given SQLSTATE code, raised by the named
+// constraint. Comparing the code and constraint name rather than the message keeps the check working regardless
+// of the server's version and locale.
+func isViolation(err error, code pq.ErrorCode, constraint string) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == code && pqErr.Constraint == constraint
+}
+
+// wrapError wraps a PostgreSQL error in a *DBError identified as kind, which may be nil. If err isn't a
+// PostgreSQL error, kind is returned if it is set, and err otherwise.
+func wrapError(err error, kind error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		if kind != nil {
+			return kind
+		}
+		return err
+	}
+	return &DBError{Kind: kind, Code: pqErr.Code, Constraint: pqErr.Constraint, Err: pqErr}
+}
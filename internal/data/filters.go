@@ -2,25 +2,76 @@ package data
 
 import (
 	"cinevault.interimme.net/internal/validator"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
+)
+
+// Define the errors returned when the pagination and sorting options are unusable.
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")         // Error when a pagination cursor cannot be decoded.
+	ErrInvalidSort   = errors.New("invalid sort parameter") // Error when a sort term is not in the safelist.
 )
 
 // Filters represents pagination and sorting options for database queries.
 type Filters struct {
 	Page         int      // Current page number.
 	PageSize     int      // Number of items per page.
-	Sort         string   // Field to sort by, possibly prefixed with '-' for descending order.
+	Sort         string   // Comma-separated fields to sort by, each possibly prefixed with '-' for descending order.
 	SortSafelist []string // List of allowed fields that can be used for sorting.
+	Cursor       string   // Opaque keyset cursor; when set, it takes precedence over Page.
+	YearMin      int      // Earliest release year to include, or 0 for no lower bound.
+	YearMax      int      // Latest release year to include, or 0 for no upper bound.
+	Tags         []string // Tags every movie must have, or empty for no restriction. Must not be nil for movie queries.
+	Search       string   // General search terms matched against the words of movie titles and tags, or empty.
+
+	expressions map[string]string // SQL expressions to sort by for the sort fields that aren't plain columns, set by the model.
+}
+
+// cursor holds the sort values and ID of the last record seen by the client, used for keyset pagination.
+type cursor struct {
+	Values []string `json:"v"`  // The values of the sort columns for the last record, formatted as strings.
+	ID     int64    `json:"id"` // The ID of the last record, used as a tie-breaker.
+}
+
+// encodeCursor encodes the sort values and ID of a record into an opaque, URL-safe cursor string.
+func encodeCursor(values []string, id int64) string {
+	js, _ := json.Marshal(cursor{Values: values, ID: id}) // Marshalling strings and an int64 never fails.
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+// decodeCursor decodes a cursor string produced by encodeCursor, returning ErrInvalidCursor if it is malformed.
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+
+	err = json.Unmarshal(js, &c)
+	if err != nil || c.ID < 1 {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
 }
 
 // Metadata contains pagination metadata for a list of resources.
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`  // The current page number.
-	PageSize     int `json:"page_size,omitempty"`     // The size of each page.
-	FirstPage    int `json:"first_page,omitempty"`    // The first page number (typically 1).
-	LastPage     int `json:"last_page,omitempty"`     // The last page number, calculated from total records.
-	TotalRecords int `json:"total_records,omitempty"` // The total number of records across all pages.
+	CurrentPage  int    `json:"current_page,omitempty"`  // The current page number.
+	PageSize     int    `json:"page_size,omitempty"`     // The size of each page.
+	FirstPage    int    `json:"first_page,omitempty"`    // The first page number (typically 1).
+	LastPage     int    `json:"last_page,omitempty"`     // The last page number, calculated from total records.
+	TotalRecords int    `json:"total_records,omitempty"` // The total number of records across all pages.
+	NextCursor   string `json:"next_cursor,omitempty"`   // Cursor for fetching the next page, present only when more records exist.
+	NextPageURL  string `json:"next_page_url,omitempty"` // URL of the next page, present only when there is a next page.
+	PrevPageURL  string `json:"prev_page_url,omitempty"` // URL of the previous page, present only when there is a previous page.
 }
 
 // calculateMetadata calculates pagination metadata based on the total number of records, current page, and page size.
@@ -38,23 +89,98 @@ func calculateMetadata(totalRecords, page, pageSize int) Metadata {
 	}
 }
 
-// sortColumn returns the column to sort by, after verifying it's in the safelist.
-// If the sort value is not in the safelist, it panics.
-func (f Filters) sortColumn() string {
-	for _, safeValue := range f.SortSafelist {
-		if f.Sort == safeValue {
-			return strings.TrimPrefix(f.Sort, "-") // Remove '-' prefix if present.
+// SetPageURLs fills in the next and previous page URLs, built from the URL of the current request by changing its
+// page parameter. The URLs are relative, containing only the path and query string. Nothing is set in cursor mode,
+// where there are no page numbers.
+func (m *Metadata) SetPageURLs(current *url.URL) {
+	if m.CurrentPage == 0 {
+		return
+	}
+
+	pageURL := func(page int) string {
+		qs := current.Query()
+		qs.Set("page", strconv.Itoa(page))
+		u := url.URL{Path: current.Path, RawQuery: qs.Encode()}
+		return u.String()
+	}
+
+	if m.CurrentPage < m.LastPage {
+		m.NextPageURL = pageURL(m.CurrentPage + 1)
+	}
+	if m.CurrentPage > m.FirstPage {
+		m.PrevPageURL = pageURL(m.CurrentPage - 1)
+	}
+}
+
+// SortSafelist returns the sort values to allow for a resource that can be sorted by the given columns: each column
+// on its own for ascending order, followed by each column prefixed with '-' for descending order. Each resource
+// declares its safelist once with this, next to its model, for the handlers that list it to share.
+func SortSafelist(columns ...string) []string {
+	safelist := make([]string, 0, 2*len(columns))
+	safelist = append(safelist, columns...)
+	for _, column := range columns {
+		safelist = append(safelist, "-"+column)
+	}
+	return safelist
+}
+
+// sortTerms splits the sort parameter into its comma-separated terms, such as "-year" and "title".
+func (f Filters) sortTerms() []string {
+	return strings.Split(f.Sort, ",")
+}
+
+// sortColumns returns the columns to sort by, after verifying each term is in the safelist.
+// If any sort term is not in the safelist, it returns an error wrapping ErrInvalidSort. This should
+// already have been caught by ValidateFilters, but the check is repeated here because the columns are
+// interpolated into SQL.
+func (f Filters) sortColumns() ([]string, error) {
+	terms := f.sortTerms()
+	columns := make([]string, len(terms))
+	for i, term := range terms {
+		if !validator.In(term, f.SortSafelist...) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidSort, term)
+		}
+		columns[i] = strings.TrimPrefix(term, "-") // Remove '-' prefix if present.
+	}
+	return columns, nil
+}
+
+// sortExpression returns the SQL to sort by for the given column: the expression it is mapped to, if any, and the
+// column itself otherwise.
+func (f Filters) sortExpression(column string) string {
+	if expression, ok := f.expressions[column]; ok {
+		return expression
+	}
+	return column
+}
+
+// sortDirections returns the sorting direction ("ASC" or "DESC") of each sort term, based on its prefix.
+func (f Filters) sortDirections() []string {
+	terms := f.sortTerms()
+	directions := make([]string, len(terms))
+	for i, term := range terms {
+		directions[i] = "ASC"
+		if strings.HasPrefix(term, "-") {
+			directions[i] = "DESC"
 		}
 	}
-	panic("unsafe sort parameter: " + f.Sort) // Panic if sort parameter is not in the safelist.
+	return directions
 }
 
-// sortDirection returns the sorting direction ("ASC" or "DESC") based on the prefix of the sort parameter.
-func (f Filters) sortDirection() string {
-	if strings.HasPrefix(f.Sort, "-") {
-		return "DESC"
+// orderBy returns the contents of an ORDER BY clause for the sort terms, such as "year DESC, title ASC, id ASC".
+// The ID is always appended as a final tie-breaker so that the order is deterministic.
+func (f Filters) orderBy() (string, error) {
+	columns, err := f.sortColumns()
+	if err != nil {
+		return "", err
+	}
+	directions := f.sortDirections()
+
+	clauses := make([]string, 0, len(columns)+1)
+	for i := range columns {
+		clauses = append(clauses, f.sortExpression(columns[i])+" "+directions[i])
 	}
-	return "ASC"
+	return strings.Join(append(clauses, "id ASC"), ", "), nil
 }
 
 // ValidateFilters validates the Filters struct to ensure pagination and sorting parameters are valid.
@@ -67,8 +193,30 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
 
-	// Ensure that the sort parameter matches a value in the safelist.
-	v.Check(validator.In(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+	// Ensure that every sort term matches a value in the safelist, and that no field is used twice.
+	columns := make([]string, 0)
+	for _, term := range f.sortTerms() {
+		v.Check(validator.In(term, f.SortSafelist...), "sort", "invalid sort value")
+		columns = append(columns, strings.TrimPrefix(term, "-"))
+	}
+	v.Check(validator.Unique(columns), "sort", "must not contain duplicate fields")
+
+	// Check that the year bounds, if provided, are plausible release years and form a valid range.
+	if f.YearMin != 0 {
+		v.Checkf(f.YearMin >= 1888 && f.YearMin <= time.Now().Year(), "year_min", "must be between %d and %d", 1888, time.Now().Year())
+	}
+	if f.YearMax != 0 {
+		v.Checkf(f.YearMax >= 1888 && f.YearMax <= time.Now().Year(), "year_max", "must be between %d and %d", 1888, time.Now().Year())
+	}
+	if f.YearMin != 0 && f.YearMax != 0 {
+		v.Check(f.YearMin <= f.YearMax, "year_min", "must not be greater than year_max")
+	}
+
+	// Ensure that the cursor, if provided, can be decoded and has a value for each sort term.
+	if f.Cursor != "" {
+		c, err := decodeCursor(f.Cursor)
+		v.Check(err == nil && len(c.Values) == len(f.sortTerms()), "cursor", "invalid cursor value")
+	}
 }
 
 // limit returns the page size, which is the number of items per page.
@@ -77,6 +225,43 @@ func (f Filters) limit() int {
 }
 
 // offset calculates the starting point for the records to be retrieved based on the current page and page size.
+// In cursor mode the keyset condition does the skipping, so the offset is always zero.
 func (f Filters) offset() int {
+	if f.Cursor != "" {
+		return 0
+	}
 	return (f.Page - 1) * f.PageSize
 }
+
+// keysetCondition returns a SQL condition restricting results to the rows that come after the cursor in the
+// current sort order. The cursor's sort values are bound to consecutive placeholders starting at firstArg, and
+// its ID to the placeholder after them. The condition is expanded into the form
+// (a > $1) OR (a = $1 AND b < $2) OR (a = $1 AND b = $2 AND id > $3), so that each column can have its own
+// direction. Ties are always broken by ascending ID, matching the ORDER BY clause produced by orderBy.
+func (f Filters) keysetCondition(firstArg int) (string, error) {
+	columns, err := f.sortColumns()
+	if err != nil {
+		return "", err
+	}
+	directions := f.sortDirections()
+	idArg := firstArg + len(columns)
+
+	clauses := make([]string, 0, len(columns)+1)
+	for i := 0; i <= len(columns); i++ {
+		parts := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			parts = append(parts, fmt.Sprintf("%s = $%d", f.sortExpression(columns[j]), firstArg+j))
+		}
+		if i < len(columns) {
+			operator := ">"
+			if directions[i] == "DESC" {
+				operator = "<"
+			}
+			parts = append(parts, fmt.Sprintf("%s %s $%d", f.sortExpression(columns[i]), operator, firstArg+i))
+		} else {
+			parts = append(parts, fmt.Sprintf("id > $%d", idArg))
+		}
+		clauses = append(clauses, "("+strings.Join(parts, " AND ")+")")
+	}
+	return "(" + strings.Join(clauses, " OR ") + ")", nil
+}
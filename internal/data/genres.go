@@ -0,0 +1,162 @@
+package data
+
+import (
+	"cinevault.interimme.net/internal/validator"
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrDuplicateGenre is returned when trying to add a genre that already exists in the canonical list.
+var ErrDuplicateGenre = errors.New("duplicate genre")
+
+// Genre represents an entry in the canonical list of genres, along with the number of movies tagged with it.
+type Genre struct {
+	Name       string `json:"name"`        // The name of the genre.
+	MovieCount int    `json:"movie_count"` // The number of movies that include this genre.
+}
+
+// ValidateGenreName checks that a genre name meets the application's validation criteria.
+func ValidateGenreName(v *validator.Validator, name string) {
+	v.Check(name != "", "name", "must be provided")
+	v.Check(len(name) <= 100, "name", "must not be more than 100 bytes long")
+}
+
+// ValidateMovieGenres checks that every genre of a movie appears in the canonical list of genres.
+func ValidateMovieGenres(v *validator.Validator, genres []string, canonical []string) {
+	for _, genre := range genres {
+		if !validator.In(genre, canonical...) {
+			v.AddError("genres", "must only contain known genres")
+			return
+		}
+	}
+}
+
+// GenreModel is the interface to the genres in the database.
+type GenreModel interface {
+	GetAll(ctx context.Context) ([]*Genre, error)
+	GetAllNames(ctx context.Context) ([]string, error)
+	Insert(ctx context.Context, name string) error
+	Delete(ctx context.Context, name string) error
+}
+
+// genreModel is the PostgreSQL implementation of GenreModel.
+type genreModel struct {
+	DB     *DB // Database connection pool, used for writes.
+	ReadDB *DB // Connection pool used for reads, which is the read replica if one is configured.
+}
+
+// GetAll retrieves every genre in the canonical list, in alphabetical order, together with its movie count.
+func (m genreModel) GetAll(ctx context.Context) ([]*Genre, error) {
+	// Unnest each movie's genres array so that movies can be joined and counted per genre.
+	query := `
+SELECT genres.name, count(movie_genres.id)
+FROM genres
+LEFT JOIN (SELECT id, unnest(genres) AS genre FROM movies) AS movie_genres
+ON movie_genres.genre = genres.name
+GROUP BY genres.name
+ORDER BY genres.name`
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.ReadDB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	genres := []*Genre{}
+	// Loop through the result set and scan each row into a Genre struct.
+	for rows.Next() {
+		var genre Genre
+		err := rows.Scan(&genre.Name, &genre.MovieCount)
+		if err != nil {
+			return nil, err
+		}
+		genres = append(genres, &genre)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return genres, nil
+}
+
+// GetAllNames retrieves the names of every genre in the canonical list, without counting movies.
+func (m genreModel) GetAllNames(ctx context.Context) ([]string, error) {
+	query := `
+SELECT name
+FROM genres
+ORDER BY name`
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		err := rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// Insert adds a new genre to the canonical list, returning ErrDuplicateGenre if it already exists.
+func (m genreModel) Insert(ctx context.Context, name string) error {
+	query := `
+INSERT INTO genres (name)
+VALUES ($1)`
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, name)
+	if err != nil {
+		switch {
+		case isViolation(err, pgUniqueViolation, "genres_name_key"):
+			return wrapError(err, ErrDuplicateGenre) // Return a specific error if the genre already exists.
+		default:
+			return wrapError(err, nil)
+		}
+	}
+	return nil
+}
+
+// Delete removes a genre from the canonical list. Movies already tagged with the genre are left unchanged.
+func (m genreModel) Delete(ctx context.Context, name string) error {
+	query := `
+DELETE FROM genres
+WHERE name = $1`
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, name)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound // Return a custom error if the genre was not found.
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package mock
+
+import (
+	"cinevault.interimme.net/internal/data"
+	"context"
+)
+
+// ActorModel is a mock data.ActorModel for actors and movie casts. Each method calls the function in the field of
+// the same name with a Func suffix, or returns zero values and a nil error if the field is nil. Without a function,
+// Get reports data.ErrRecordNotFound instead, like a database with no records.
+type ActorModel struct {
+	InsertFunc           func(ctx context.Context, actor *data.Actor) error
+	GetFunc              func(ctx context.Context, id int64) (*data.Actor, error)
+	GetAllFunc           func(ctx context.Context, name string, filters data.Filters) ([]*data.Actor, data.Metadata, error)
+	AddToCastFunc        func(ctx context.Context, movieID int64, member *data.CastMember) error
+	RemoveFromCastFunc   func(ctx context.Context, movieID, actorID int64) error
+	GetCastForMoviesFunc func(ctx context.Context, movieIDs []int64) (map[int64][]*data.CastMember, error)
+}
+
+// Insert calls InsertFunc, if it is set.
+func (m ActorModel) Insert(ctx context.Context, actor *data.Actor) error {
+	if m.InsertFunc != nil {
+		return m.InsertFunc(ctx, actor)
+	}
+	return nil
+}
+
+// Get calls GetFunc, if it is set.
+func (m ActorModel) Get(ctx context.Context, id int64) (*data.Actor, error) {
+	if m.GetFunc != nil {
+		return m.GetFunc(ctx, id)
+	}
+	return nil, data.ErrRecordNotFound
+}
+
+// GetAll calls GetAllFunc, if it is set.
+func (m ActorModel) GetAll(ctx context.Context, name string, filters data.Filters) ([]*data.Actor, data.Metadata, error) {
+	if m.GetAllFunc != nil {
+		return m.GetAllFunc(ctx, name, filters)
+	}
+	return nil, data.Metadata{}, nil
+}
+
+// AddToCast calls AddToCastFunc, if it is set.
+func (m ActorModel) AddToCast(ctx context.Context, movieID int64, member *data.CastMember) error {
+	if m.AddToCastFunc != nil {
+		return m.AddToCastFunc(ctx, movieID, member)
+	}
+	return nil
+}
+
+// RemoveFromCast calls RemoveFromCastFunc, if it is set.
+func (m ActorModel) RemoveFromCast(ctx context.Context, movieID, actorID int64) error {
+	if m.RemoveFromCastFunc != nil {
+		return m.RemoveFromCastFunc(ctx, movieID, actorID)
+	}
+	return nil
+}
+
+// GetCastForMovies calls GetCastForMoviesFunc, if it is set.
+func (m ActorModel) GetCastForMovies(ctx context.Context, movieIDs []int64) (map[int64][]*data.CastMember, error) {
+	if m.GetCastForMoviesFunc != nil {
+		return m.GetCastForMoviesFunc(ctx, movieIDs)
+	}
+	return nil, nil
+}
@@ -0,0 +1,48 @@
+package mock
+
+import (
+	"cinevault.interimme.net/internal/data"
+	"context"
+)
+
+// APIKeyModel is a mock data.APIKeyModel for users' API keys. Each method calls the function in the field of the same
+// name with a Func suffix, or returns zero values and a nil error if the field is nil. Without a function, GetForKey
+// reports data.ErrRecordNotFound instead, like a database with no records.
+type APIKeyModel struct {
+	InsertFunc        func(ctx context.Context, key *data.APIKey) error
+	GetForKeyFunc     func(ctx context.Context, keyPlaintext string) (*data.APIKey, error)
+	GetAllForUserFunc func(ctx context.Context, userID int64) ([]*data.APIKey, error)
+	DeleteForUserFunc func(ctx context.Context, id, userID int64) error
+}
+
+// Insert calls InsertFunc, if it is set.
+func (m APIKeyModel) Insert(ctx context.Context, key *data.APIKey) error {
+	if m.InsertFunc != nil {
+		return m.InsertFunc(ctx, key)
+	}
+	return nil
+}
+
+// GetForKey calls GetForKeyFunc, if it is set.
+func (m APIKeyModel) GetForKey(ctx context.Context, keyPlaintext string) (*data.APIKey, error) {
+	if m.GetForKeyFunc != nil {
+		return m.GetForKeyFunc(ctx, keyPlaintext)
+	}
+	return nil, data.ErrRecordNotFound
+}
+
+// GetAllForUser calls GetAllForUserFunc, if it is set.
+func (m APIKeyModel) GetAllForUser(ctx context.Context, userID int64) ([]*data.APIKey, error) {
+	if m.GetAllForUserFunc != nil {
+		return m.GetAllForUserFunc(ctx, userID)
+	}
+	return nil, nil
+}
+
+// DeleteForUser calls DeleteForUserFunc, if it is set.
+func (m APIKeyModel) DeleteForUser(ctx context.Context, id, userID int64) error {
+	if m.DeleteForUserFunc != nil {
+		return m.DeleteForUserFunc(ctx, id, userID)
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package mock
+
+import (
+	"cinevault.interimme.net/internal/data"
+	"context"
+)
+
+// AuditModel is a mock data.AuditModel for the audit log. Each method calls the function in the field of the same
+// name with a Func suffix, or returns zero values and a nil error if the field is nil.
+type AuditModel struct {
+	RecordFunc func(ctx context.Context, userID int64, action, entityType string, entityID int64, oldValue, newValue interface{}) error
+	GetAllFunc func(ctx context.Context, entityType string, entityID int64, filters data.Filters) ([]*data.AuditEntry, data.Metadata, error)
+}
+
+// Record calls RecordFunc, if it is set.
+func (m AuditModel) Record(ctx context.Context, userID int64, action, entityType string, entityID int64, oldValue, newValue interface{}) error {
+	if m.RecordFunc != nil {
+		return m.RecordFunc(ctx, userID, action, entityType, entityID, oldValue, newValue)
+	}
+	return nil
+}
+
+// GetAll calls GetAllFunc, if it is set.
+func (m AuditModel) GetAll(ctx context.Context, entityType string, entityID int64, filters data.Filters) ([]*data.AuditEntry, data.Metadata, error) {
+	if m.GetAllFunc != nil {
+		return m.GetAllFunc(ctx, entityType, entityID, filters)
+	}
+	return nil, data.Metadata{}, nil
+}
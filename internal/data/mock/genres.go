@@ -0,0 +1,47 @@
+package mock
+
+import (
+	"cinevault.interimme.net/internal/data"
+	"context"
+)
+
+// GenreModel is a mock data.GenreModel for genres. Each method calls the function in the field of the same name with
+// a Func suffix, or returns zero values and a nil error if the field is nil.
+type GenreModel struct {
+	GetAllFunc      func(ctx context.Context) ([]*data.Genre, error)
+	GetAllNamesFunc func(ctx context.Context) ([]string, error)
+	InsertFunc      func(ctx context.Context, name string) error
+	DeleteFunc      func(ctx context.Context, name string) error
+}
+
+// GetAll calls GetAllFunc, if it is set.
+func (m GenreModel) GetAll(ctx context.Context) ([]*data.Genre, error) {
+	if m.GetAllFunc != nil {
+		return m.GetAllFunc(ctx)
+	}
+	return nil, nil
+}
+
+// GetAllNames calls GetAllNamesFunc, if it is set.
+func (m GenreModel) GetAllNames(ctx context.Context) ([]string, error) {
+	if m.GetAllNamesFunc != nil {
+		return m.GetAllNamesFunc(ctx)
+	}
+	return nil, nil
+}
+
+// Insert calls InsertFunc, if it is set.
+func (m GenreModel) Insert(ctx context.Context, name string) error {
+	if m.InsertFunc != nil {
+		return m.InsertFunc(ctx, name)
+	}
+	return nil
+}
+
+// Delete calls DeleteFunc, if it is set.
+func (m GenreModel) Delete(ctx context.Context, name string) error {
+	if m.DeleteFunc != nil {
+		return m.DeleteFunc(ctx, name)
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+// Package mock provides mock implementations of the data models, so that code using data.Models, such as the API's
+// handlers, can be tested without a database. Each mock is a struct with a function field for every method of the
+// model, which tests set to the behavior they need.
+package mock
+
+import (
+	"cinevault.interimme.net/internal/data"
+)
+
+// NewModels returns a data.Models made up of mocks with no functions set, for tests to replace the models they use.
+func NewModels() data.Models {
+	return data.Models{
+		Actors:      ActorModel{},
+		APIKeys:     APIKeyModel{},
+		Audit:       AuditModel{},
+		Genres:      GenreModel{},
+		Movies:      MovieModel{},
+		Permissions: PermissionModel{},
+		Reviews:     ReviewModel{},
+		Tokens:      TokenModel{},
+		Users:       UserModel{},
+		Watchlists:  WatchlistModel{},
+	}
+}
@@ -0,0 +1,147 @@
+package mock
+
+import (
+	"cinevault.interimme.net/internal/data"
+	"context"
+)
+
+// MovieModel is a mock data.MovieModel for movies. Each method calls the function in the field of the same name with
+// a Func suffix, or returns zero values and a nil error if the field is nil. Without a function, Get and
+// GetIDForUUID report data.ErrRecordNotFound instead, like a database with no records.
+type MovieModel struct {
+	InsertFunc         func(ctx context.Context, movie *data.Movie) error
+	InsertManyFunc     func(ctx context.Context, movies []*data.Movie) error
+	GetFunc            func(ctx context.Context, id int64) (*data.Movie, error)
+	GetIDForUUIDFunc   func(ctx context.Context, uuid string) (int64, error)
+	GetStatsFunc       func(ctx context.Context) (data.MovieStats, error)
+	GetAllForActorFunc func(ctx context.Context, actorID int64) ([]*data.Movie, error)
+	GetTrendingFunc    func(ctx context.Context, limit int) ([]*data.Movie, error)
+	IncrementViewsFunc func(ctx context.Context, id int64) error
+	GetSimilarFunc     func(ctx context.Context, id int64, limit int) ([]*data.Movie, error)
+	UpdateFunc         func(ctx context.Context, movie *data.Movie) error
+	DeleteFunc         func(ctx context.Context, id int64) error
+	DeleteManyFunc     func(ctx context.Context, ids []int64) ([]*data.Movie, error)
+	GetAllFunc         func(ctx context.Context, title string, titleMatch string, genres []string, filters data.Filters) ([]*data.Movie, data.Metadata, error)
+	CountFunc          func(ctx context.Context, title string, titleMatch string, genres []string, filters data.Filters) (int, error)
+	ExportFunc         func(ctx context.Context, title string, titleMatch string, genres []string, filters data.Filters, fn func(*data.Movie) error) error
+}
+
+// Insert calls InsertFunc, if it is set.
+func (m MovieModel) Insert(ctx context.Context, movie *data.Movie) error {
+	if m.InsertFunc != nil {
+		return m.InsertFunc(ctx, movie)
+	}
+	return nil
+}
+
+// InsertMany calls InsertManyFunc, if it is set.
+func (m MovieModel) InsertMany(ctx context.Context, movies []*data.Movie) error {
+	if m.InsertManyFunc != nil {
+		return m.InsertManyFunc(ctx, movies)
+	}
+	return nil
+}
+
+// Get calls GetFunc, if it is set.
+func (m MovieModel) Get(ctx context.Context, id int64) (*data.Movie, error) {
+	if m.GetFunc != nil {
+		return m.GetFunc(ctx, id)
+	}
+	return nil, data.ErrRecordNotFound
+}
+
+// GetIDForUUID calls GetIDForUUIDFunc, if it is set.
+func (m MovieModel) GetIDForUUID(ctx context.Context, uuid string) (int64, error) {
+	if m.GetIDForUUIDFunc != nil {
+		return m.GetIDForUUIDFunc(ctx, uuid)
+	}
+	return 0, data.ErrRecordNotFound
+}
+
+// GetStats calls GetStatsFunc, if it is set.
+func (m MovieModel) GetStats(ctx context.Context) (data.MovieStats, error) {
+	if m.GetStatsFunc != nil {
+		return m.GetStatsFunc(ctx)
+	}
+	return data.MovieStats{}, nil
+}
+
+// GetAllForActor calls GetAllForActorFunc, if it is set.
+func (m MovieModel) GetAllForActor(ctx context.Context, actorID int64) ([]*data.Movie, error) {
+	if m.GetAllForActorFunc != nil {
+		return m.GetAllForActorFunc(ctx, actorID)
+	}
+	return nil, nil
+}
+
+// GetTrending calls GetTrendingFunc, if it is set.
+func (m MovieModel) GetTrending(ctx context.Context, limit int) ([]*data.Movie, error) {
+	if m.GetTrendingFunc != nil {
+		return m.GetTrendingFunc(ctx, limit)
+	}
+	return nil, nil
+}
+
+// IncrementViews calls IncrementViewsFunc, if it is set.
+func (m MovieModel) IncrementViews(ctx context.Context, id int64) error {
+	if m.IncrementViewsFunc != nil {
+		return m.IncrementViewsFunc(ctx, id)
+	}
+	return nil
+}
+
+// GetSimilar calls GetSimilarFunc, if it is set.
+func (m MovieModel) GetSimilar(ctx context.Context, id int64, limit int) ([]*data.Movie, error) {
+	if m.GetSimilarFunc != nil {
+		return m.GetSimilarFunc(ctx, id, limit)
+	}
+	return nil, nil
+}
+
+// Update calls UpdateFunc, if it is set.
+func (m MovieModel) Update(ctx context.Context, movie *data.Movie) error {
+	if m.UpdateFunc != nil {
+		return m.UpdateFunc(ctx, movie)
+	}
+	return nil
+}
+
+// Delete calls DeleteFunc, if it is set.
+func (m MovieModel) Delete(ctx context.Context, id int64) error {
+	if m.DeleteFunc != nil {
+		return m.DeleteFunc(ctx, id)
+	}
+	return nil
+}
+
+// DeleteMany calls DeleteManyFunc, if it is set.
+func (m MovieModel) DeleteMany(ctx context.Context, ids []int64) ([]*data.Movie, error) {
+	if m.DeleteManyFunc != nil {
+		return m.DeleteManyFunc(ctx, ids)
+	}
+	return nil, nil
+}
+
+// GetAll calls GetAllFunc, if it is set.
+func (m MovieModel) GetAll(ctx context.Context, title string, titleMatch string, genres []string, filters data.Filters) ([]*data.Movie, data.Metadata, error) {
+	if m.GetAllFunc != nil {
+		return m.GetAllFunc(ctx, title, titleMatch, genres, filters)
+	}
+	return nil, data.Metadata{}, nil
+}
+
+// Count calls CountFunc, if it is set.
+func (m MovieModel) Count(ctx context.Context, title string, titleMatch string, genres []string, filters data.Filters) (int, error) {
+	if m.CountFunc != nil {
+		return m.CountFunc(ctx, title, titleMatch, genres, filters)
+	}
+	return 0, nil
+}
+
+// Export calls ExportFunc, if it is set.
+func (m MovieModel) Export(ctx context.Context, title string, titleMatch string, genres []string, filters data.Filters, fn func(*data.Movie) error) error {
+	if m.ExportFunc != nil {
+		return m.ExportFunc(ctx, title, titleMatch, genres, filters, fn)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package mock
+
+import (
+	"cinevault.interimme.net/internal/data"
+	"context"
+)
+
+// PermissionModel is a mock data.PermissionModel for user permissions. Each method calls the function in the field
+// of the same name with a Func suffix, or returns zero values and a nil error if the field is nil.
+type PermissionModel struct {
+	GetAllForUserFunc func(ctx context.Context, userID int64) (data.Permissions, error)
+	GetAllFunc        func(ctx context.Context) (data.Permissions, error)
+	AddForUserFunc    func(ctx context.Context, userID int64, codes ...string) error
+	RemoveForUserFunc func(ctx context.Context, userID int64, codes ...string) error
+}
+
+// GetAllForUser calls GetAllForUserFunc, if it is set.
+func (m PermissionModel) GetAllForUser(ctx context.Context, userID int64) (data.Permissions, error) {
+	if m.GetAllForUserFunc != nil {
+		return m.GetAllForUserFunc(ctx, userID)
+	}
+	return nil, nil
+}
+
+// GetAll calls GetAllFunc, if it is set.
+func (m PermissionModel) GetAll(ctx context.Context) (data.Permissions, error) {
+	if m.GetAllFunc != nil {
+		return m.GetAllFunc(ctx)
+	}
+	return nil, nil
+}
+
+// AddForUser calls AddForUserFunc, if it is set.
+func (m PermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	if m.AddForUserFunc != nil {
+		return m.AddForUserFunc(ctx, userID, codes...)
+	}
+	return nil
+}
+
+// RemoveForUser calls RemoveForUserFunc, if it is set.
+func (m PermissionModel) RemoveForUser(ctx context.Context, userID int64, codes ...string) error {
+	if m.RemoveForUserFunc != nil {
+		return m.RemoveForUserFunc(ctx, userID, codes...)
+	}
+	return nil
+}
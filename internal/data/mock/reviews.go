@@ -0,0 +1,57 @@
+package mock
+
+import (
+	"cinevault.interimme.net/internal/data"
+	"context"
+)
+
+// ReviewModel is a mock data.ReviewModel for reviews. Each method calls the function in the field of the same name
+// with a Func suffix, or returns zero values and a nil error if the field is nil. Without a function, Get reports
+// data.ErrRecordNotFound instead, like a database with no records.
+type ReviewModel struct {
+	InsertFunc         func(ctx context.Context, review *data.Review) error
+	GetFunc            func(ctx context.Context, id int64) (*data.Review, error)
+	UpdateFunc         func(ctx context.Context, review *data.Review) error
+	DeleteFunc         func(ctx context.Context, id, userID int64) error
+	GetAllForMovieFunc func(ctx context.Context, movieID int64) ([]*data.Review, error)
+}
+
+// Insert calls InsertFunc, if it is set.
+func (m ReviewModel) Insert(ctx context.Context, review *data.Review) error {
+	if m.InsertFunc != nil {
+		return m.InsertFunc(ctx, review)
+	}
+	return nil
+}
+
+// Get calls GetFunc, if it is set.
+func (m ReviewModel) Get(ctx context.Context, id int64) (*data.Review, error) {
+	if m.GetFunc != nil {
+		return m.GetFunc(ctx, id)
+	}
+	return nil, data.ErrRecordNotFound
+}
+
+// Update calls UpdateFunc, if it is set.
+func (m ReviewModel) Update(ctx context.Context, review *data.Review) error {
+	if m.UpdateFunc != nil {
+		return m.UpdateFunc(ctx, review)
+	}
+	return nil
+}
+
+// Delete calls DeleteFunc, if it is set.
+func (m ReviewModel) Delete(ctx context.Context, id, userID int64) error {
+	if m.DeleteFunc != nil {
+		return m.DeleteFunc(ctx, id, userID)
+	}
+	return nil
+}
+
+// GetAllForMovie calls GetAllForMovieFunc, if it is set.
+func (m ReviewModel) GetAllForMovie(ctx context.Context, movieID int64) ([]*data.Review, error) {
+	if m.GetAllForMovieFunc != nil {
+		return m.GetAllForMovieFunc(ctx, movieID)
+	}
+	return nil, nil
+}
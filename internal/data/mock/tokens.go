@@ -0,0 +1,85 @@
+package mock
+
+import (
+	"cinevault.interimme.net/internal/data"
+	"context"
+	"time"
+)
+
+// TokenModel is a mock data.TokenModel for user tokens. Each method calls the function in the field of the same name
+// with a Func suffix, or returns zero values and a nil error if the field is nil. Without a function, Get reports
+// data.ErrRecordNotFound instead, like a database with no records.
+type TokenModel struct {
+	NewFunc              func(ctx context.Context, userID int64, ttl time.Duration, scope string) (*data.Token, error)
+	NewEmailChangeFunc   func(ctx context.Context, userID int64, ttl time.Duration, newEmail string) (*data.Token, error)
+	GetFunc              func(ctx context.Context, scope, tokenPlaintext string) (*data.Token, error)
+	InsertFunc           func(ctx context.Context, token *data.Token) error
+	DeleteAllForUserFunc func(ctx context.Context, scope string, userID int64) error
+	DeleteFunc           func(ctx context.Context, scope, tokenPlaintext string) error
+	GetAllForUserFunc    func(ctx context.Context, userID int64) ([]*data.Session, error)
+	DeleteForUserFunc    func(ctx context.Context, id, userID int64) error
+}
+
+// New calls NewFunc, if it is set.
+func (m TokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*data.Token, error) {
+	if m.NewFunc != nil {
+		return m.NewFunc(ctx, userID, ttl, scope)
+	}
+	return nil, nil
+}
+
+// NewEmailChange calls NewEmailChangeFunc, if it is set.
+func (m TokenModel) NewEmailChange(ctx context.Context, userID int64, ttl time.Duration, newEmail string) (*data.Token, error) {
+	if m.NewEmailChangeFunc != nil {
+		return m.NewEmailChangeFunc(ctx, userID, ttl, newEmail)
+	}
+	return nil, nil
+}
+
+// Get calls GetFunc, if it is set.
+func (m TokenModel) Get(ctx context.Context, scope, tokenPlaintext string) (*data.Token, error) {
+	if m.GetFunc != nil {
+		return m.GetFunc(ctx, scope, tokenPlaintext)
+	}
+	return nil, data.ErrRecordNotFound
+}
+
+// Insert calls InsertFunc, if it is set.
+func (m TokenModel) Insert(ctx context.Context, token *data.Token) error {
+	if m.InsertFunc != nil {
+		return m.InsertFunc(ctx, token)
+	}
+	return nil
+}
+
+// DeleteAllForUser calls DeleteAllForUserFunc, if it is set.
+func (m TokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	if m.DeleteAllForUserFunc != nil {
+		return m.DeleteAllForUserFunc(ctx, scope, userID)
+	}
+	return nil
+}
+
+// Delete calls DeleteFunc, if it is set.
+func (m TokenModel) Delete(ctx context.Context, scope, tokenPlaintext string) error {
+	if m.DeleteFunc != nil {
+		return m.DeleteFunc(ctx, scope, tokenPlaintext)
+	}
+	return nil
+}
+
+// GetAllForUser calls GetAllForUserFunc, if it is set.
+func (m TokenModel) GetAllForUser(ctx context.Context, userID int64) ([]*data.Session, error) {
+	if m.GetAllForUserFunc != nil {
+		return m.GetAllForUserFunc(ctx, userID)
+	}
+	return nil, nil
+}
+
+// DeleteForUser calls DeleteForUserFunc, if it is set.
+func (m TokenModel) DeleteForUser(ctx context.Context, id, userID int64) error {
+	if m.DeleteForUserFunc != nil {
+		return m.DeleteForUserFunc(ctx, id, userID)
+	}
+	return nil
+}
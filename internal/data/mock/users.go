@@ -0,0 +1,102 @@
+package mock
+
+import (
+	"cinevault.interimme.net/internal/data"
+	"context"
+)
+
+// UserModel is a mock data.UserModel for user accounts. Each method calls the function in the field of the same name
+// with a Func suffix, or returns zero values and a nil error if the field is nil. Without a function, GetByEmail,
+// GetForToken, and Get report data.ErrRecordNotFound instead, like a database with no records.
+type UserModel struct {
+	InsertFunc      func(ctx context.Context, user *data.User) error
+	GetByEmailFunc  func(ctx context.Context, email string) (*data.User, error)
+	UpdateFunc      func(ctx context.Context, user *data.User) error
+	GetForTokenFunc func(ctx context.Context, tokenScope, tokenPlaintext string) (*data.User, error)
+	GetFunc         func(ctx context.Context, id int64) (*data.User, error)
+	GetAllFunc      func(ctx context.Context, email string, activated *bool, filters data.Filters) ([]*data.User, data.Metadata, error)
+	GetStatsFunc    func(ctx context.Context) (data.UserStats, error)
+	GetTOTPFunc     func(ctx context.Context, id int64) ([]byte, bool, error)
+	SetTOTPFunc     func(ctx context.Context, id int64, secret []byte, enabled bool) error
+	DeleteFunc      func(ctx context.Context, id int64) error
+}
+
+// Insert calls InsertFunc, if it is set.
+func (m UserModel) Insert(ctx context.Context, user *data.User) error {
+	if m.InsertFunc != nil {
+		return m.InsertFunc(ctx, user)
+	}
+	return nil
+}
+
+// GetByEmail calls GetByEmailFunc, if it is set.
+func (m UserModel) GetByEmail(ctx context.Context, email string) (*data.User, error) {
+	if m.GetByEmailFunc != nil {
+		return m.GetByEmailFunc(ctx, email)
+	}
+	return nil, data.ErrRecordNotFound
+}
+
+// Update calls UpdateFunc, if it is set.
+func (m UserModel) Update(ctx context.Context, user *data.User) error {
+	if m.UpdateFunc != nil {
+		return m.UpdateFunc(ctx, user)
+	}
+	return nil
+}
+
+// GetForToken calls GetForTokenFunc, if it is set.
+func (m UserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*data.User, error) {
+	if m.GetForTokenFunc != nil {
+		return m.GetForTokenFunc(ctx, tokenScope, tokenPlaintext)
+	}
+	return nil, data.ErrRecordNotFound
+}
+
+// Get calls GetFunc, if it is set.
+func (m UserModel) Get(ctx context.Context, id int64) (*data.User, error) {
+	if m.GetFunc != nil {
+		return m.GetFunc(ctx, id)
+	}
+	return nil, data.ErrRecordNotFound
+}
+
+// GetAll calls GetAllFunc, if it is set.
+func (m UserModel) GetAll(ctx context.Context, email string, activated *bool, filters data.Filters) ([]*data.User, data.Metadata, error) {
+	if m.GetAllFunc != nil {
+		return m.GetAllFunc(ctx, email, activated, filters)
+	}
+	return nil, data.Metadata{}, nil
+}
+
+// GetStats calls GetStatsFunc, if it is set.
+func (m UserModel) GetStats(ctx context.Context) (data.UserStats, error) {
+	if m.GetStatsFunc != nil {
+		return m.GetStatsFunc(ctx)
+	}
+	return data.UserStats{}, nil
+}
+
+// GetTOTP calls GetTOTPFunc, if it is set.
+func (m UserModel) GetTOTP(ctx context.Context, id int64) ([]byte, bool, error) {
+	if m.GetTOTPFunc != nil {
+		return m.GetTOTPFunc(ctx, id)
+	}
+	return nil, false, nil
+}
+
+// SetTOTP calls SetTOTPFunc, if it is set.
+func (m UserModel) SetTOTP(ctx context.Context, id int64, secret []byte, enabled bool) error {
+	if m.SetTOTPFunc != nil {
+		return m.SetTOTPFunc(ctx, id, secret, enabled)
+	}
+	return nil
+}
+
+// Delete calls DeleteFunc, if it is set.
+func (m UserModel) Delete(ctx context.Context, id int64) error {
+	if m.DeleteFunc != nil {
+		return m.DeleteFunc(ctx, id)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package mock
+
+import (
+	"cinevault.interimme.net/internal/data"
+	"context"
+)
+
+// WatchlistModel is a mock data.WatchlistModel for users' watchlists. Each method calls the function in the field of
+// the same name with a Func suffix, or returns zero values and a nil error if the field is nil.
+type WatchlistModel struct {
+	AddFunc           func(ctx context.Context, userID, movieID int64) error
+	RemoveFunc        func(ctx context.Context, userID, movieID int64) error
+	GetAllForUserFunc func(ctx context.Context, userID int64, filters data.Filters) ([]*data.Movie, data.Metadata, error)
+}
+
+// Add calls AddFunc, if it is set.
+func (m WatchlistModel) Add(ctx context.Context, userID, movieID int64) error {
+	if m.AddFunc != nil {
+		return m.AddFunc(ctx, userID, movieID)
+	}
+	return nil
+}
+
+// Remove calls RemoveFunc, if it is set.
+func (m WatchlistModel) Remove(ctx context.Context, userID, movieID int64) error {
+	if m.RemoveFunc != nil {
+		return m.RemoveFunc(ctx, userID, movieID)
+	}
+	return nil
+}
+
+// GetAllForUser calls GetAllForUserFunc, if it is set.
+func (m WatchlistModel) GetAllForUser(ctx context.Context, userID int64, filters data.Filters) ([]*data.Movie, data.Metadata, error) {
+	if m.GetAllForUserFunc != nil {
+		return m.GetAllForUserFunc(ctx, userID, filters)
+	}
+	return nil, data.Metadata{}, nil
+}
@@ -1,7 +1,6 @@
 package data
 
 import (
-	"database/sql"
 	"errors"
 )
 
@@ -11,22 +10,46 @@ var (
 	ErrEditConflict   = errors.New("edit conflict")    // Error when a concurrent edit causes a conflict.
 )
 
-// Models struct is a container for different models (Movie, Permission, Token, User).
+// Models struct is a container for different models (Actor, APIKey, Audit, Genre, Movie, Permission, Review, Token, User, Watchlist).
 // This struct provides an easy way to access all the database models in one place.
+//
+// Each model is an interface, implemented by the PostgreSQL models returned by NewModels, whose methods are
+// documented with their implementations. Any of them can be replaced with a mock, such as those in the mock package,
+// to test code that uses it without a database.
 type Models struct {
+	Actors      ActorModel      // ActorModel handles actors and the casts of movies.
+	APIKeys     APIKeyModel     // APIKeyModel handles the API keys users create for machine clients.
+	Audit       AuditModel      // AuditModel handles the audit trail of changes to records.
+	Genres      GenreModel      // GenreModel handles the canonical list of genres.
 	Movies      MovieModel      // MovieModel handles operations related to the movies.
 	Permissions PermissionModel // PermissionModel handles user permissions.
+	Reviews     ReviewModel     // ReviewModel handles user reviews of movies.
 	Tokens      TokenModel      // TokenModel handles user tokens (e.g., for authentication).
 	Users       UserModel       // UserModel handles user-related operations.
+	Watchlists  WatchlistModel  // WatchlistModel handles the movies users have saved to watch later.
 }
 
 // NewModels initializes and returns a Models struct with a database connection pool.
-// It is used to create instances of each model type with a shared database connection.
-func NewModels(db *sql.DB) Models {
+// It is used to create instances of each model type with a shared database connection. If readDB is not nil, the
+// models' read methods (Get, GetAll, GetByEmail, GetAllForUser, and the other lookups and listings) use it instead
+// of db, so that they can be served by a read replica. Token and API key lookups always use db, since a token is
+// often used straight after it is created.
+// movieCache and permissionCache, either of which may be nil, cache the results of MovieModel.Get and
+// PermissionModel.GetAllForUser. New tokens and API keys are hashed with tokenHashScheme, one of TokenHashSchemes.
+func NewModels(db, readDB *DB, movieCache *Cache[*Movie], permissionCache *Cache[Permissions], tokenHashScheme string) Models {
+	if readDB == nil {
+		readDB = db // Fall back to the primary when there is no replica.
+	}
 	return Models{
-		Movies:      MovieModel{DB: db},      // Initialize MovieModel with the provided DB connection.
-		Permissions: PermissionModel{DB: db}, // Initialize PermissionModel with the provided DB connection.
-		Tokens:      TokenModel{DB: db},      // Initialize TokenModel with the provided DB connection.
-		Users:       UserModel{DB: db},       // Initialize UserModel with the provided DB connection.
+		Actors:      actorModel{DB: db, ReadDB: readDB},                               // Initialize ActorModel with the provided DB connections.
+		APIKeys:     apiKeyModel{DB: db, ReadDB: readDB, hashScheme: tokenHashScheme}, // Initialize APIKeyModel with the provided DB connections and hashing scheme.
+		Audit:       auditModel{DB: db, ReadDB: readDB},                               // Initialize AuditModel with the provided DB connections.
+		Genres:      genreModel{DB: db, ReadDB: readDB},                               // Initialize GenreModel with the provided DB connections.
+		Movies:      movieModel{DB: db, ReadDB: readDB, cache: movieCache},            // Initialize MovieModel with the provided DB connections and cache.
+		Permissions: permissionModel{DB: db, ReadDB: readDB, cache: permissionCache},  // Initialize PermissionModel with the provided DB connections and cache.
+		Reviews:     reviewModel{DB: db, ReadDB: readDB, movieCache: movieCache},      // Initialize ReviewModel with the provided DB connections and movie cache.
+		Tokens:      tokenModel{DB: db, hashScheme: tokenHashScheme},                  // Initialize TokenModel with the provided DB connection and hashing scheme.
+		Users:       userModel{DB: db, ReadDB: readDB},                                // Initialize UserModel with the provided DB connections.
+		Watchlists:  watchlistModel{DB: db, ReadDB: readDB},                           // Initialize WatchlistModel with the provided DB connections.
 	}
 }
@@ -4,81 +4,307 @@ import (
 	"cinevault.interimme.net/internal/validator"
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/lib/pq"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
+// ErrDuplicateMovie is returned when a movie is inserted or updated with the same title (ignoring case) and year as
+// another movie in the database.
+var ErrDuplicateMovie = errors.New("duplicate movie")
+
+// Title matching modes supported by MovieModel.GetAll.
+const (
+	TitleMatchFulltext  = "fulltext"  // Full-text search on the words of the title, using the GIN index. This is the default.
+	TitleMatchPrefix    = "prefix"    // Case-insensitive match on the start of the title.
+	TitleMatchSubstring = "substring" // Case-insensitive match anywhere in the title. Note this can't use the full-text index and scans the table.
+)
+
+// TitleMatchSafelist lists the title matching modes that clients may request.
+var TitleMatchSafelist = []string{TitleMatchFulltext, TitleMatchPrefix, TitleMatchSubstring}
+
+// maxTitleSearchLength is the maximum length, in characters, of the title search term accepted by GetAll and Export.
+const maxTitleSearchLength = 200
+
+// ValidateTitleSearch checks that a title search term is short enough to be matched without an expensive scan.
+func ValidateTitleSearch(v *validator.Validator, title string) {
+	v.CheckCodedf(utf8.RuneCountInString(title) <= maxTitleSearchLength, "title", validator.CodeTooLong, "must not be more than %d characters long", maxTitleSearchLength)
+}
+
+// ValidateSearch checks that the general search terms in Filters.Search are no longer than a title search term.
+func ValidateSearch(v *validator.Validator, search string) {
+	v.CheckCodedf(utf8.RuneCountInString(search) <= maxTitleSearchLength, "q", validator.CodeTooLong, "must not be more than %d characters long", maxTitleSearchLength)
+}
+
 // Movie represents a movie record in the database.
 type Movie struct {
-	ID        int64     `json:"id"`                // Unique identifier for the movie.
-	CreatedAt time.Time `json:"-"`                 // Timestamp when the movie was created. This field is not included in the JSON response.
-	Title     string    `json:"title"`             // The title of the movie.
-	Year      int32     `json:"year,omitempty"`    // The release year of the movie. Omitted from JSON if not provided.
-	Runtime   Runtime   `json:"runtime,omitempty"` // The runtime of the movie in minutes. Omitted from JSON if not provided.
-	Genres    []string  `json:"genres,omitempty"`  // A list of genres the movie belongs to. Omitted from JSON if not provided.
-	Version   int32     `json:"version"`           // The version number of the movie record for optimistic concurrency control.
+	ID            int64         `json:"id"`                       // Unique identifier for the movie.
+	UUID          string        `json:"-"`                        // Random identifier for the movie, shown in place of the ID once UseUUID is called.
+	CreatedAt     time.Time     `json:"-"`                        // Timestamp when the movie was created. This field is not included in the JSON response.
+	Title         string        `json:"title"`                    // The title of the movie.
+	Year          int32         `json:"year,omitempty"`           // The release year of the movie. Omitted from JSON if not provided.
+	ReleaseDate   *Date         `json:"release_date,omitempty"`   // The full release date of the movie, if known. Its year is the movie's year.
+	Runtime       Runtime       `json:"runtime,omitempty"`        // The runtime of the movie in minutes. Omitted from JSON if not provided.
+	Genres        []string      `json:"genres,omitempty"`         // A list of genres the movie belongs to. Omitted from JSON if not provided.
+	Tags          []string      `json:"tags,omitempty"`           // Free-form keywords describing the movie, such as "time-travel". Omitted if it has none.
+	AverageRating *float64      `json:"average_rating,omitempty"` // The average review rating, computed from the reviews table. Omitted if the movie has no reviews.
+	PosterURL     string        `json:"poster_url,omitempty"`     // The URL of the movie's poster image. Omitted if no poster has been uploaded.
+	Cast          []*CastMember `json:"cast,omitempty"`           // The movie's cast, in billing order. Only loaded when requested with include=cast.
+	Views         int64         `json:"views,omitempty"`          // The number of times the movie has been fetched. Only loaded by GetTrending.
+	Version       int32         `json:"version"`                  // The version number of the movie record for optimistic concurrency control.
+
+	useUUID        bool // Whether the UUID is shown as the movie's ID, for APIs that don't reveal the sequential IDs.
+	runtimeMinutes bool // Whether the runtime is shown as a plain number of minutes rather than "<n> mins".
+}
+
+// UseUUID makes the movie identify itself by its UUID rather than its sequential ID in JSON and ExternalID, so that
+// clients can't tell the size of the catalog or enumerate it. The ID is still used internally.
+func (m *Movie) UseUUID() {
+	m.useUUID = true
+}
+
+// UseRuntimeMinutes makes the movie show its runtime in JSON as a plain number of minutes, such as 102, rather than
+// as a string such as "102 mins", for clients that compare runtimes numerically.
+func (m *Movie) UseRuntimeMinutes() {
+	m.runtimeMinutes = true
+}
+
+// ExternalID returns the identifier clients know the movie by: its UUID if UseUUID has been called, and its ID
+// otherwise.
+func (m Movie) ExternalID() string {
+	if m.useUUID {
+		return m.UUID
+	}
+	return strconv.FormatInt(m.ID, 10)
+}
+
+// MarshalJSON encodes the movie with the fields tagged above, except that the id is the UUID, as a string, if
+// UseUUID has been called, and the runtime is a number of minutes if UseRuntimeMinutes has been called.
+func (m Movie) MarshalJSON() ([]byte, error) {
+	type movie Movie // Has the fields but not the methods of Movie, so that encoding it doesn't recurse.
+	if !m.useUUID && !m.runtimeMinutes {
+		return json.Marshal(movie(m))
+	}
+
+	view := struct {
+		ID      interface{} `json:"id"`                // Takes precedence over the embedded movie's ID.
+		Runtime interface{} `json:"runtime,omitempty"` // Takes precedence over the embedded movie's runtime; nil if it has none.
+		movie
+	}{ID: m.ID, movie: movie(m)}
+	if m.useUUID {
+		view.ID = m.UUID
+	}
+	switch {
+	case m.Runtime == 0:
+		// Leave the runtime out, as the tag on the embedded movie's runtime would.
+	case m.runtimeMinutes:
+		view.Runtime = int32(m.Runtime)
+	default:
+		view.Runtime = m.Runtime
+	}
+	return json.Marshal(view)
 }
 
 // ValidateMovie validates the fields of a Movie struct to ensure they meet the required criteria.
 func ValidateMovie(v *validator.Validator, movie *Movie) {
-	v.Check(movie.Title != "", "title", "must be provided")
-	v.Check(len(movie.Title) <= 500, "title", "must not be more than 500 bytes long")
-	v.Check(movie.Year != 0, "year", "must be provided")
-	v.Check(movie.Year >= 1888, "year", "must be greater than 1888") // The year 1888 is chosen because it's the year of the first known film.
-	v.Check(movie.Year <= int32(time.Now().Year()), "year", "must not be in the future")
-	v.Check(movie.Runtime != 0, "runtime", "must be provided")
-	v.Check(movie.Runtime > 0, "runtime", "must be a positive integer")
-	v.Check(movie.Genres != nil, "genres", "must be provided")
-	v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
-	v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genres")
-	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
+	v.CheckCoded(movie.Title != "", "title", validator.CodeRequired, "must be provided")
+	v.CheckCoded(len(movie.Title) <= 500, "title", validator.CodeTooLong, "must not be more than 500 bytes long")
+	v.CheckCoded(movie.Year != 0, "year", validator.CodeRequired, "must be provided")
+	v.CheckCoded(movie.Year >= 1888, "year", validator.CodeOutOfRange, "must be greater than 1888") // The year 1888 is chosen because it's the year of the first known film.
+	v.CheckCoded(movie.Year <= int32(time.Now().Year()), "year", validator.CodeOutOfRange, "must not be in the future")
+	if movie.ReleaseDate != nil {
+		v.CheckCoded(!movie.ReleaseDate.After(time.Now()), "release_date", validator.CodeOutOfRange, "must not be in the future")
+		v.CheckCoded(movie.Year == int32(movie.ReleaseDate.Year()), "year", validator.CodeInvalidFormat, "must be the year of release_date")
+	}
+	v.CheckCoded(movie.Runtime != 0, "runtime", validator.CodeRequired, "must be provided")
+	v.CheckCoded(movie.Runtime > 0, "runtime", validator.CodeOutOfRange, "must be a positive integer")
+	v.CheckCoded(movie.Genres != nil, "genres", validator.CodeRequired, "must be provided")
+	v.CheckCoded(len(movie.Genres) >= 1, "genres", validator.CodeTooFew, "must contain at least 1 genre")
+	v.CheckCoded(len(movie.Genres) <= 5, "genres", validator.CodeTooMany, "must not contain more than 5 genres")
+	v.CheckCoded(validator.Unique(movie.Genres), "genres", validator.CodeDuplicate, "must not contain duplicate values")
+	ValidateTags(v, movie.Tags)
+}
+
+// Limits on the tags of a movie, which unlike genres can be any words editors choose.
+const (
+	maxTags      = 20 // Maximum number of tags a movie can have.
+	maxTagLength = 50 // Maximum length of each tag, in characters.
+)
+
+// ValidateTags checks the tags of a movie, which are optional: there may be up to maxTags of them, each no longer
+// than maxTagLength characters, and none empty or repeated.
+func ValidateTags(v *validator.Validator, tags []string) {
+	v.CheckCodedf(len(tags) <= maxTags, "tags", validator.CodeTooMany, "must not contain more than %d tags", maxTags)
+	for _, tag := range tags {
+		v.CheckCoded(strings.TrimSpace(tag) != "", "tags", validator.CodeRequired, "must not contain empty tags")
+		v.CheckCodedf(utf8.RuneCountInString(tag) <= maxTagLength, "tags", validator.CodeTooLong, "must not contain tags longer than %d characters", maxTagLength)
+	}
+	v.CheckCoded(validator.Unique(tags), "tags", validator.CodeDuplicate, "must not contain duplicate values")
+}
+
+// MovieSortSafelist lists the sort values accepted when listing or exporting movies.
+// Sorting by relevance orders the movies by how well they match the general search terms, and is the default when
+// there are some.
+var MovieSortSafelist = SortSafelist("id", "title", "year", "release_date", "runtime", "relevance")
+
+// movieSortExpressions maps the movie sort fields that aren't sorted by their column alone to the SQL expressions
+// they are sorted by. Movies with no release date are sorted as if released on the first day of their year, since
+// NULLs would be left out of the comparisons keyset pagination relies on.
+var movieSortExpressions = map[string]string{
+	"release_date": "COALESCE(release_date, make_date(year, 1, 1))",
+}
+
+// movieSearchSortExpressions returns movieSortExpressions together with the expression for sorting by relevance,
+// which ranks each movie against the general search terms bound to the placeholder with the given number, for the
+// queries that take them. Matches in the title count for more than matches in the tags, as movie_search_vector
+// weights them higher.
+func movieSearchSortExpressions(searchArg int) map[string]string {
+	expressions := map[string]string{
+		"relevance": fmt.Sprintf("ts_rank(movie_search_vector(title, tags), plainto_tsquery('simple', $%d))", searchArg),
+	}
+	for field, expression := range movieSortExpressions {
+		expressions[field] = expression
+	}
+	return expressions
+}
+
+// MovieModel is the interface to the movies in the database.
+type MovieModel interface {
+	Insert(ctx context.Context, movie *Movie) error
+	InsertMany(ctx context.Context, movies []*Movie) error
+	Get(ctx context.Context, id int64) (*Movie, error)
+	GetIDForUUID(ctx context.Context, uuid string) (int64, error)
+	GetStats(ctx context.Context) (MovieStats, error)
+	GetAllForActor(ctx context.Context, actorID int64) ([]*Movie, error)
+	GetTrending(ctx context.Context, limit int) ([]*Movie, error)
+	IncrementViews(ctx context.Context, id int64) error
+	GetSimilar(ctx context.Context, id int64, limit int) ([]*Movie, error)
+	Update(ctx context.Context, movie *Movie) error
+	Delete(ctx context.Context, id int64) error
+	DeleteMany(ctx context.Context, ids []int64) ([]*Movie, error)
+	GetAll(ctx context.Context, title string, titleMatch string, genres []string, filters Filters) ([]*Movie, Metadata, error)
+	Count(ctx context.Context, title string, titleMatch string, genres []string, filters Filters) (int, error)
+	Export(ctx context.Context, title string, titleMatch string, genres []string, filters Filters, fn func(*Movie) error) error
 }
 
-// MovieModel represents the methods that can be performed on the movies in the database.
-type MovieModel struct {
-	DB *sql.DB // Database connection pool.
+// movieModel is the PostgreSQL implementation of MovieModel.
+type movieModel struct {
+	DB     *DB            // Database connection pool, used for writes.
+	ReadDB *DB            // Connection pool used for reads, which is the read replica if one is configured.
+	cache  *Cache[*Movie] // Cache of movies by ID used by Get, or nil if caching is disabled.
 }
 
 // Insert adds a new movie record to the database.
-func (m MovieModel) Insert(movie *Movie) error {
+func (m movieModel) Insert(ctx context.Context, movie *Movie) error {
 	query := `
-INSERT INTO movies (title, year, runtime, genres)
-VALUES ($1, $2, $3, $4)
-RETURNING id, created_at, version`
-	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
-	// Create a context with a 3-second timeout for executing the query.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+INSERT INTO movies (title, year, release_date, runtime, genres, tags)
+VALUES ($1, $2, $3, $4, $5, COALESCE($6, '{}'::text[]))
+RETURNING id, uuid, created_at, version`
+	args := []interface{}{movie.Title, movie.Year, movie.ReleaseDate, movie.Runtime, pq.Array(movie.Genres), pq.Array(movie.Tags)}
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	// Execute the query and scan the returned id, created_at, and version into the movie struct.
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	// Execute the query and scan the returned id, uuid, created_at, and version into the movie struct.
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.UUID, &movie.CreatedAt, &movie.Version)
+	if err != nil {
+		switch {
+		case isViolation(err, pgUniqueViolation, "movies_title_year_unique_idx"):
+			return wrapError(err, ErrDuplicateMovie) // Return a specific error if a movie with the same title and year exists.
+		default:
+			return wrapError(err, nil) // Return any other errors that occur.
+		}
+	}
+	return nil
 }
 
-// Get retrieves a specific movie record from the database by its ID.
-func (m MovieModel) Get(id int64) (*Movie, error) {
+// InsertMany adds several movie records to the database inside a single transaction. If any insert fails, the
+// whole batch is rolled back. On success, the id, uuid, created_at, and version fields of each movie are populated.
+func (m movieModel) InsertMany(ctx context.Context, movies []*Movie) error {
+	query := `
+INSERT INTO movies (title, year, release_date, runtime, genres, tags)
+VALUES ($1, $2, $3, $4, $5, COALESCE($6, '{}'::text[]))
+RETURNING id, uuid, created_at, version`
+
+	// Derive a context with a 10-second timeout from the caller's context, as the batch may contain many rows.
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	// Begin a new transaction.
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // Rollback is a no-op if the transaction has already been committed.
+
+	// Prepare the insert statement once and reuse it for every movie in the batch.
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, movie := range movies {
+		args := []interface{}{movie.Title, movie.Year, movie.ReleaseDate, movie.Runtime, pq.Array(movie.Genres), pq.Array(movie.Tags)}
+		err = stmt.QueryRowContext(ctx, args...).Scan(&movie.ID, &movie.UUID, &movie.CreatedAt, &movie.Version)
+		if err != nil {
+			switch {
+			case isViolation(err, pgUniqueViolation, "movies_title_year_unique_idx"):
+				return wrapError(err, ErrDuplicateMovie) // The movie duplicates an existing movie or an earlier one in the batch.
+			default:
+				return wrapError(err, nil)
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Get retrieves a specific movie record from the database by its ID. If caching is enabled, the movie is served
+// from the cache when it holds a copy, and added to the cache otherwise.
+func (m movieModel) Get(ctx context.Context, id int64) (*Movie, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound // Return an error if the ID is invalid.
 	}
+	if movie, ok := m.cache.get(id); ok {
+		return copyMovie(movie), nil
+	}
+
+	// Read a movie that was changed while cached from the primary, which a lagging replica may not have caught up
+	// with, so that the old row isn't cached again.
+	db := m.ReadDB
+	if m.cache.invalidated(id) {
+		db = m.DB
+	}
 
 	query := `
-SELECT id, created_at, title, year, runtime, genres, version
+SELECT id, uuid, created_at, title, year, release_date, runtime, genres, tags,
+(SELECT ROUND(AVG(rating), 1) FROM reviews WHERE reviews.movie_id = movies.id), poster_url, version
 FROM movies
 WHERE id = $1`
 	var movie Movie
-	// Create a context with a 3-second timeout for executing the query.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	// Execute the query and scan the result into a movie struct.
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+	err := db.QueryRowContext(ctx, query, id).Scan(
 		&movie.ID,
+		&movie.UUID,
 		&movie.CreatedAt,
 		&movie.Title,
 		&movie.Year,
+		&movie.ReleaseDate,
 		&movie.Runtime,
 		pq.Array(&movie.Genres),
+		pq.Array(&movie.Tags),
+		&movie.AverageRating,
+		&movie.PosterURL,
 		&movie.Version,
 	)
 	if err != nil {
@@ -89,44 +315,291 @@ WHERE id = $1`
 			return nil, err // Return any other errors that occur.
 		}
 	}
+	m.cacheMovie(&movie, db == m.DB)
 	return &movie, nil
 }
 
-// Update modifies the details of an existing movie record in the database.
-func (m MovieModel) Update(movie *Movie) error {
+// GetIDForUUID returns the ID of the movie with the given UUID, returning ErrRecordNotFound if there is no such movie
+// or the UUID is malformed.
+func (m movieModel) GetIDForUUID(ctx context.Context, uuid string) (int64, error) {
+	if !validator.Matches(uuid, validator.UUIDRX) {
+		return 0, ErrRecordNotFound // Postgres would reject the malformed UUID with an error of its own.
+	}
+	query := `
+SELECT id
+FROM movies
+WHERE uuid = $1`
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var id int64
+	err := m.ReadDB.QueryRowContext(ctx, query, uuid).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+	return id, nil
+}
+
+// cacheMovie adds a copy of movie to the cache, where primary tells whether it came from the primary database. A
+// movie older than the one already cached, as told by its version, is ignored, so that a read from a lagging replica
+// cannot replace a newer copy of the movie.
+func (m movieModel) cacheMovie(movie *Movie, primary bool) {
+	m.cache.put(movie.ID, copyMovie(movie), primary, func(cached *Movie) bool {
+		return cached.Version <= movie.Version
+	})
+}
+
+// MovieStats holds aggregate figures about the movies in the database.
+type MovieStats struct {
+	Total                 int            `json:"total"`                   // Number of movies.
+	AverageRuntimeMinutes float64        `json:"average_runtime_minutes"` // Mean runtime of the movies, in minutes, or 0 if there are none.
+	Genres                map[string]int `json:"genres"`                  // Number of movies in each genre.
+}
+
+// GetStats computes the MovieStats of the movies in the database in a single query. The genre counts are built
+// into a JSON object by PostgreSQL, so that they come back in the same row as the totals.
+func (m movieModel) GetStats(ctx context.Context) (MovieStats, error) {
+	query := `
+SELECT
+	(SELECT count(*) FROM movies),
+	(SELECT COALESCE(ROUND(AVG(runtime), 1), 0) FROM movies),
+	(SELECT COALESCE(json_object_agg(genre, n), '{}')
+	 FROM (SELECT unnest(genres) AS genre, count(*) AS n FROM movies GROUP BY genre) AS counts)`
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var stats MovieStats
+	var genres []byte
+	err := m.ReadDB.QueryRowContext(ctx, query).Scan(&stats.Total, &stats.AverageRuntimeMinutes, &genres)
+	if err != nil {
+		return MovieStats{}, err
+	}
+
+	// Decode the genre counts from the JSON object built by the query.
+	err = json.Unmarshal(genres, &stats.Genres)
+	if err != nil {
+		return MovieStats{}, err
+	}
+	return stats, nil
+}
+
+// GetAllForActor retrieves the movies an actor is credited in, newest first.
+func (m movieModel) GetAllForActor(ctx context.Context, actorID int64) ([]*Movie, error) {
+	query := `
+SELECT movies.id, movies.uuid, movies.created_at, movies.title, movies.year, movies.release_date, movies.runtime, movies.genres, movies.tags,
+(SELECT ROUND(AVG(rating), 1) FROM reviews WHERE reviews.movie_id = movies.id), movies.poster_url, movies.version
+FROM movies
+INNER JOIN movie_cast ON movie_cast.movie_id = movies.id
+WHERE movie_cast.actor_id = $1
+ORDER BY movies.year DESC, movies.id ASC`
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.ReadDB.QueryContext(ctx, query, actorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+	// Loop through the result set and scan each row into a Movie struct.
+	for rows.Next() {
+		var movie Movie
+		err := rows.Scan(
+			&movie.ID,
+			&movie.UUID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.ReleaseDate,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			pq.Array(&movie.Tags),
+			&movie.AverageRating,
+			&movie.PosterURL,
+			&movie.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		movies = append(movies, &movie)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return movies, nil
+}
+
+// GetTrending retrieves up to limit movies with the most views across the whole catalog, most viewed first.
+func (m movieModel) GetTrending(ctx context.Context, limit int) ([]*Movie, error) {
+	query := `
+SELECT id, uuid, created_at, title, year, release_date, runtime, genres, tags,
+(SELECT ROUND(AVG(rating), 1) FROM reviews WHERE reviews.movie_id = movies.id), poster_url, views, version
+FROM movies
+ORDER BY views DESC, id ASC
+LIMIT $1`
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.ReadDB.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+	// Loop through the result set and scan each row into a Movie struct.
+	for rows.Next() {
+		var movie Movie
+		err := rows.Scan(
+			&movie.ID,
+			&movie.UUID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.ReleaseDate,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			pq.Array(&movie.Tags),
+			&movie.AverageRating,
+			&movie.PosterURL,
+			&movie.Views,
+			&movie.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		movies = append(movies, &movie)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return movies, nil
+}
+
+// IncrementViews adds one to the view count of the movie with the given ID. The version is left alone, since a
+// view doesn't change the movie and shouldn't cause edit conflicts.
+func (m movieModel) IncrementViews(ctx context.Context, id int64) error {
+	query := `
+UPDATE movies
+SET views = views + 1
+WHERE id = $1`
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+// GetSimilar retrieves up to limit movies that share at least one genre with the movie with the given ID,
+// ordered by the number of genres they have in common. The movie itself is excluded from the results.
+func (m movieModel) GetSimilar(ctx context.Context, id int64, limit int) ([]*Movie, error) {
+	query := `
+SELECT m.id, m.uuid, m.created_at, m.title, m.year, m.release_date, m.runtime, m.genres, m.tags,
+(SELECT ROUND(AVG(rating), 1) FROM reviews WHERE reviews.movie_id = m.id), m.poster_url, m.version
+FROM movies m, movies source
+WHERE source.id = $1 AND m.id <> source.id AND m.genres && source.genres
+ORDER BY cardinality(ARRAY(SELECT unnest(m.genres) INTERSECT SELECT unnest(source.genres))) DESC, m.id ASC
+LIMIT $2`
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.ReadDB.QueryContext(ctx, query, id, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+	// Loop through the result set and scan each row into a Movie struct.
+	for rows.Next() {
+		var movie Movie
+		err := rows.Scan(
+			&movie.ID,
+			&movie.UUID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.ReleaseDate,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			pq.Array(&movie.Tags),
+			&movie.AverageRating,
+			&movie.PosterURL,
+			&movie.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		movies = append(movies, &movie)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return movies, nil
+}
+
+// Update modifies the details of an existing movie record in the database. The cached copy of the movie, if any,
+// is replaced by the updated movie, or dropped if there is an edit conflict, since it may be out of date.
+func (m movieModel) Update(ctx context.Context, movie *Movie) error {
 	query := `
 UPDATE movies
-SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-WHERE id = $5 AND version = $6
+SET title = $1, year = $2, release_date = $3, runtime = $4, genres = $5, tags = COALESCE($6, '{}'::text[]), poster_url = $7,
+version = version + 1
+WHERE id = $8 AND version = $9
 RETURNING version`
 	args := []interface{}{
 		movie.Title,
 		movie.Year,
+		movie.ReleaseDate,
 		movie.Runtime,
 		pq.Array(movie.Genres),
+		pq.Array(movie.Tags),
+		movie.PosterURL,
 		movie.ID,
 		movie.Version,
 	}
 
-	// Create a context with a 3-second timeout for executing the query.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	// Execute the update query and scan the returned version into the movie struct.
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
 	if err != nil {
 		switch {
+		case isViolation(err, pgUniqueViolation, "movies_title_year_unique_idx"):
+			return wrapError(err, ErrDuplicateMovie) // Return a specific error if another movie has the same title and year.
 		case errors.Is(err, sql.ErrNoRows):
+			m.cache.invalidate(movie.ID)
 			return ErrEditConflict // Return a custom error if there is an edit conflict.
 		default:
-			return err // Return any other errors that occur.
+			return wrapError(err, nil) // Return any other errors that occur.
 		}
 	}
+	m.cacheMovie(movie, true)
 	return nil
 }
 
-// Delete removes a specific movie record from the database by its ID.
-func (m MovieModel) Delete(id int64) error {
+// Delete removes a specific movie record from the database by its ID, and from the cache.
+func (m movieModel) Delete(ctx context.Context, id int64) error {
 	if id < 1 {
 		return ErrRecordNotFound // Return an error if the ID is invalid.
 	}
@@ -134,8 +607,8 @@ func (m MovieModel) Delete(id int64) error {
 DELETE FROM movies
 WHERE id = $1`
 
-	// Create a context with a 3-second timeout for executing the query.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	// Execute the delete query.
@@ -143,6 +616,7 @@ WHERE id = $1`
 	if err != nil {
 		return err
 	}
+	m.cache.invalidate(id)
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
 		return err
@@ -153,23 +627,122 @@ WHERE id = $1`
 	return nil
 }
 
+// DeleteMany removes the movies with the given IDs from the database, and from the cache, in a single statement, so
+// that either all of them are deleted or none are. IDs with no movie are skipped. It returns the movies that were
+// deleted, as they were beforehand, so the number deleted is the number of rows affected.
+func (m movieModel) DeleteMany(ctx context.Context, ids []int64) ([]*Movie, error) {
+	// The average ratings are worked out in the outer query, which still sees the reviews the delete cascades to.
+	query := `
+WITH deleted AS (
+	DELETE FROM movies
+	WHERE id = ANY($1)
+	RETURNING id, uuid, created_at, title, year, release_date, runtime, genres, tags, poster_url, version
+)
+SELECT id, uuid, created_at, title, year, release_date, runtime, genres, tags,
+(SELECT ROUND(AVG(rating), 1) FROM reviews WHERE reviews.movie_id = deleted.id), poster_url, version
+FROM deleted
+ORDER BY id`
+
+	// Derive a context with a 10-second timeout from the caller's context, as the list may contain many movies.
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+	for rows.Next() {
+		var movie Movie
+		err := rows.Scan(
+			&movie.ID,
+			&movie.UUID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.ReleaseDate,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			pq.Array(&movie.Tags),
+			&movie.AverageRating,
+			&movie.PosterURL,
+			&movie.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		movies = append(movies, &movie)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		m.cache.invalidate(id)
+	}
+	return movies, nil
+}
+
 // GetAll retrieves all movie records that match the provided title and genres, and applies pagination and sorting.
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+// The titleMatch argument selects how the title is matched, and must be one of the TitleMatch constants.
+// When filters.Cursor is set, keyset pagination is used instead of LIMIT/OFFSET so that deep pages stay cheap.
+// Results sorted by relevance can only be paginated by page number, since the client never sees the ranks a
+// cursor would hold; a cursor is then rejected with ErrInvalidCursor, and none is returned.
+func (m movieModel) GetAll(ctx context.Context, title string, titleMatch string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+	filters.expressions = movieSearchSortExpressions(8)
+
+	// Prepare the arguments for the query.
+	args := []interface{}{
+		titleSearchTerm(title, titleMatch), pq.Array(genres), filters.limit(), filters.offset(), filters.YearMin, filters.YearMax,
+		pq.Array(filters.Tags), filters.Search,
+	}
+
+	// Build the ORDER BY clause, which fails if the sort parameter contains a term outside the safelist.
+	orderBy, err := filters.orderBy()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	// Restrict the results to rows after the cursor when one has been supplied.
+	columns, _ := filters.sortColumns() // Already checked when building the ORDER BY clause.
+	byRelevance := validator.In("relevance", columns...)
+	keyset := "TRUE"
+	if filters.Cursor != "" {
+		c, err := decodeCursor(filters.Cursor)
+		if err != nil || len(c.Values) != len(filters.sortTerms()) || byRelevance {
+			return nil, Metadata{}, ErrInvalidCursor
+		}
+		keyset, err = filters.keysetCondition(9)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		for _, value := range c.Values {
+			args = append(args, value)
+		}
+		args = append(args, c.ID)
+	}
+
 	query := fmt.Sprintf(`
-SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
+SELECT count(*) OVER(), id, uuid, created_at, title, year, release_date, runtime, genres, tags,
+(SELECT ROUND(AVG(rating), 1) FROM reviews WHERE reviews.movie_id = movies.id), poster_url, version
 FROM movies
-WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+WHERE (%s OR $1 = '')
 AND (genres @> $2 OR $2 = '{}')
-ORDER BY %s %s, id ASC
-LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+AND (year >= $5 OR $5 = 0)
+AND (year <= $6 OR $6 = 0)
+AND (tags @> $7 OR $7 = '{}')
+AND (%s OR $8 = '')
+AND %s
+ORDER BY %s
+LIMIT $3 OFFSET $4`, titleCondition(titleMatch), searchCondition(8), keyset, orderBy)
 
-	// Create a context with a 3-second timeout for executing the query.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	// Prepare the arguments for the query.
-	args := []interface{}{title, pq.Array(genres), filters.limit(), filters.offset()}
-	rows, err := m.DB.QueryContext(ctx, query, args...)
+	rows, err := m.ReadDB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err
 	}
@@ -183,11 +756,16 @@ LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
 		err := rows.Scan(
 			&totalRecords,
 			&movie.ID,
+			&movie.UUID,
 			&movie.CreatedAt,
 			&movie.Title,
 			&movie.Year,
+			&movie.ReleaseDate,
 			&movie.Runtime,
 			pq.Array(&movie.Genres),
+			pq.Array(&movie.Tags),
+			&movie.AverageRating,
+			&movie.PosterURL,
 			&movie.Version,
 		)
 		if err != nil {
@@ -199,8 +777,167 @@ LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
 		return nil, Metadata{}, err
 	}
 
-	// Calculate pagination metadata for the result set.
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	// Calculate pagination metadata for the result set. In cursor mode the total only counts the rows after
+	// the cursor, so page numbers are meaningless and only the page size is reported.
+	var metadata Metadata
+	if filters.Cursor != "" {
+		if totalRecords > 0 {
+			metadata = Metadata{PageSize: filters.PageSize}
+		}
+	} else {
+		metadata = calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	}
+
+	// Provide a cursor for the next page if there are more records beyond the ones returned.
+	if len(movies) > 0 && totalRecords > filters.offset()+len(movies) && !byRelevance {
+		last := movies[len(movies)-1]
+		values := make([]string, len(columns))
+		for i, column := range columns {
+			values[i] = last.sortValue(column)
+		}
+		metadata.NextCursor = encodeCursor(values, last.ID)
+	}
 
 	return movies, metadata, nil
 }
+
+// Count returns the number of movie records that match the provided title, genres, and filters, the same
+// total that GetAll reports for them, without fetching any rows. Pagination and sorting in filters are ignored.
+func (m movieModel) Count(ctx context.Context, title string, titleMatch string, genres []string, filters Filters) (int, error) {
+	query := fmt.Sprintf(`
+SELECT count(*)
+FROM movies
+WHERE (%s OR $1 = '')
+AND (genres @> $2 OR $2 = '{}')
+AND (year >= $3 OR $3 = 0)
+AND (year <= $4 OR $4 = 0)
+AND (tags @> $5 OR $5 = '{}')
+AND (%s OR $6 = '')`, titleCondition(titleMatch), searchCondition(6))
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var count int
+	args := []interface{}{titleSearchTerm(title, titleMatch), pq.Array(genres), filters.YearMin, filters.YearMax, pq.Array(filters.Tags), filters.Search}
+	err := m.ReadDB.QueryRowContext(ctx, query, args...).Scan(&count)
+	return count, err
+}
+
+// Export streams every movie record that matches the provided title, genres, and filters to fn, one at a
+// time and in the order given by filters.Sort, so that the whole result set never has to be held in memory.
+// Pagination is ignored, and fn should return an error to stop the export early. Unlike the other queries,
+// no timeout is applied beyond that of ctx, since a large export can take much longer than a page of results.
+func (m movieModel) Export(ctx context.Context, title string, titleMatch string, genres []string, filters Filters, fn func(*Movie) error) error {
+	filters.expressions = movieSearchSortExpressions(6)
+
+	// Build the ORDER BY clause, which fails if the sort parameter contains a term outside the safelist.
+	orderBy, err := filters.orderBy()
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(`
+SELECT id, uuid, created_at, title, year, release_date, runtime, genres, tags,
+(SELECT ROUND(AVG(rating), 1) FROM reviews WHERE reviews.movie_id = movies.id), poster_url, version
+FROM movies
+WHERE (%s OR $1 = '')
+AND (genres @> $2 OR $2 = '{}')
+AND (year >= $3 OR $3 = 0)
+AND (year <= $4 OR $4 = 0)
+AND (tags @> $5 OR $5 = '{}')
+AND (%s OR $6 = '')
+ORDER BY %s`, titleCondition(titleMatch), searchCondition(6), orderBy)
+
+	args := []interface{}{titleSearchTerm(title, titleMatch), pq.Array(genres), filters.YearMin, filters.YearMax, pq.Array(filters.Tags), filters.Search}
+	rows, err := m.ReadDB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	// Scan each row into a Movie struct and pass it on before reading the next.
+	for rows.Next() {
+		var movie Movie
+		err := rows.Scan(
+			&movie.ID,
+			&movie.UUID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.ReleaseDate,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			pq.Array(&movie.Tags),
+			&movie.AverageRating,
+			&movie.PosterURL,
+			&movie.Version,
+		)
+		if err != nil {
+			return err
+		}
+		if err = fn(&movie); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// titleCondition returns the SQL condition used to match the title against the $1 placeholder for the given mode.
+func titleCondition(titleMatch string) string {
+	switch titleMatch {
+	case TitleMatchPrefix:
+		return "title ILIKE $1 || '%'"
+	case TitleMatchSubstring:
+		return "title ILIKE '%' || $1 || '%'"
+	default:
+		return "to_tsvector('simple', title) @@ plainto_tsquery('simple', $1)"
+	}
+}
+
+// searchCondition returns the SQL condition used to match the general search terms bound to the placeholder with
+// the given number against the words of the title and tags. movie_search_vector is defined by the migrations, so
+// that the condition can use the full-text index on it.
+func searchCondition(arg int) string {
+	return fmt.Sprintf("movie_search_vector(title, tags) @@ plainto_tsquery('simple', $%d)", arg)
+}
+
+// titleSearchTerm returns the value to bind to the $1 placeholder of titleCondition for the given mode. The ILIKE
+// modes match the term literally, so any wildcards in it are escaped.
+func titleSearchTerm(title, titleMatch string) string {
+	switch titleMatch {
+	case TitleMatchPrefix, TitleMatchSubstring:
+		return escapeLike(title)
+	default:
+		return title
+	}
+}
+
+// likeEscaper escapes the characters that have a special meaning in LIKE and ILIKE patterns.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike escapes s for use in a LIKE or ILIKE pattern with the default escape character, so that it matches
+// only itself. Without this, a search for "_" would match every row, and patterns such as "%a%b%c%" could make
+// the match needlessly expensive.
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// sortValue returns the value of the given sort column for the movie, formatted as a string for use in a cursor.
+func (movie *Movie) sortValue(column string) string {
+	switch column {
+	case "title":
+		return movie.Title
+	case "year":
+		return strconv.FormatInt(int64(movie.Year), 10)
+	case "runtime":
+		return strconv.FormatInt(int64(movie.Runtime), 10)
+	case "release_date":
+		if movie.ReleaseDate != nil {
+			return movie.ReleaseDate.String()
+		}
+		return NewDate(int(movie.Year), time.January, 1).String()
+	default:
+		return strconv.FormatInt(movie.ID, 10)
+	}
+}
@@ -0,0 +1,90 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"cinevault.interimme.net/internal/validator"
+	_ "embed"
+	"strings"
+	"unicode"
+)
+
+// commonPasswordsFile holds a list of widely used passwords, one per line, that are rejected when the password
+// policy denies common passwords.
+//
+//go:embed "common_passwords.txt"
+var commonPasswordsFile []byte
+
+// commonPasswords is the set of entries in commonPasswordsFile, lower-cased so that lookups ignore case.
+var commonPasswords = func() map[string]bool {
+	passwords := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(commonPasswordsFile))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			passwords[strings.ToLower(line)] = true
+		}
+	}
+	return passwords
+}()
+
+// Validation codes for the password policy rules.
+const (
+	CodeMissingMixedCase = "missing_mixed_case" // The password does not contain both upper and lower case letters.
+	CodeMissingDigit     = "missing_digit"      // The password does not contain a digit.
+	CodeMissingSymbol    = "missing_symbol"     // The password does not contain a symbol.
+	CodeCommonPassword   = "common_password"    // The password is on the list of common passwords.
+)
+
+// PasswordPolicy describes the rules that new passwords must follow, in addition to the length limits checked by
+// ValidatePasswordPlaintext.
+type PasswordPolicy struct {
+	MinLength        int  // Minimum length in bytes; values below 8 have no effect, as 8 is always required.
+	RequireMixedCase bool // Require both upper and lower case letters.
+	RequireDigit     bool // Require at least one digit.
+	RequireSymbol    bool // Require at least one character that is not a letter, digit, or space.
+	DenyCommon       bool // Reject passwords on the embedded list of common passwords.
+}
+
+// ValidatePassword checks that a new password meets the length limits and the rules of the given policy. Every
+// rule that fails is reported: the messages are combined into a single "password" error, whose code is that of
+// the first failed rule.
+func ValidatePassword(v *validator.Validator, password string, policy PasswordPolicy) {
+	// Check the basic length limits first, which also covers a missing password.
+	ValidatePasswordPlaintext(v, password)
+	if _, exists := v.Errors["password"]; exists {
+		return
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case !unicode.IsLetter(r) && !unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+
+	// Collect the rules that the password fails.
+	var codes []string
+	var messages []validator.Message
+	fail := func(ok bool, code, message string, args ...interface{}) {
+		if !ok {
+			codes = append(codes, code)
+			messages = append(messages, validator.Message{ID: message, Args: args})
+		}
+	}
+	fail(len(password) >= policy.MinLength, validator.CodeTooShort, "must be at least %d bytes long", policy.MinLength)
+	fail(!policy.RequireMixedCase || (hasUpper && hasLower), CodeMissingMixedCase, "must contain both upper and lower case letters")
+	fail(!policy.RequireDigit || hasDigit, CodeMissingDigit, "must contain at least one digit")
+	fail(!policy.RequireSymbol || hasSymbol, CodeMissingSymbol, "must contain at least one symbol")
+	fail(!policy.DenyCommon || !commonPasswords[strings.ToLower(password)], CodeCommonPassword, "must not be a commonly used password")
+
+	if len(messages) > 0 {
+		v.AddCodedMessages("password", codes[0], messages...)
+	}
+}
@@ -1,8 +1,8 @@
 package data
 
 import (
+	"cinevault.interimme.net/internal/validator"
 	"context"
-	"database/sql"
 	"github.com/lib/pq"
 	"time"
 )
@@ -20,13 +20,48 @@ func (p Permissions) Include(code string) bool {
 	return false // Return false if the permission code is not found.
 }
 
-// PermissionModel represents the data access object for permissions-related operations.
-type PermissionModel struct {
-	DB *sql.DB // Database connection pool.
+// ValidatePermissionCodes checks that codes is a non-empty list of distinct permission codes, each of which
+// appears in known.
+func ValidatePermissionCodes(v *validator.Validator, codes []string, known Permissions) {
+	v.CheckCoded(len(codes) >= 1, "permissions", validator.CodeTooFew, "must contain at least 1 permission")
+	v.CheckCoded(validator.Unique(codes), "permissions", validator.CodeDuplicate, "must not contain duplicate values")
+	for _, code := range codes {
+		if !known.Include(code) {
+			v.AddError("permissions", "must only contain known permission codes")
+			return
+		}
+	}
+}
+
+// PermissionModel is the interface to user permissions in the database.
+type PermissionModel interface {
+	GetAllForUser(ctx context.Context, userID int64) (Permissions, error)
+	GetAll(ctx context.Context) (Permissions, error)
+	AddForUser(ctx context.Context, userID int64, codes ...string) error
+	RemoveForUser(ctx context.Context, userID int64, codes ...string) error
+}
+
+// permissionModel is the PostgreSQL implementation of PermissionModel.
+type permissionModel struct {
+	DB     *DB                 // Database connection pool, used for writes.
+	ReadDB *DB                 // Connection pool used for reads, which is the read replica if one is configured.
+	cache  *Cache[Permissions] // Cache of each user's permissions used by GetAllForUser, or nil if caching is disabled.
 }
 
-// GetAllForUser retrieves all permission codes for a specific user from the database.
-func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+// GetAllForUser retrieves all permission codes for a specific user from the database. If caching is enabled, the
+// permissions are served from the cache when it holds them, and added to the cache otherwise.
+func (m permissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	if permissions, ok := m.cache.get(userID); ok {
+		return append(Permissions(nil), permissions...), nil
+	}
+
+	// Read permissions that were changed while cached from the primary, so that a lagging replica can't bring back
+	// a permission that was just removed.
+	db := m.ReadDB
+	if m.cache.invalidated(userID) {
+		db = m.DB
+	}
+
 	// SQL query to select all permission codes associated with a specific user.
 	query := `
 SELECT permissions.code
@@ -35,12 +70,12 @@ INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
 INNER JOIN users ON users_permissions.user_id = users.id
 WHERE users.id = $1`
 
-	// Create a context with a 3-second timeout for executing the query.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	// Execute the query with the user ID as a parameter.
-	rows, err := m.DB.QueryContext(ctx, query, userID)
+	rows, err := db.QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, err // Return an error if the query fails.
 	}
@@ -61,21 +96,76 @@ WHERE users.id = $1`
 	if err = rows.Err(); err != nil {
 		return nil, err
 	}
+	m.cache.put(userID, append(Permissions(nil), permissions...), db == m.DB, nil)
 	return permissions, nil // Return the permissions slice.
 }
 
-// AddForUser adds new permissions for a specific user in the database.
-func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
+// GetAll retrieves every permission code defined in the database, in alphabetical order.
+func (m permissionModel) GetAll(ctx context.Context) (Permissions, error) {
+	query := `
+SELECT code
+FROM permissions
+ORDER BY code`
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.ReadDB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions Permissions
+	// Iterate over the result set and append each permission to the permissions slice.
+	for rows.Next() {
+		var permission string
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+// AddForUser adds new permissions for a specific user in the database. Permissions the user already has are left
+// as they are. The user's cached permissions, if any, are dropped.
+func (m permissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
 	// SQL query to insert new user permissions.
 	query := `
 INSERT INTO users_permissions
-SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+ON CONFLICT DO NOTHING`
 
-	// Create a context with a 3-second timeout for executing the query.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	// Execute the query with the user ID and permission codes as parameters.
 	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	m.cache.invalidate(userID)
 	return err // Return any error encountered during query execution.
 }
+
+// RemoveForUser removes permissions from a specific user in the database. Codes the user doesn't have are ignored.
+// The user's cached permissions, if any, are dropped.
+func (m permissionModel) RemoveForUser(ctx context.Context, userID int64, codes ...string) error {
+	query := `
+DELETE FROM users_permissions
+WHERE user_id = $1
+AND permission_id IN (SELECT id FROM permissions WHERE code = ANY($2))`
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	// Execute the query with the user ID and permission codes as parameters.
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	m.cache.invalidate(userID)
+	return err
+}
@@ -0,0 +1,210 @@
+package data
+
+import (
+	"cinevault.interimme.net/internal/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrDuplicateReview is returned when a user tries to review a movie they have already reviewed.
+var ErrDuplicateReview = errors.New("duplicate review")
+
+// Review represents a user's review of a movie.
+type Review struct {
+	ID        int64     `json:"id"`         // Unique identifier for the review.
+	CreatedAt time.Time `json:"created_at"` // Timestamp when the review was created.
+	UserID    int64     `json:"user_id"`    // ID of the user who wrote the review.
+	MovieID   int64     `json:"movie_id"`   // ID of the movie being reviewed.
+	Rating    int32     `json:"rating"`     // Rating from 1 to 10.
+	Body      string    `json:"body"`       // Free-text body of the review, which may be empty.
+	Version   int32     `json:"version"`    // The version number of the review record for optimistic concurrency control.
+}
+
+// ValidateReview validates the fields of a Review struct to ensure they meet the required criteria.
+func ValidateReview(v *validator.Validator, review *Review) {
+	v.CheckCoded(review.Rating != 0, "rating", validator.CodeRequired, "must be provided")
+	v.CheckCoded(review.Rating >= 1 && review.Rating <= 10, "rating", validator.CodeOutOfRange, "must be between 1 and 10")
+	v.CheckCoded(len(review.Body) <= 10_000, "body", validator.CodeTooLong, "must not be more than 10000 bytes long")
+}
+
+// ReviewModel is the interface to the reviews in the database.
+type ReviewModel interface {
+	Insert(ctx context.Context, review *Review) error
+	Get(ctx context.Context, id int64) (*Review, error)
+	Update(ctx context.Context, review *Review) error
+	Delete(ctx context.Context, id, userID int64) error
+	GetAllForMovie(ctx context.Context, movieID int64) ([]*Review, error)
+}
+
+// reviewModel is the PostgreSQL implementation of ReviewModel.
+type reviewModel struct {
+	DB         *DB            // Database connection pool, used for writes.
+	ReadDB     *DB            // Connection pool used for reads, which is the read replica if one is configured.
+	movieCache *Cache[*Movie] // Cache of movies, whose average ratings change with their reviews.
+}
+
+// Insert adds a new review record to the database, returning ErrDuplicateReview if the user has already
+// reviewed the movie. Changing a movie's reviews changes its average rating, so the movie is dropped from the cache
+// by this and the other methods that write reviews.
+func (m reviewModel) Insert(ctx context.Context, review *Review) error {
+	query := `
+INSERT INTO reviews (user_id, movie_id, rating, body)
+VALUES ($1, $2, $3, $4)
+RETURNING id, created_at, version`
+	args := []interface{}{review.UserID, review.MovieID, review.Rating, review.Body}
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	// Execute the query and scan the returned id, created_at, and version into the review struct.
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&review.ID, &review.CreatedAt, &review.Version)
+	if err != nil {
+		switch {
+		case isViolation(err, pgUniqueViolation, "reviews_user_id_movie_id_key"):
+			return wrapError(err, ErrDuplicateReview) // Return a specific error if the user has already reviewed this movie.
+		default:
+			return wrapError(err, nil)
+		}
+	}
+	m.movieCache.invalidate(review.MovieID)
+	return nil
+}
+
+// Get retrieves a specific review record from the database by its ID.
+func (m reviewModel) Get(ctx context.Context, id int64) (*Review, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound // Return an error if the ID is invalid.
+	}
+
+	query := `
+SELECT id, created_at, user_id, movie_id, rating, body, version
+FROM reviews
+WHERE id = $1`
+	var review Review
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	// Execute the query and scan the result into a review struct.
+	err := m.ReadDB.QueryRowContext(ctx, query, id).Scan(
+		&review.ID,
+		&review.CreatedAt,
+		&review.UserID,
+		&review.MovieID,
+		&review.Rating,
+		&review.Body,
+		&review.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound // Return a custom error if no rows are found.
+		default:
+			return nil, err
+		}
+	}
+	return &review, nil
+}
+
+// Update modifies the rating and body of an existing review record in the database.
+func (m reviewModel) Update(ctx context.Context, review *Review) error {
+	query := `
+UPDATE reviews
+SET rating = $1, body = $2, version = version + 1
+WHERE id = $3 AND version = $4
+RETURNING version`
+	args := []interface{}{review.Rating, review.Body, review.ID, review.Version}
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	// Execute the update query and scan the returned version into the review struct.
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&review.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict // Return a custom error if there is an edit conflict.
+		default:
+			return err
+		}
+	}
+	m.movieCache.invalidate(review.MovieID)
+	return nil
+}
+
+// Delete removes a specific review record from the database by its ID. Only the user who wrote the review
+// may delete it, so a review belonging to another user is reported as not found.
+func (m reviewModel) Delete(ctx context.Context, id, userID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound // Return an error if the ID is invalid.
+	}
+	query := `
+DELETE FROM reviews
+WHERE id = $1 AND user_id = $2
+RETURNING movie_id`
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	// Execute the delete query, scanning the ID of the reviewed movie so that it can be dropped from the cache.
+	var movieID int64
+	err := m.DB.QueryRowContext(ctx, query, id, userID).Scan(&movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound // Return a custom error if no rows are deleted.
+		default:
+			return err
+		}
+	}
+	m.movieCache.invalidate(movieID)
+	return nil
+}
+
+// GetAllForMovie retrieves all reviews for a specific movie, newest first.
+func (m reviewModel) GetAllForMovie(ctx context.Context, movieID int64) ([]*Review, error) {
+	query := `
+SELECT id, created_at, user_id, movie_id, rating, body, version
+FROM reviews
+WHERE movie_id = $1
+ORDER BY created_at DESC, id DESC`
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.ReadDB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reviews := []*Review{}
+	// Loop through the result set and scan each row into a Review struct.
+	for rows.Next() {
+		var review Review
+		err := rows.Scan(
+			&review.ID,
+			&review.CreatedAt,
+			&review.UserID,
+			&review.MovieID,
+			&review.Rating,
+			&review.Body,
+			&review.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, &review)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return reviews, nil
+}
@@ -3,6 +3,8 @@ package data
 import (
 	"errors"
 	"fmt"
+	"math"
+	"regexp"
 	"strconv"
 	"strings"
 )
@@ -26,9 +28,23 @@ func (r Runtime) MarshalJSON() ([]byte, error) {
 	return []byte(quotedJSONValue), nil
 }
 
+// hoursMinutesRX matches runtimes given in hours and minutes, such as "2h 15m", "2h", or "90m".
+var hoursMinutesRX = regexp.MustCompile(`^(?:(\d+)h)?\s*(?:(\d+)m)?$`)
+
 // UnmarshalJSON implements the json.Unmarshaler interface for the Runtime type.
-// It parses a JSON-encoded string in the format "<number> mins" and converts it to a Runtime value.
+// It accepts a JSON-encoded string in the format "<number> mins", in hours and minutes such as "2h 15m" or "90m",
+// or a bare number of minutes, either as a string such as "135" or as a JSON number, and converts it to a Runtime
+// value. As is the convention for json.Unmarshaler, a JSON null leaves the value unchanged.
 func (r *Runtime) UnmarshalJSON(jsonValue []byte) error {
+	if string(jsonValue) == "null" {
+		return nil
+	}
+
+	// Accept a bare JSON number as a number of minutes.
+	if len(jsonValue) > 0 && jsonValue[0] != '"' {
+		return r.setMinutes(string(jsonValue), "0")
+	}
+
 	// Remove the surrounding quotes from the JSON string value.
 	unquotedJSONValue, err := strconv.Unquote(string(jsonValue))
 	if err != nil {
@@ -37,19 +53,46 @@ func (r *Runtime) UnmarshalJSON(jsonValue []byte) error {
 
 	// Split the unquoted string into two parts: the number and the unit (e.g., "123 mins").
 	parts := strings.Split(unquotedJSONValue, " ")
+	if len(parts) == 2 && parts[1] == "mins" {
+		return r.setMinutes(parts[0], "0")
+	}
 
-	// Check if the split result is exactly two parts and the unit is "mins".
-	if len(parts) != 2 || parts[1] != "mins" {
+	// Otherwise, the string must be in hours and minutes, or a bare number of minutes.
+	matches := hoursMinutesRX.FindStringSubmatch(unquotedJSONValue)
+	switch {
+	case matches != nil && (matches[1] != "" || matches[2] != ""):
+		return r.setMinutes(matches[2], matches[1])
+	case unquotedJSONValue != "" && strings.Trim(unquotedJSONValue, "0123456789") == "":
+		return r.setMinutes(unquotedJSONValue, "0")
+	default:
 		return ErrInvalidRuntimeFormat // Return an error if the format is incorrect.
 	}
+}
 
-	// Parse the number part into an int32.
-	i, err := strconv.ParseInt(parts[0], 10, 32)
-	if err != nil {
-		return ErrInvalidRuntimeFormat // Return an error if the number cannot be parsed.
+// setMinutes parses the given numbers of minutes and hours, either of which may be empty for none, and assigns
+// their total in minutes to the receiver. It returns ErrInvalidRuntimeFormat if either can't be parsed or the total
+// doesn't fit in a Runtime.
+func (r *Runtime) setMinutes(minutes, hours string) error {
+	var total int64
+	for _, part := range []struct {
+		value      string
+		multiplier int64
+	}{{minutes, 1}, {hours, 60}} {
+		if part.value == "" {
+			continue
+		}
+		// Parse the number into an int32.
+		i, err := strconv.ParseInt(part.value, 10, 32)
+		if err != nil {
+			return ErrInvalidRuntimeFormat // Return an error if the number cannot be parsed.
+		}
+		total += i * part.multiplier
+	}
+	if total < math.MinInt32 || total > math.MaxInt32 {
+		return ErrInvalidRuntimeFormat
 	}
 
-	// Convert the parsed integer to a Runtime type and assign it to the receiver.
-	*r = Runtime(i)
+	// Convert the total to a Runtime type and assign it to the receiver.
+	*r = Runtime(total)
 	return nil // Return nil to indicate successful parsing.
 }
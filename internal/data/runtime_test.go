@@ -0,0 +1,55 @@
+package data
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRuntimeUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		want    Runtime
+		wantErr error
+	}{
+		{name: "hours and minutes", json: `"2h 15m"`, want: 135},
+		{name: "hours and minutes without a space", json: `"2h15m"`, want: 135},
+		{name: "hours only", json: `"2h"`, want: 120},
+		{name: "minutes only", json: `"90m"`, want: 90},
+		{name: "bare number string", json: `"135"`, want: 135},
+		{name: "JSON number", json: `135`, want: 135},
+		{name: "mins suffix", json: `"135 mins"`, want: 135},
+		{name: "unit without a number", json: `"h"`, wantErr: ErrInvalidRuntimeFormat},
+		{name: "space before the unit", json: `"2 h"`, wantErr: ErrInvalidRuntimeFormat},
+		{name: "minutes before hours", json: `"15m 2h"`, wantErr: ErrInvalidRuntimeFormat},
+		{name: "fractional hours", json: `"1.5h"`, wantErr: ErrInvalidRuntimeFormat},
+		{name: "empty string", json: `""`, wantErr: ErrInvalidRuntimeFormat},
+		{name: "overflowing number string", json: `"2147483648"`, wantErr: ErrInvalidRuntimeFormat},
+		{name: "overflowing JSON number", json: `2147483648`, wantErr: ErrInvalidRuntimeFormat},
+		{name: "overflowing hours", json: `"35791395h"`, wantErr: ErrInvalidRuntimeFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r Runtime
+			err := r.UnmarshalJSON([]byte(tt.json))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("UnmarshalJSON(%s) returned error %v; want %v", tt.json, err, tt.wantErr)
+			}
+			if r != tt.want {
+				t.Errorf("UnmarshalJSON(%s) set %d; want %d", tt.json, r, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuntimeUnmarshalJSONNull(t *testing.T) {
+	r := Runtime(90)
+	err := r.UnmarshalJSON([]byte("null"))
+	if err != nil {
+		t.Fatalf("UnmarshalJSON(null) returned error %v; want nil", err)
+	}
+	if r != 90 {
+		t.Errorf("UnmarshalJSON(null) set %d; want the value to stay 90", r)
+	}
+}
@@ -5,8 +5,12 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/sha512"
 	"database/sql"
 	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"github.com/lib/pq"
 	"time"
 )
 
@@ -15,24 +19,79 @@ const (
 	ScopeActivation     = "activation"     // Token scope for account activation.
 	ScopeAuthentication = "authentication" // Token scope for user authentication.
 	ScopePasswordReset  = "password-reset" // Token scope for password reset.
+	ScopeRefresh        = "refresh"        // Token scope for renewing an expired authentication JWT.
+	ScopeEmailChange    = "email-change"   // Token scope for confirming a change of email address.
+	ScopeMagicLink      = "magic-link"     // Token scope for logging in without a password.
 )
 
 // Token struct represents a token with its plaintext value, hashed value, associated user ID, expiry time, and scope.
 type Token struct {
-	Plaintext string    `json:"token"`  // Plaintext representation of the token.
-	Hash      []byte    `json:"-"`      // SHA-256 hash of the plaintext token (not included in JSON output).
-	UserID    int64     `json:"-"`      // ID of the user to whom the token belongs (not included in JSON output).
-	Expiry    time.Time `json:"expiry"` // Expiry time of the token.
-	Scope     string    `json:"-"`      // Scope of the token (e.g., activation, authentication, password reset) (not included in JSON output).
+	Plaintext  string    `json:"token"`  // Plaintext representation of the token.
+	Hash       []byte    `json:"-"`      // Hash of the plaintext token (not included in JSON output).
+	HashScheme string    `json:"-"`      // Scheme the hash was made with, such as sha256 (not included in JSON output).
+	UserID     int64     `json:"-"`      // ID of the user to whom the token belongs (not included in JSON output).
+	Expiry     time.Time `json:"expiry"` // Expiry time of the token.
+	Scope      string    `json:"-"`      // Scope of the token (e.g., activation, authentication, password reset) (not included in JSON output).
+	NewEmail   string    `json:"-"`      // For email-change tokens, the address the user wants to switch to (not included in JSON output).
 }
 
-// generateToken creates a new Token struct for a specific user, with a given time-to-live (TTL) and scope.
-func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
-	// Initialize a new Token struct with the provided user ID, expiry time, and scope.
+// SessionScopes are the scopes of the tokens that keep a user logged in, which users can list and revoke as their
+// sessions.
+var SessionScopes = []string{ScopeAuthentication, ScopeRefresh}
+
+// Session describes a token that keeps a user logged in, without revealing the token itself. The identifier is the
+// start of the token's hash, enough for users to tell their sessions apart but not to use or look up the token.
+type Session struct {
+	ID         int64     `json:"id"`         // Unique identifier of the token.
+	Scope      string    `json:"scope"`      // Scope of the token (authentication or refresh).
+	Identifier string    `json:"identifier"` // First bytes of the token's hash, in hexadecimal.
+	CreatedAt  time.Time `json:"created_at"` // Timestamp when the token was issued.
+	Expiry     time.Time `json:"expiry"`     // Expiry time of the token.
+}
+
+// Token hashing schemes. New tokens are hashed with the configured scheme, which is recorded with each token, so
+// that tokens made before the scheme was changed can still be used until they expire.
+const (
+	TokenHashSHA256 = "sha256" // SHA-256, the default.
+	TokenHashSHA512 = "sha512" // SHA-512, for a longer hash.
+)
+
+// TokenHashSchemes lists the schemes tokens can be hashed with.
+var TokenHashSchemes = []string{TokenHashSHA256, TokenHashSHA512}
+
+// hashToken returns the hash of a plaintext token under the given scheme, which must be one of TokenHashSchemes.
+// The tokens are random, so a fast hash is enough to stop a leaked tokens table from being used to log in.
+func hashToken(scheme, tokenPlaintext string) []byte {
+	switch scheme {
+	case TokenHashSHA512:
+		hash := sha512.Sum512([]byte(tokenPlaintext))
+		return hash[:]
+	default:
+		hash := sha256.Sum256([]byte(tokenPlaintext))
+		return hash[:]
+	}
+}
+
+// tokenHashes returns the schemes in TokenHashSchemes and the hash of a plaintext token under each of them, for
+// matching tokens whichever scheme they were hashed with, using the condition
+// (hash_scheme, hash) IN (SELECT * FROM unnest($1::text[], $2::bytea[])).
+func tokenHashes(tokenPlaintext string) (pq.StringArray, pq.ByteaArray) {
+	hashes := make(pq.ByteaArray, len(TokenHashSchemes))
+	for i, scheme := range TokenHashSchemes {
+		hashes[i] = hashToken(scheme, tokenPlaintext)
+	}
+	return pq.StringArray(TokenHashSchemes), hashes
+}
+
+// generateToken creates a new Token struct for a specific user, with a given time-to-live (TTL) and scope, hashed
+// with the given scheme.
+func generateToken(userID int64, ttl time.Duration, scope, hashScheme string) (*Token, error) {
+	// Initialize a new Token struct with the provided user ID, expiry time, scope, and hashing scheme.
 	token := &Token{
-		UserID: userID,
-		Expiry: time.Now().Add(ttl), // Set the expiry time to the current time plus the TTL.
-		Scope:  scope,
+		UserID:     userID,
+		Expiry:     time.Now().Add(ttl), // Set the expiry time to the current time plus the TTL.
+		Scope:      scope,
+		HashScheme: hashScheme,
 	}
 
 	// Create a slice of 16 random bytes to use as the base for the token.
@@ -47,9 +106,8 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 	// Encode the random bytes to a base32 string without padding to create the plaintext token.
 	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
 
-	// Generate a SHA-256 hash of the plaintext token and store it in the Hash field.
-	hash := sha256.Sum256([]byte(token.Plaintext))
-	token.Hash = hash[:]
+	// Hash the plaintext token and store it in the Hash field.
+	token.Hash = hashToken(hashScheme, token.Plaintext)
 	return token, nil // Return the generated token.
 }
 
@@ -62,36 +120,98 @@ func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
 }
 
-// TokenModel struct wraps a database connection pool and provides methods for working with tokens.
-type TokenModel struct {
-	DB *sql.DB
+// TokenModel is the interface to user tokens in the database.
+type TokenModel interface {
+	New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error)
+	NewEmailChange(ctx context.Context, userID int64, ttl time.Duration, newEmail string) (*Token, error)
+	Get(ctx context.Context, scope, tokenPlaintext string) (*Token, error)
+	Insert(ctx context.Context, token *Token) error
+	DeleteAllForUser(ctx context.Context, scope string, userID int64) error
+	Delete(ctx context.Context, scope, tokenPlaintext string) error
+	GetAllForUser(ctx context.Context, userID int64) ([]*Session, error)
+	DeleteForUser(ctx context.Context, id, userID int64) error
+}
+
+// tokenModel is the PostgreSQL implementation of TokenModel.
+type tokenModel struct {
+	DB         *DB
+	hashScheme string // Scheme new tokens are hashed with, one of TokenHashSchemes.
 }
 
 // New generates a new token for a user and inserts it into the database.
-func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+func (m tokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
 	// Generate a new token.
-	token, err := generateToken(userID, ttl, scope)
+	token, err := generateToken(userID, ttl, scope, m.hashScheme)
 	if err != nil {
 		return nil, err
 	}
 
 	// Insert the token into the database.
-	err = m.Insert(token)
+	err = m.Insert(ctx, token)
 	return token, err // Return the generated token and any error from the insert operation.
 }
 
+// NewEmailChange generates a new email-change token for a user, recording the address they want to switch to,
+// and inserts it into the database.
+func (m tokenModel) NewEmailChange(ctx context.Context, userID int64, ttl time.Duration, newEmail string) (*Token, error) {
+	// Generate a new token.
+	token, err := generateToken(userID, ttl, ScopeEmailChange, m.hashScheme)
+	if err != nil {
+		return nil, err
+	}
+	token.NewEmail = newEmail
+
+	// Insert the token into the database.
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+// Get retrieves an unexpired token by its plaintext value and scope, returning ErrRecordNotFound if there is none.
+func (m tokenModel) Get(ctx context.Context, scope, tokenPlaintext string) (*Token, error) {
+	// Hash the plaintext token with each scheme to match the stored value.
+	schemes, hashes := tokenHashes(tokenPlaintext)
+
+	query := `
+SELECT hash, hash_scheme, user_id, expiry, scope, COALESCE(new_email, '')
+FROM tokens
+WHERE (hash_scheme, hash) IN (SELECT * FROM unnest($1::text[], $2::bytea[])) AND scope = $3 AND expiry > $4`
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	token := Token{Plaintext: tokenPlaintext}
+	err := m.DB.QueryRowContext(ctx, query, schemes, hashes, scope, time.Now()).Scan(
+		&token.Hash,
+		&token.HashScheme,
+		&token.UserID,
+		&token.Expiry,
+		&token.Scope,
+		&token.NewEmail,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound // Return a specific error if no token is found.
+		default:
+			return nil, err
+		}
+	}
+	return &token, nil
+}
+
 // Insert adds a new token record to the database.
-func (m TokenModel) Insert(token *Token) error {
+func (m tokenModel) Insert(ctx context.Context, token *Token) error {
 	// SQL query to insert a new token into the tokens table.
 	query := `
-INSERT INTO tokens (hash, user_id, expiry, scope)
-VALUES ($1, $2, $3, $4)`
+INSERT INTO tokens (hash, hash_scheme, user_id, expiry, scope, new_email)
+VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''))`
 
 	// Arguments for the SQL query.
-	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope}
+	args := []interface{}{token.Hash, token.HashScheme, token.UserID, token.Expiry, token.Scope, token.NewEmail}
 
-	// Create a context with a 3-second timeout for executing the query.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	// Execute the query and insert the token into the database.
@@ -100,17 +220,107 @@ VALUES ($1, $2, $3, $4)`
 }
 
 // DeleteAllForUser deletes all tokens for a specific user and scope from the database.
-func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
+func (m tokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
 	// SQL query to delete all tokens for a specific user and scope.
 	query := `
 DELETE FROM tokens
 WHERE scope = $1 AND user_id = $2`
 
-	// Create a context with a 3-second timeout for executing the query.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	// Execute the query and delete the tokens from the database.
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
 	return err // Return any error encountered during query execution.
 }
+
+// Delete removes a single token, identified by its plaintext value and scope, from the database. It returns
+// ErrRecordNotFound if no matching token exists, which lets callers treat a successful delete as consuming the token.
+func (m tokenModel) Delete(ctx context.Context, scope, tokenPlaintext string) error {
+	// Hash the plaintext token with each scheme to match the stored value.
+	schemes, hashes := tokenHashes(tokenPlaintext)
+
+	// SQL query to delete the token with the given hash and scope.
+	query := `
+DELETE FROM tokens
+WHERE (hash_scheme, hash) IN (SELECT * FROM unnest($1::text[], $2::bytea[])) AND scope = $3`
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	// Execute the query and delete the token from the database.
+	result, err := m.DB.ExecContext(ctx, query, schemes, hashes, scope)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound // Return a custom error if the token did not exist.
+	}
+	return nil
+}
+
+// GetAllForUser retrieves the unexpired session tokens of a user, newest first.
+func (m tokenModel) GetAllForUser(ctx context.Context, userID int64) ([]*Session, error) {
+	query := `
+SELECT id, scope, substring(hash from 1 for 4), created_at, expiry
+FROM tokens
+WHERE user_id = $1 AND scope = ANY($2) AND expiry > $3
+ORDER BY created_at DESC, id DESC`
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, pq.Array(SessionScopes), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		var session Session
+		var hashPrefix []byte
+		err := rows.Scan(&session.ID, &session.Scope, &hashPrefix, &session.CreatedAt, &session.Expiry)
+		if err != nil {
+			return nil, err
+		}
+		session.Identifier = hex.EncodeToString(hashPrefix)
+		sessions = append(sessions, &session)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// DeleteForUser removes the session token with the given ID, provided it belongs to the given user. It returns
+// ErrRecordNotFound if the user has no session token with that ID, so that users can't probe each other's tokens.
+func (m tokenModel) DeleteForUser(ctx context.Context, id, userID int64) error {
+	query := `
+DELETE FROM tokens
+WHERE id = $1 AND user_id = $2 AND scope = ANY($3)`
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID, pq.Array(SessionScopes))
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
@@ -3,9 +3,9 @@ package data
 import (
 	"cinevault.interimme.net/internal/validator"
 	"context"
-	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
 	"golang.org/x/crypto/bcrypt"
 	"time"
 )
@@ -24,7 +24,7 @@ type User struct {
 	Email     string    `json:"email"`      // The user's email address.
 	Password  password  `json:"-"`          // The user's password, stored as a hashed value (not included in JSON output).
 	Activated bool      `json:"activated"`  // Indicates whether the user's account is activated.
-	Version   int       `json:"-"`          // Version number for optimistic concurrency control (not included in JSON output).
+	Version   int32     `json:"-"`          // Version number for optimistic concurrency control (not included in JSON output).
 }
 
 // IsAnonymous checks if the user is an anonymous user (not logged in).
@@ -38,14 +38,33 @@ type password struct {
 	hash      []byte  // The bcrypt hash of the password.
 }
 
-// UserModel wraps a sql.DB connection pool for performing operations on the users table.
-type UserModel struct {
-	DB *sql.DB
+// UserSortSafelist lists the sort values accepted when listing user accounts.
+var UserSortSafelist = SortSafelist("id", "created_at", "name")
+
+// UserModel is the interface to user accounts in the database.
+type UserModel interface {
+	Insert(ctx context.Context, user *User) error
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	Update(ctx context.Context, user *User) error
+	GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error)
+	Get(ctx context.Context, id int64) (*User, error)
+	GetAll(ctx context.Context, email string, activated *bool, filters Filters) ([]*User, Metadata, error)
+	GetStats(ctx context.Context) (UserStats, error)
+	GetTOTP(ctx context.Context, id int64) ([]byte, bool, error)
+	SetTOTP(ctx context.Context, id int64, secret []byte, enabled bool) error
+	Delete(ctx context.Context, id int64) error
 }
 
-// Set hashes a plaintext password using bcrypt and stores both the plaintext (temporarily) and hashed password.
-func (p *password) Set(plaintextPassword string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12) // Hash the password with bcrypt at cost 12.
+// userModel is the PostgreSQL implementation of UserModel.
+type userModel struct {
+	DB     *DB // Database connection pool, used for writes.
+	ReadDB *DB // Connection pool used for reads, which is the read replica if one is configured.
+}
+
+// Set hashes a plaintext password using bcrypt at the given cost and stores both the plaintext (temporarily) and
+// hashed password.
+func (p *password) Set(plaintextPassword string, cost int) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), cost) // Hash the password with bcrypt.
 	if err != nil {
 		return err
 	}
@@ -68,24 +87,35 @@ func (p *password) Matches(plaintextPassword string) (bool, error) {
 	return true, nil // Return true if the passwords match.
 }
 
+// NeedsRehash reports whether the stored hash was generated at a lower bcrypt cost than targetCost, meaning that
+// it should be replaced by a new hash of the plaintext the next time it is known, such as after a successful
+// login. A hash whose cost can't be read is left alone.
+func (p *password) NeedsRehash(targetCost int) bool {
+	cost, err := bcrypt.Cost(p.hash)
+	if err != nil {
+		return false
+	}
+	return cost < targetCost
+}
+
 // ValidateEmail checks if the email meets the application's validation criteria.
 func ValidateEmail(v *validator.Validator, email string) {
-	v.Check(email != "", "email", "must be provided")                                              // Check that the email is not empty.
-	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address") // Check that the email matches a valid format.
+	v.CheckCoded(email != "", "email", validator.CodeRequired, "must be provided")                                                   // Check that the email is not empty.
+	v.CheckCoded(validator.Matches(email, validator.EmailRX), "email", validator.CodeInvalidFormat, "must be a valid email address") // Check that the email matches a valid format.
 }
 
 // ValidatePasswordPlaintext checks if the plaintext password meets the application's security criteria.
 func ValidatePasswordPlaintext(v *validator.Validator, password string) {
-	v.Check(password != "", "password", "must be provided")                         // Check that the password is not empty.
-	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")        // Check that the password is at least 8 characters long.
-	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long") // Check that the password is not longer than 72 characters.
+	v.CheckCoded(password != "", "password", validator.CodeRequired, "must be provided")                        // Check that the password is not empty.
+	v.CheckCoded(len(password) >= 8, "password", validator.CodeTooShort, "must be at least 8 bytes long")       // Check that the password is at least 8 characters long.
+	v.CheckCoded(len(password) <= 72, "password", validator.CodeTooLong, "must not be more than 72 bytes long") // Check that the password is not longer than 72 characters.
 }
 
 // ValidateUser validates the user's details and ensures the password hash is present.
 func ValidateUser(v *validator.Validator, user *User) {
-	v.Check(user.Name != "", "name", "must be provided")                           // Check that the name is not empty.
-	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes long") // Check that the name is not too long.
-	ValidateEmail(v, user.Email)                                                   // Validate the email format.
+	v.CheckCoded(user.Name != "", "name", validator.CodeRequired, "must be provided")                          // Check that the name is not empty.
+	v.CheckCoded(len(user.Name) <= 500, "name", validator.CodeTooLong, "must not be more than 500 bytes long") // Check that the name is not too long.
+	ValidateEmail(v, user.Email)                                                                               // Validate the email format.
 
 	if user.Password.plaintext != nil {
 		ValidatePasswordPlaintext(v, *user.Password.plaintext) // Validate the plaintext password if it's provided.
@@ -97,7 +127,7 @@ func ValidateUser(v *validator.Validator, user *User) {
 }
 
 // Insert adds a new user to the database, returning an error if the email already exists.
-func (m UserModel) Insert(user *User) error {
+func (m userModel) Insert(ctx context.Context, user *User) error {
 	query := `
 INSERT INTO users (name, email, password_hash, activated)
 VALUES ($1, $2, $3, $4)
@@ -105,35 +135,35 @@ RETURNING id, created_at, version`
 
 	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	// Execute the query and scan the returned id, created_at, and version into the user struct.
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
-			return ErrDuplicateEmail // Return a specific error if the email is already in use.
+		case isViolation(err, pgUniqueViolation, "users_email_key"):
+			return wrapError(err, ErrDuplicateEmail) // Return a specific error if the email is already in use.
 		default:
-			return err // Return any other errors that occur.
+			return wrapError(err, nil) // Return any other errors that occur.
 		}
 	}
 	return nil
 }
 
 // GetByEmail retrieves a user from the database based on their email address.
-func (m UserModel) GetByEmail(email string) (*User, error) {
+func (m userModel) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
 SELECT id, created_at, name, email, password_hash, activated, version
 FROM users
 WHERE email = $1`
 
 	var user User
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	// Execute the query and scan the result into a user struct.
-	err := m.DB.QueryRowContext(ctx, query, email).Scan(
+	err := m.ReadDB.QueryRowContext(ctx, query, email).Scan(
 		&user.ID,
 		&user.CreatedAt,
 		&user.Name,
@@ -154,7 +184,7 @@ WHERE email = $1`
 }
 
 // Update modifies an existing user's details in the database, using optimistic concurrency control.
-func (m UserModel) Update(user *User) error {
+func (m userModel) Update(ctx context.Context, user *User) error {
 	query := `
 UPDATE users
 SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
@@ -170,40 +200,40 @@ RETURNING version`
 		user.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	// Execute the query and scan the returned version into the user struct.
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
-			return ErrDuplicateEmail // Return a specific error if the email is already in use.
+		case isViolation(err, pgUniqueViolation, "users_email_key"):
+			return wrapError(err, ErrDuplicateEmail) // Return a specific error if the email is already in use.
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict // Return a specific error if there is an edit conflict.
 		default:
-			return err // Return any other errors that occur.
+			return wrapError(err, nil) // Return any other errors that occur.
 		}
 	}
 	return nil
 }
 
 // GetForToken retrieves a user based on a token's hash, scope, and expiry.
-func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
-	tokenHash := sha256.Sum256([]byte(tokenPlaintext)) // Hash the plaintext token using SHA-256.
+func (m userModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
+	schemes, hashes := tokenHashes(tokenPlaintext) // Hash the plaintext token with each scheme it could have been hashed with.
 
 	query := `
 SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
 FROM users
 INNER JOIN tokens
 ON users.id = tokens.user_id
-WHERE tokens.hash = $1
-AND tokens.scope = $2
-AND tokens.expiry > $3`
+WHERE (tokens.hash_scheme, tokens.hash) IN (SELECT * FROM unnest($1::text[], $2::bytea[]))
+AND tokens.scope = $3
+AND tokens.expiry > $4`
 
-	args := []interface{}{tokenHash[:], tokenScope, time.Now()}
+	args := []interface{}{schemes, hashes, tokenScope, time.Now()}
 	var user User
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	// Execute the query and scan the result into a user struct.
@@ -229,18 +259,18 @@ AND tokens.expiry > $3`
 }
 
 // Get retrieves a user from the database based on their ID.
-func (m UserModel) Get(id int64) (*User, error) {
+func (m userModel) Get(ctx context.Context, id int64) (*User, error) {
 	query := `
 SELECT id, created_at, name, email, password_hash, activated, version
 FROM users
 WHERE id = $1`
 
 	var user User
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	// Execute the query and scan the result into a user struct.
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+	err := m.ReadDB.QueryRowContext(ctx, query, id).Scan(
 		&user.ID,
 		&user.CreatedAt,
 		&user.Name,
@@ -259,3 +289,181 @@ WHERE id = $1`
 	}
 	return &user, nil
 }
+
+// GetAll retrieves users, applying pagination and sorting. Only users whose email contains the email string
+// (ignoring case) are included, or all users if it is empty, and only users with the given activation status
+// if activated is not nil.
+func (m userModel) GetAll(ctx context.Context, email string, activated *bool, filters Filters) ([]*User, Metadata, error) {
+	// Build the ORDER BY clause, which fails if the sort parameter contains a term outside the safelist.
+	orderBy, err := filters.orderBy()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), id, created_at, name, email, activated, version
+FROM users
+WHERE (email ILIKE '%%' || $1 || '%%' OR $1 = '')
+AND ($2::boolean IS NULL OR activated = $2)
+ORDER BY %s
+LIMIT $3 OFFSET $4`, orderBy)
+	args := []interface{}{escapeLike(email), activated, filters.limit(), filters.offset()}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.ReadDB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	users := []*User{}
+	// Loop through the result set and scan each row into a User struct. The password hash is never selected.
+	for rows.Next() {
+		var user User
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Activated,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		users = append(users, &user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	// Calculate pagination metadata for the result set.
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return users, metadata, nil
+}
+
+// UserStats holds aggregate figures about the user accounts in the database.
+type UserStats struct {
+	Total     int `json:"total"`     // Number of user accounts.
+	Activated int `json:"activated"` // Number of user accounts that have been activated.
+}
+
+// GetStats counts the user accounts in the database, and how many of them are activated, in a single query.
+func (m userModel) GetStats(ctx context.Context) (UserStats, error) {
+	query := `
+SELECT count(*), count(*) FILTER (WHERE activated)
+FROM users`
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var stats UserStats
+	err := m.ReadDB.QueryRowContext(ctx, query).Scan(&stats.Total, &stats.Activated)
+	if err != nil {
+		return UserStats{}, err
+	}
+	return stats, nil
+}
+
+// GetTOTP retrieves the encrypted TOTP secret of a user, and whether two-factor authentication has been enabled
+// with it. The secret is nil if the user has never started enrolling.
+func (m userModel) GetTOTP(ctx context.Context, id int64) ([]byte, bool, error) {
+	query := `
+SELECT totp_secret, totp_enabled
+FROM users
+WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var secret []byte
+	var enabled bool
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&secret, &enabled)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, false, ErrRecordNotFound // Return a specific error if no user is found.
+		default:
+			return nil, false, err
+		}
+	}
+	return secret, enabled, nil
+}
+
+// SetTOTP stores the encrypted TOTP secret of a user and whether two-factor authentication is enabled. Passing
+// a nil secret removes it. The user's version is not changed, so this doesn't conflict with other updates.
+func (m userModel) SetTOTP(ctx context.Context, id int64, secret []byte, enabled bool) error {
+	query := `
+UPDATE users
+SET totp_secret = $1, totp_enabled = $2
+WHERE id = $3`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, secret, enabled, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound // Return a specific error if the user did not exist.
+	}
+	return nil
+}
+
+// Delete removes a user and all of their associated data from the database inside a single transaction, so a
+// failure part-way through cannot leave orphaned rows. Reviews, tokens, permissions, and watchlist entries are removed explicitly
+// rather than relying solely on the ON DELETE CASCADE constraints.
+func (m userModel) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound // Return an error if the ID is invalid.
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	// Begin a new transaction.
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // Rollback is a no-op if the transaction has already been committed.
+
+	// Remove the rows that reference the user.
+	queries := []string{
+		`DELETE FROM reviews WHERE user_id = $1`,
+		`DELETE FROM tokens WHERE user_id = $1`,
+		`DELETE FROM users_permissions WHERE user_id = $1`,
+		`DELETE FROM watchlists WHERE user_id = $1`,
+	}
+	for _, query := range queries {
+		_, err = tx.ExecContext(ctx, query, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Remove the user itself.
+	result, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound // Return a specific error if the user did not exist.
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,128 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"github.com/lib/pq"
+	"time"
+)
+
+// WatchlistSortSafelist lists the sort values accepted when listing the movies on a watchlist.
+var WatchlistSortSafelist = SortSafelist("id", "title", "year", "release_date", "runtime", "added_at")
+
+// WatchlistModel is the interface to users' watchlists in the database.
+type WatchlistModel interface {
+	Add(ctx context.Context, userID, movieID int64) error
+	Remove(ctx context.Context, userID, movieID int64) error
+	GetAllForUser(ctx context.Context, userID int64, filters Filters) ([]*Movie, Metadata, error)
+}
+
+// watchlistModel is the PostgreSQL implementation of WatchlistModel.
+type watchlistModel struct {
+	DB     *DB // Database connection pool, used for writes.
+	ReadDB *DB // Connection pool used for reads, which is the read replica if one is configured.
+}
+
+// Add saves a movie to a user's watchlist. Adding a movie that is already on the watchlist is not an error.
+func (m watchlistModel) Add(ctx context.Context, userID, movieID int64) error {
+	query := `
+INSERT INTO watchlists (user_id, movie_id)
+VALUES ($1, $2)
+ON CONFLICT (user_id, movie_id) DO NOTHING`
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, movieID)
+	return err
+}
+
+// Remove deletes a movie from a user's watchlist, returning ErrRecordNotFound if it was not on the watchlist.
+func (m watchlistModel) Remove(ctx context.Context, userID, movieID int64) error {
+	query := `
+DELETE FROM watchlists
+WHERE user_id = $1 AND movie_id = $2`
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	// Execute the delete query.
+	result, err := m.DB.ExecContext(ctx, query, userID, movieID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound // Return a custom error if no rows are affected.
+	}
+	return nil
+}
+
+// GetAllForUser retrieves the movies on a user's watchlist, applying pagination and sorting. As well as the
+// movie columns, the results can be sorted by added_at, the time each movie was added to the watchlist.
+func (m watchlistModel) GetAllForUser(ctx context.Context, userID int64, filters Filters) ([]*Movie, Metadata, error) {
+	filters.expressions = movieSortExpressions
+
+	// Build the ORDER BY clause, which fails if the sort parameter contains a term outside the safelist.
+	orderBy, err := filters.orderBy()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), id, uuid, created_at, title, year, release_date, runtime, genres, tags,
+(SELECT ROUND(AVG(rating), 1) FROM reviews WHERE reviews.movie_id = movies.id), poster_url, version
+FROM movies
+INNER JOIN watchlists ON watchlists.movie_id = movies.id
+WHERE watchlists.user_id = $1
+ORDER BY %s
+LIMIT $2 OFFSET $3`, orderBy)
+
+	// Derive a context with a 3-second timeout from the caller's context for executing the query.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.ReadDB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*Movie{}
+	// Loop through the result set and scan each row into a Movie struct.
+	for rows.Next() {
+		var movie Movie
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.UUID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.ReleaseDate,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			pq.Array(&movie.Tags),
+			&movie.AverageRating,
+			&movie.PosterURL,
+			&movie.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		movies = append(movies, &movie)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	// Calculate pagination metadata for the result set.
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return movies, metadata, nil
+}
@@ -2,9 +2,13 @@ package jsonlog
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -15,9 +19,10 @@ type Level int8
 // Log level constants to define different levels of logging severity.
 const (
 	LevelInfo  Level = iota // Info level logs, typically used for general informational messages. Value is 0.
-	LevelError              // Error level logs, used for non-critical errors. Value is 1.
-	LevelFatal              // Fatal level logs, used for critical errors after which the application cannot continue. Value is 2.
-	LevelOff                // No logging. Value is 3.
+	LevelWarn               // Warn level logs, used for unexpected conditions that are not errors, such as slow queries. Value is 1.
+	LevelError              // Error level logs, used for non-critical errors. Value is 2.
+	LevelFatal              // Fatal level logs, used for critical errors after which the application cannot continue. Value is 3.
+	LevelOff                // No logging. Value is 4.
 )
 
 // String converts the log level to its string representation.
@@ -25,6 +30,8 @@ func (l Level) String() string {
 	switch l {
 	case LevelInfo:
 		return "INFO"
+	case LevelWarn:
+		return "WARN"
 	case LevelError:
 		return "ERROR"
 	case LevelFatal:
@@ -34,18 +41,58 @@ func (l Level) String() string {
 	}
 }
 
+// Format is a custom type for defining the output format of log entries.
+type Format int8
+
+// Format constants to define the supported output formats.
+const (
+	FormatJSON Format = iota // One JSON object per line. Value is 0.
+	FormatText               // logfmt-style key=value pairs, easier to read during local development. Value is 1.
+)
+
+// String converts the format to its string representation.
+func (f Format) String() string {
+	switch f {
+	case FormatJSON:
+		return "json"
+	case FormatText:
+		return "text"
+	default:
+		return ""
+	}
+}
+
+// ParseFormat converts a string such as "json" or "text" to a Format, returning an error if it is not recognized.
+func ParseFormat(s string) (Format, error) {
+	switch s {
+	case "json":
+		return FormatJSON, nil
+	case "text":
+		return FormatText, nil
+	default:
+		return FormatJSON, fmt.Errorf("unknown log format %q", s)
+	}
+}
+
 // Logger struct defines a custom logger that writes logs to an output and filters messages below a certain severity level.
 type Logger struct {
 	out      io.Writer  // Destination for the log messages, such as os.Stdout or a file.
 	minLevel Level      // Minimum log level to output messages for.
+	format   Format     // Output format of the log entries.
 	mu       sync.Mutex // Mutex to synchronize log writes and prevent race conditions.
 }
 
-// New creates a new Logger instance.
+// New creates a new Logger instance that writes JSON log entries.
 func New(out io.Writer, minLevel Level) *Logger {
+	return NewWithFormat(out, minLevel, FormatJSON)
+}
+
+// NewWithFormat creates a new Logger instance that writes log entries in the given format.
+func NewWithFormat(out io.Writer, minLevel Level, format Format) *Logger {
 	return &Logger{
 		out:      out,
 		minLevel: minLevel,
+		format:   format,
 	}
 }
 
@@ -54,6 +101,11 @@ func (l *Logger) PrintInfo(message string, properties map[string]string) {
 	l.print(LevelInfo, message, properties)
 }
 
+// PrintWarn logs a message at the WARN level.
+func (l *Logger) PrintWarn(message string, properties map[string]string) {
+	l.print(LevelWarn, message, properties)
+}
+
 // PrintError logs an error message at the ERROR level.
 func (l *Logger) PrintError(err error, properties map[string]string) {
 	l.print(LevelError, err.Error(), properties)
@@ -91,11 +143,19 @@ func (l *Logger) print(level Level, message string, properties map[string]string
 		aux.Trace = string(debug.Stack())
 	}
 
-	// Marshal the log entry to JSON.
-	line, err := json.Marshal(aux)
-	if err != nil {
-		// If JSON marshaling fails, log the error in plain text.
-		line = []byte(LevelError.String() + ": unable to marshal log message: " + err.Error())
+	var line []byte
+	switch l.format {
+	case FormatText:
+		// Render the log entry as key=value pairs.
+		line = formatText(aux.Time, aux.Level, aux.Message, aux.Properties, aux.Trace)
+	default:
+		// Marshal the log entry to JSON.
+		var err error
+		line, err = json.Marshal(aux)
+		if err != nil {
+			// If JSON marshaling fails, log the error in plain text.
+			line = []byte(LevelError.String() + ": unable to marshal log message: " + err.Error())
+		}
 	}
 
 	// Ensure that log writes are atomic by locking the mutex.
@@ -110,3 +170,37 @@ func (l *Logger) print(level Level, message string, properties map[string]string
 func (l *Logger) Write(message []byte) (n int, err error) {
 	return l.print(LevelError, string(message), nil)
 }
+
+// formatText renders a log entry in logfmt style, e.g. `time=... level=INFO msg="starting server" addr=:4000`.
+// Properties are written in sorted key order so that the output is stable.
+func formatText(time, level, message string, properties map[string]string, trace string) []byte {
+	var b strings.Builder
+
+	b.WriteString("time=" + quoteText(time))
+	b.WriteString(" level=" + quoteText(level))
+	b.WriteString(" msg=" + quoteText(message))
+
+	keys := make([]string, 0, len(properties))
+	for key := range properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		b.WriteString(" " + key + "=" + quoteText(properties[key]))
+	}
+
+	if trace != "" {
+		b.WriteString(" trace=" + quoteText(trace))
+	}
+
+	return []byte(b.String())
+}
+
+// quoteText quotes a logfmt value if it is empty or contains spaces, quotes, equals signs, or control characters.
+func quoteText(value string) string {
+	if value == "" || strings.ContainsAny(value, " \t\r\n\"=") {
+		return strconv.Quote(value)
+	}
+	return value
+}
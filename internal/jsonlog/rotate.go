@@ -0,0 +1,129 @@
+package jsonlog
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// RotatingFile is an io.WriteCloser that writes to a log file, moving it aside and starting a new one when it
+// grows past a maximum size or, optionally, when the day changes. Rotation only happens between calls to Write,
+// so as long as each log entry is written with a single call, as the Logger does, no entry is split across files.
+type RotatingFile struct {
+	path    string     // Path of the current log file.
+	maxSize int64      // Size in bytes after which the file is rotated, or 0 for no size limit.
+	daily   bool       // Rotate the file when the day (in UTC) changes.
+	mu      sync.Mutex // Mutex to protect the fields below.
+	file    *os.File   // The open log file.
+	size    int64      // Number of bytes in the open log file.
+	day     string     // Day (in UTC) that the open log file was started, in YYYY-MM-DD form.
+}
+
+// NewRotatingFile opens the log file at path for appending, creating it if necessary. When the file would grow
+// past maxSize bytes, or the day changes and daily is true, it is renamed with a timestamp suffix and a new file
+// is started at path.
+func NewRotatingFile(path string, maxSize int64, daily bool) (*RotatingFile, error) {
+	f := &RotatingFile{
+		path:    path,
+		maxSize: maxSize,
+		daily:   daily,
+	}
+
+	err := f.open()
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens the log file at f.path, recording its current size and the day it was last written to.
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	f.day = info.ModTime().UTC().Format(time.DateOnly)
+	if f.size == 0 {
+		f.day = time.Now().UTC().Format(time.DateOnly)
+	}
+	return nil
+}
+
+// Write writes p to the log file, first rotating the file if p would take it past the maximum size or if the
+// day has changed. An entry larger than the maximum size is written to a file of its own.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Try to reopen the log file if an earlier rotation left it closed.
+	if f.file == nil {
+		err := f.open()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	tooBig := f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize
+	newDay := f.daily && f.day != time.Now().UTC().Format(time.DateOnly)
+	if tooBig || newDay {
+		// If the rotation fails but the old file could be reopened, keep writing to it rather than losing the entry.
+		err := f.rotate()
+		if err != nil && f.file == nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate closes the current log file, renames it with a timestamp suffix, and opens a new file in its place. If
+// the file can't be renamed, it is reopened and the error returned. f.file is left nil if no file could be opened.
+func (f *RotatingFile) rotate() error {
+	err := f.file.Close()
+	f.file = nil
+	if err != nil {
+		return err
+	}
+
+	renameErr := os.Rename(f.path, f.backupName())
+	err = f.open()
+	if err != nil {
+		return err
+	}
+	return renameErr
+}
+
+// backupName returns an unused name for a rotated log file, made by appending the current time to the path.
+func (f *RotatingFile) backupName() string {
+	name := f.path + "." + time.Now().UTC().Format("2006-01-02T15-04-05")
+	for i := 1; ; i++ {
+		_, err := os.Stat(name)
+		if os.IsNotExist(err) {
+			return name
+		}
+		name = fmt.Sprintf("%s.%s.%d", f.path, time.Now().UTC().Format("2006-01-02T15-04-05"), i)
+	}
+}
+
+// Close closes the current log file.
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Close()
+}
@@ -2,9 +2,14 @@ package mailer
 
 import (
 	"bytes"
+	"cinevault.interimme.net/internal/jsonlog"
 	"embed"
+	"errors"
 	"github.com/go-mail/mail/v2"
 	"html/template"
+	"io/fs"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -15,15 +20,46 @@ import (
 //go:embed "templates"
 var templateFS embed.FS
 
+// defaultTemplates holds the embedded templates with the "templates" directory stripped from their names, so that
+// they are named like the templates in an override filesystem.
+var defaultTemplates, _ = fs.Sub(templateFS, "templates") // Sub only fails for invalid directory names.
+
+// ErrNoRecipients is returned when a message has no To, Cc, or Bcc addresses.
+var ErrNoRecipients = errors.New("mailer: message has no recipients")
+
+// EmailParams describes an email to send with SendMessage: its recipients, the template file to render, and the
+// dynamic data passed to the template. At least one recipient must be given, in any of To, Cc, or Bcc. Bcc
+// addresses receive the email without being listed in its headers.
+type EmailParams struct {
+	To       []string    // Addresses for the To header.
+	Cc       []string    // Addresses for the Cc header.
+	Bcc      []string    // Addresses that receive a blind copy.
+	Template string      // Filename of the email template.
+	Data     interface{} // Dynamic content passed to the template for rendering.
+}
+
 // Mailer struct contains a mail.Dialer instance to connect to an SMTP server for sending emails,
 // and a sender string to specify the "From" email address in the format "Name <email@example.com>".
+// Failed sends are retried with exponential backoff: the delay before each retry is RetryDelay,
+// doubled after every attempt. A Mailer created without an SMTP host is log-only: it renders emails as usual but
+// writes them to Logger instead of sending them, so that tokens can still be read from the logs in environments
+// with no mail server, such as CI or a demo.
 type Mailer struct {
-	dialer *mail.Dialer // SMTP dialer for sending emails.
-	sender string       // Email address of the sender.
+	dialer      *mail.Dialer    // SMTP dialer for sending emails, or nil in log-only mode.
+	sender      string          // Email address of the sender.
+	MaxAttempts int             // Maximum number of times to try sending an email; values below 1 mean a single attempt.
+	RetryDelay  time.Duration   // Delay before the first retry, doubled for each retry after it.
+	Logger      *jsonlog.Logger // Logger that failed attempts are reported to, or nil to not report them.
+	Templates   fs.FS           // Templates that override the embedded ones with the same filename, or nil to use only the embedded ones.
 }
 
-// New initializes and returns a new Mailer instance with the given SMTP server settings.
+// New initializes and returns a new Mailer instance with the given SMTP server settings. If host is empty, the
+// Mailer is log-only and the other settings are ignored, except for the sender.
 func New(host string, port int, username, password, sender string) Mailer {
+	if host == "" {
+		return Mailer{sender: sender, MaxAttempts: 1}
+	}
+
 	// Create a new mail.Dialer instance with the specified SMTP server settings (host, port, username, password).
 	// The dialer is configured with a timeout of 5 seconds for sending emails.
 	dialer := mail.NewDialer(host, port, username, password)
@@ -31,17 +67,86 @@ func New(host string, port int, username, password, sender string) Mailer {
 
 	// Return a new Mailer instance containing the configured dialer and sender information.
 	return Mailer{
-		dialer: dialer,
-		sender: sender,
+		dialer:      dialer,
+		sender:      sender,
+		MaxAttempts: 3,
+		RetryDelay:  500 * time.Millisecond,
+	}
+}
+
+// templateFS returns the file system to load the named template from: the override templates if they contain it,
+// and the embedded defaults otherwise.
+func (m Mailer) templateFS(name string) fs.FS {
+	if m.Templates != nil {
+		if _, err := fs.Stat(m.Templates, name); err == nil {
+			return m.Templates
+		}
+	}
+	return defaultTemplates
+}
+
+// LogOnly reports whether the Mailer writes emails to its logger instead of sending them, because it was created
+// without an SMTP host.
+func (m Mailer) LogOnly() bool {
+	return m.dialer == nil
+}
+
+// ErrPingTimeout is returned by Ping when the SMTP server doesn't respond in time.
+var ErrPingTimeout = errors.New("mailer: timed out connecting to the SMTP server")
+
+// Ping checks that the SMTP server can be reached by connecting to it, negotiating TLS and authenticating as a send
+// would, then disconnecting without sending anything. It gives up with ErrPingTimeout after timeout, even if the
+// server accepts the connection but never greets the client. In log-only mode, there is no server and it returns
+// nil straight away.
+func (m Mailer) Ping(timeout time.Duration) error {
+	if m.LogOnly() {
+		return nil
+	}
+
+	// Dial with a copy of the dialer, so that the timeout and the authentication mechanism chosen while dialing
+	// don't affect the sends.
+	dialer := *m.dialer
+	dialer.Timeout = timeout
+
+	// Dial in the background, as the dialer's timeout doesn't cover waiting for the server's greeting. The channel
+	// is buffered so that a dial finishing after the timeout doesn't block forever.
+	done := make(chan error, 1)
+	go func() {
+		conn, err := dialer.Dial()
+		if err == nil {
+			err = conn.Close()
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return ErrPingTimeout
 	}
 }
 
 // Send composes and sends an email using the specified recipient, template file, and dynamic data.
 // `recipient` is the email address to send to, `templateFile` is the filename of the email template,
-// and `data` is dynamic content passed to the template for rendering.
+// and `data` is dynamic content passed to the template for rendering. It is shorthand for SendMessage with a single
+// To address.
 func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
-	// Parse the email template from the embedded file system using the specified template file.
-	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	return m.SendMessage(EmailParams{To: []string{recipient}, Template: templateFile, Data: data})
+}
+
+// SendMessage composes and sends an email to the recipients in params, using its template file and dynamic data.
+// It returns ErrNoRecipients, without connecting to the SMTP server, if params has no recipients.
+func (m Mailer) SendMessage(params EmailParams) error {
+	// Make sure there is someone to send the email to before doing any work.
+	if len(params.To)+len(params.Cc)+len(params.Bcc) == 0 {
+		return ErrNoRecipients
+	}
+	templateFile, data := params.Template, params.Data
+
+	// Parse the email template, from the override file system if it has one with this name, and from the embedded
+	// file system otherwise.
+	tmpl, err := template.New("email").ParseFS(m.templateFS(templateFile), templateFile)
 	if err != nil {
 		return err // Return an error if parsing the template fails.
 	}
@@ -60,30 +165,78 @@ func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
 		return err // Return an error if executing the plain body template fails.
 	}
 
-	// Execute the "htmlBody" template and store the result in a bytes.Buffer for the HTML email body.
-	htmlBody := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
-	if err != nil {
-		return err // Return an error if executing the HTML body template fails.
+	// Execute the "htmlBody" template, if the template file defines one, and store the result in a bytes.Buffer
+	// for the HTML email body. Templates without an HTML body are sent as plain text only.
+	var htmlBody *bytes.Buffer
+	if tmpl.Lookup("htmlBody") != nil {
+		htmlBody = new(bytes.Buffer)
+		err = tmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+		if err != nil {
+			return err // Return an error if executing the HTML body template fails.
+		}
 	}
 
-	// Create a new mail.Message instance and set the recipient, sender, and subject headers.
-	// Set the plain-text body of the email using SetBody() and the HTML body using AddAlternative().
+	// Create a new mail.Message instance and set the recipient, sender, and subject headers, leaving out any
+	// empty recipient lists.
+	// Set the plain-text body of the email using SetBody() and the HTML body, if any, using AddAlternative().
 	// Note: AddAlternative() should always be called after SetBody() to properly set both content types.
 	msg := mail.NewMessage()
-	msg.SetHeader("To", recipient)
+	for field, addresses := range map[string][]string{"To": params.To, "Cc": params.Cc, "Bcc": params.Bcc} {
+		if len(addresses) > 0 {
+			msg.SetHeader(field, addresses...)
+		}
+	}
 	msg.SetHeader("From", m.sender)
 	msg.SetHeader("Subject", subject.String())
 	msg.SetBody("text/plain", plainBody.String())
-	msg.AddAlternative("text/html", htmlBody.String())
+	if htmlBody != nil {
+		msg.AddAlternative("text/html", htmlBody.String())
+	}
+
+	// In log-only mode, write the rendered email to the logger instead of sending it. The plain-text body is
+	// logged rather than the HTML one, as it holds the same content in a more readable form.
+	if m.LogOnly() {
+		if m.Logger != nil {
+			properties := map[string]string{
+				"template": templateFile,
+				"from":     m.sender,
+				"subject":  subject.String(),
+				"body":     plainBody.String(),
+			}
+			for field, addresses := range map[string][]string{"to": params.To, "cc": params.Cc, "bcc": params.Bcc} {
+				if len(addresses) > 0 {
+					properties[field] = strings.Join(addresses, ", ")
+				}
+			}
+			m.Logger.PrintInfo("email not sent, mailer is log-only", properties)
+		}
+		return nil
+	}
 
 	// Send the email by calling DialAndSend() on the dialer with the message.
 	// This method establishes a connection to the SMTP server, sends the email, and then closes the connection.
-	// It returns an error if sending fails, such as a timeout or connection issue.
-	err = m.dialer.DialAndSend(msg)
-	if err != nil {
-		return err // Return an error if sending the email fails.
-	}
+	// It returns an error if sending fails, such as a timeout or connection issue, in which case the send is
+	// retried after a delay that doubles with each attempt.
+	delay := m.RetryDelay
+	for attempt := 1; ; attempt++ {
+		err = m.dialer.DialAndSend(msg)
+		if err == nil {
+			return nil // Return nil if the email is sent successfully.
+		}
+		if attempt >= m.MaxAttempts {
+			return err // Return the last error once all attempts have failed.
+		}
 
-	return nil // Return nil if the email is sent successfully.
+		// Report the failed attempt before waiting to try again.
+		if m.Logger != nil {
+			m.Logger.PrintWarn("failed to send email, retrying", map[string]string{
+				"template": templateFile,
+				"attempt":  strconv.Itoa(attempt),
+				"error":    err.Error(),
+			})
+		}
+
+		time.Sleep(delay)
+		delay *= 2
+	}
 }
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrInvalidName is returned when a file name contains path separators or is otherwise unsafe to store.
+var ErrInvalidName = errors.New("invalid file name")
+
+// Storage is the interface implemented by the backends that uploaded files, such as movie posters, are saved to.
+// Save stores the contents of r under the given name and returns the URL from which the file can be retrieved.
+// Delete removes the file that Save returned the URL for; deleting a file that does not exist is not an error.
+type Storage interface {
+	Save(ctx context.Context, name string, r io.Reader) (string, error)
+	Delete(ctx context.Context, fileURL string) error
+}
+
+// Local is a Storage implementation that writes files to a directory on the local filesystem. The files are
+// expected to be served by the application under baseURL.
+type Local struct {
+	dir     string // Directory that files are written to.
+	baseURL string // URL prefix under which the files in dir are served.
+}
+
+// NewLocal creates the directory if necessary and returns a Local storage backend that writes to it.
+func NewLocal(dir, baseURL string) (*Local, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Local{
+		dir:     dir,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+	}, nil
+}
+
+// Dir returns the directory that the backend writes files to.
+func (l *Local) Dir() string {
+	return l.dir
+}
+
+// Save writes the contents of r to a file with the given name, replacing any existing file. The data is written
+// to a temporary file first and then renamed, so readers never see a partially-written file.
+func (l *Local) Save(ctx context.Context, name string, r io.Reader) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", ErrInvalidName
+	}
+
+	tmp, err := os.CreateTemp(l.dir, ".upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name()) // Clean up the temporary file if it was not renamed.
+
+	_, err = io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return "", err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return "", err
+	}
+
+	// Give up before making the file visible if the request has been cancelled in the meantime.
+	if err = ctx.Err(); err != nil {
+		return "", err
+	}
+
+	err = os.Rename(tmp.Name(), filepath.Join(l.dir, name))
+	if err != nil {
+		return "", err
+	}
+
+	return l.baseURL + "/" + url.PathEscape(name), nil
+}
+
+// Delete removes the file served at fileURL. URLs outside of baseURL, such as those of posters recorded before
+// this backend was configured, are ignored, as are files that have already been removed.
+func (l *Local) Delete(ctx context.Context, fileURL string) error {
+	escaped, ok := strings.CutPrefix(fileURL, l.baseURL+"/")
+	if !ok {
+		return nil
+	}
+	name, err := url.PathUnescape(escaped)
+	if err != nil || name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return ErrInvalidName
+	}
+
+	err = os.Remove(filepath.Join(l.dir, name))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
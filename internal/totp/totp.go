@@ -0,0 +1,149 @@
+package totp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Parameters of the generated codes. These are the defaults of RFC 6238, which authenticator apps assume when
+// they are not given in the otpauth URI.
+const (
+	period = 30 * time.Second // Length of the time step each code is valid for.
+	digits = 6                // Number of digits in each code.
+	skew   = 1                // Number of time steps either side of the current one that are also accepted.
+)
+
+// ErrInvalidCiphertext is returned when an encrypted secret cannot be decrypted, for example because it was
+// encrypted with a different key.
+var ErrInvalidCiphertext = errors.New("invalid ciphertext")
+
+// encoding is the base32 encoding used for secrets, which is what authenticator apps expect.
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret generates a new random 160-bit secret, encoded in base32.
+func NewSecret() (string, error) {
+	key := make([]byte, 20)
+	_, err := rand.Read(key)
+	if err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(key), nil
+}
+
+// URI returns an otpauth:// URI for the secret, which authenticator apps can import, typically from a QR code.
+// The issuer and account name are shown in the app to identify the code.
+func URI(issuer, account, secret string) string {
+	qs := url.Values{}
+	qs.Set("secret", secret)
+	qs.Set("issuer", issuer)
+	qs.Set("algorithm", "SHA1")
+	qs.Set("digits", fmt.Sprint(digits))
+	qs.Set("period", fmt.Sprint(int(period.Seconds())))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: qs.Encode(),
+	}
+	return u.String()
+}
+
+// Code returns the code for the secret at time t, as defined by RFC 6238 using HMAC-SHA1.
+func Code(secret string, t time.Time) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	return code(key, uint64(t.Unix())/uint64(period.Seconds())), nil
+}
+
+// code computes the HOTP value (RFC 4226) of the key for the given counter.
+func code(key []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// Dynamically truncate the HMAC to a 31-bit integer, and keep its last few decimal digits.
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
+
+// Validate reports whether code is valid for the secret at time t. Codes from the time steps either side of the
+// current one are accepted too, to allow for clock drift and for the time it takes to type the code in.
+func Validate(code, secret string, t time.Time) bool {
+	for i := -skew; i <= skew; i++ {
+		expected, err := Code(secret, t.Add(time.Duration(i)*period))
+		if err != nil {
+			return false
+		}
+		if subtle.ConstantTimeCompare([]byte(code), []byte(expected)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// Encrypt encrypts a secret with AES-GCM for storing at rest, using a 16, 24, or 32-byte key. The random nonce
+// is prepended to the returned ciphertext.
+func Encrypt(key []byte, secret string) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, []byte(secret), nil), nil
+}
+
+// Decrypt decrypts a secret encrypted by Encrypt with the same key.
+func Decrypt(key, ciphertext []byte) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return "", ErrInvalidCiphertext
+	}
+	nonce, ciphertext := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", ErrInvalidCiphertext
+	}
+	return string(plaintext), nil
+}
+
+// newGCM returns an AES-GCM cipher for the key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
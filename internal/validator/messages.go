@@ -0,0 +1,128 @@
+package validator
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultLanguage is the language validation messages are written in, used when a client accepts none of the
+// languages in the catalog.
+const DefaultLanguage = "en"
+
+// catalog maps language tags to translations of the validation messages, keyed by the ID of each message, which is
+// its English format string. English needs no entry, since the IDs are the English messages. A message missing
+// from a language's translations falls back to English.
+var catalog = map[string]map[string]string{
+	"es": {
+		"a genre with this name already exists":                                      "ya existe un género con este nombre",
+		"a movie with the same title and year as one of these movies already exists": "ya existe una película con el mismo título y año que una de estas películas",
+		"a movie with this title and year already exists":                            "ya existe una película con este título y año",
+		"a user with this email address already exists":                              "ya existe un usuario con esta dirección de correo electrónico",
+		"invalid checks value":                                                       "valor de checks no válido",
+		"invalid date format":                                                        "formato de fecha no válido",
+		"invalid cursor value":                                                       "valor de cursor no válido",
+		"invalid or expired activation token":                                        "token de activación no válido o caducado",
+		"invalid or expired email change token":                                      "token de cambio de correo electrónico no válido o caducado",
+		"invalid or expired login token":                                             "token de inicio de sesión no válido o caducado",
+		"invalid or expired password reset token":                                    "token de restablecimiento de contraseña no válido o caducado",
+		"invalid runtime format":                                                     "formato de duración no válido",
+		"invalid runtime_format value":                                               "valor de runtime_format no válido",
+		"invalid sort value":                                                         "valor de ordenación no válido",
+		"invalid title_match value":                                                  "valor de title_match no válido",
+		"invalid two-factor authentication code":                                     "código de autenticación de dos factores no válido",
+		"is not a known field":                                                       "no es un campo conocido",
+		"is repeated in the batch":                                                   "se repite en el lote",
+		"must be 26 bytes long":                                                      "debe tener 26 bytes",
+		"must be 52 bytes long":                                                      "debe tener 52 bytes",
+		"must be a JPEG or PNG image":                                                "debe ser una imagen JPEG o PNG",
+		"must be a boolean":                                                          "debe ser un booleano",
+		"must be a maximum of %d":                                                    "debe ser como máximo %d",
+		"must be a maximum of 10 million":                                            "debe ser como máximo 10 millones",
+		"must be a maximum of 100":                                                   "debe ser como máximo 100",
+		"must be a number":                                                           "debe ser un número",
+		"must be a positive integer":                                                 "debe ser un entero positivo",
+		"must be a string":                                                           "debe ser una cadena",
+		"must be a valid email address":                                              "debe ser una dirección de correo electrónico válida",
+		"must be the year of release_date":                                           "debe ser el año de release_date",
+		"must be an array of integers":                                               "debe ser una lista de enteros",
+		"must be an array of strings":                                                "debe ser una lista de cadenas",
+		"must be an integer":                                                         "debe ser un entero",
+		"must be an object":                                                          "debe ser un objeto",
+		"must be at least %d bytes long":                                             "debe tener al menos %d bytes",
+		"must be at least 8 bytes long":                                              "debe tener al menos 8 bytes",
+		"must be between %d and %d":                                                  "debe estar entre %d y %d",
+		"must be between 1 and 10":                                                   "debe estar entre 1 y 10",
+		"must be different from your current email address":                          "debe ser distinta de tu dirección de correo electrónico actual",
+		"must be greater than 1888":                                                  "debe ser mayor que 1888",
+		"must be greater than zero":                                                  "debe ser mayor que cero",
+		"must be movie":                                                              "debe ser movie",
+		"must be provided":                                                           "es obligatorio",
+		"must contain at least 1 genre":                                              "debe contener al menos 1 género",
+		"must contain at least 1 id":                                                 "debe contener al menos 1 id",
+		"must contain at least 1 permission":                                         "debe contener al menos 1 permiso",
+		"must contain at least 1 scope":                                              "debe contener al menos 1 ámbito",
+		"must contain at least one digit":                                            "debe contener al menos un dígito",
+		"must contain at least one symbol":                                           "debe contener al menos un símbolo",
+		"must contain both upper and lower case letters":                             "debe contener letras mayúsculas y minúsculas",
+		"must not be a commonly used password":                                       "no debe ser una contraseña de uso común",
+		"must not be greater than year_max":                                          "no debe ser mayor que year_max",
+		"must not be in the future":                                                  "no debe estar en el futuro",
+		"must not be larger than %d bytes":                                           "no debe ocupar más de %d bytes",
+		"must not be more than %d characters long":                                   "no debe tener más de %d caracteres",
+		"must not be more than 100 bytes long":                                       "no debe tener más de 100 bytes",
+		"must not be more than 10000 bytes long":                                     "no debe tener más de 10000 bytes",
+		"must not be more than 500 bytes long":                                       "no debe tener más de 500 bytes",
+		"must not be more than 72 bytes long":                                        "no debe tener más de 72 bytes",
+		"must not be negative":                                                       "no debe ser negativo",
+		"must not contain empty tags":                                                "no debe contener etiquetas vacías",
+		"must not contain more than %d tags":                                         "no debe contener más de %d etiquetas",
+		"must not contain tags longer than %d characters":                            "no debe contener etiquetas de más de %d caracteres",
+		"must not contain duplicate fields":                                          "no debe contener campos duplicados",
+		"must not contain duplicate values":                                          "no debe contener valores duplicados",
+		"must not contain more than %d ids":                                          "no debe contener más de %d ids",
+		"must not contain more than 5 genres":                                        "no debe contener más de 5 géneros",
+		"must only contain known genres":                                             "solo debe contener géneros conocidos",
+		"must only contain known permission codes":                                   "solo debe contener códigos de permiso conocidos",
+		"must refer to an existing actor":                                            "debe hacer referencia a un actor existente",
+		"no matching email address found":                                            "no se encontró ninguna dirección de correo electrónico coincidente",
+		"two-factor authentication has not been set up":                              "la autenticación de dos factores no se ha configurado",
+		"two-factor authentication is already enabled":                               "la autenticación de dos factores ya está activada",
+		"two-factor authentication is not enabled":                                   "la autenticación de dos factores no está activada",
+		"user account must be activated":                                             "la cuenta de usuario debe estar activada",
+		"user has already been activated":                                            "el usuario ya ha sido activado",
+		"you have already reviewed this movie":                                       "ya has reseñado esta película",
+	},
+}
+
+// Supported reports whether validation messages can be given in the language with the given tag, such as "es".
+func Supported(lang string) bool {
+	_, ok := catalog[lang]
+	return ok || lang == DefaultLanguage
+}
+
+// Translate returns the message formatted in the given language, falling back to English if the language or the
+// message is not in the catalog.
+func (m Message) Translate(lang string) string {
+	format, ok := catalog[lang][m.ID]
+	if !ok {
+		return m.English()
+	}
+	if len(m.Args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, m.Args...)
+}
+
+// Localized returns the validator's errors translated into the given language, as a map of field names to
+// messages. Errors made up of several messages have them joined with semicolons, as in Errors.
+func (v *Validator) Localized(lang string) map[string]string {
+	errors := make(map[string]string, len(v.Errors))
+	for key, messages := range v.Messages {
+		translated := make([]string, len(messages))
+		for i, message := range messages {
+			translated[i] = message.Translate(lang)
+		}
+		errors[key] = strings.Join(translated, "; ")
+	}
+	return errors
+}
@@ -1,23 +1,56 @@
 package validator
 
 import (
+	"fmt"
 	"regexp"
+	"strings"
 )
 
 // EmailRX is a regular expression pattern to validate the format of email addresses.
 // It checks that the email conforms to the standard format with allowed characters and structure.
+// UUIDRX matches UUIDs in their canonical form of hyphenated hexadecimal groups, in either case.
 var (
 	EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+	UUIDRX  = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+)
+
+// Machine-readable codes describing why a field failed validation, for clients that localize error messages.
+const (
+	CodeRequired      = "required"       // The field is missing or empty.
+	CodeTooShort      = "too_short"      // The field is shorter than the minimum length.
+	CodeTooLong       = "too_long"       // The field is longer than the maximum length.
+	CodeTooFew        = "too_few"        // The field contains fewer than the minimum number of values.
+	CodeTooMany       = "too_many"       // The field contains more than the maximum number of values.
+	CodeOutOfRange    = "out_of_range"   // The field is outside the allowed range of values.
+	CodeInvalidFormat = "invalid_format" // The field is not in the expected format.
+	CodeDuplicate     = "duplicate"      // The field contains duplicate values, or a value that is already in use.
 )
 
 // Validator struct holds a map of validation errors, where the key is the field name and the value is the error message.
 type Validator struct {
-	Errors map[string]string // Maps field names to their corresponding error messages.
+	Errors   map[string]string    // Maps field names to their corresponding error messages, in English.
+	Codes    map[string]string    // Maps field names to the machine-readable code of their error, if it has one.
+	Messages map[string][]Message // Maps field names to the untranslated messages their error is made up of.
+}
+
+// Message is a validation error message before it is translated. The ID is the English format string of the
+// message, which is also its key in the message catalog, and Args are the values it is formatted with.
+type Message struct {
+	ID   string        // Format string of the English message, e.g. "must be a maximum of %d".
+	Args []interface{} // Arguments for the format string, if it has any.
+}
+
+// English returns the message formatted in English.
+func (m Message) English() string {
+	if len(m.Args) == 0 {
+		return m.ID
+	}
+	return fmt.Sprintf(m.ID, m.Args...)
 }
 
-// New initializes a new Validator instance with an empty map for errors.
+// New initializes a new Validator instance with empty maps for errors and codes.
 func New() *Validator {
-	return &Validator{Errors: make(map[string]string)}
+	return &Validator{Errors: make(map[string]string), Codes: make(map[string]string), Messages: make(map[string][]Message)}
 }
 
 // Valid returns true if the Validator contains no errors.
@@ -27,8 +60,36 @@ func (v *Validator) Valid() bool {
 
 // AddError adds an error message for a given field to the Validator, if an error does not already exist for that field.
 func (v *Validator) AddError(key, message string) {
-	if _, exists := v.Errors[key]; !exists {
-		v.Errors[key] = message // Add the error message to the map if it doesn't already exist.
+	v.AddCodedMessages(key, "", Message{ID: message})
+}
+
+// AddErrorf adds an error message built from a format string and arguments for a given field to the Validator, if
+// an error does not already exist for that field. The format string identifies the message for translation.
+func (v *Validator) AddErrorf(key, format string, args ...interface{}) {
+	v.AddCodedMessages(key, "", Message{ID: format, Args: args})
+}
+
+// AddCodedError adds an error message and code for a given field to the Validator, if an error does not already
+// exist for that field.
+func (v *Validator) AddCodedError(key, code, message string) {
+	v.AddCodedMessages(key, code, Message{ID: message})
+}
+
+// AddCodedMessages adds an error for a given field made up of several messages, which are joined with semicolons,
+// along with its code, if an error does not already exist for that field. An empty code means the error has none.
+func (v *Validator) AddCodedMessages(key, code string, messages ...Message) {
+	if _, exists := v.Errors[key]; exists {
+		return
+	}
+
+	english := make([]string, len(messages))
+	for i, message := range messages {
+		english[i] = message.English()
+	}
+	v.Errors[key] = strings.Join(english, "; ") // Add the error message and code to the maps if it doesn't already exist.
+	v.Messages[key] = messages
+	if code != "" {
+		v.Codes[key] = code
 	}
 }
 
@@ -39,6 +100,29 @@ func (v *Validator) Check(ok bool, key, message string) {
 	}
 }
 
+// Checkf adds an error message built from a format string and arguments to the Validator if the provided
+// condition is false.
+func (v *Validator) Checkf(ok bool, key, format string, args ...interface{}) {
+	if !ok {
+		v.AddErrorf(key, format, args...) // Add an error if the condition is not met.
+	}
+}
+
+// CheckCoded adds an error message and code to the Validator if the provided condition is false.
+func (v *Validator) CheckCoded(ok bool, key, code, message string) {
+	if !ok {
+		v.AddCodedError(key, code, message) // Add a coded error if the condition is not met.
+	}
+}
+
+// CheckCodedf adds an error message built from a format string and arguments, along with a code, to the Validator
+// if the provided condition is false.
+func (v *Validator) CheckCodedf(ok bool, key, code, format string, args ...interface{}) {
+	if !ok {
+		v.AddCodedMessages(key, code, Message{ID: format, Args: args}) // Add a coded error if the condition is not met.
+	}
+}
+
 // In checks if a value is in a list of strings.
 // It returns true if the value is found in the list.
 func In(value string, list ...string) bool {
@@ -56,10 +140,10 @@ func Matches(value string, rx *regexp.Regexp) bool {
 	return rx.MatchString(value)
 }
 
-// Unique checks if all values in a slice of strings are unique.
+// Unique checks if all values in a slice, such as strings or IDs, are unique.
 // It returns true if all values are unique.
-func Unique(values []string) bool {
-	uniqueValues := make(map[string]bool)
+func Unique[T comparable](values []T) bool {
+	uniqueValues := make(map[T]bool)
 	for _, value := range values {
 		uniqueValues[value] = true // Add the value to the map if it doesn't already exist.
 	}
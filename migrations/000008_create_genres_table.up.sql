@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS genres (
+    id bigserial PRIMARY KEY,
+    name text UNIQUE NOT NULL
+);
+
+-- Seed the canonical list with the genres already used by existing movies.
+INSERT INTO genres (name)
+SELECT DISTINCT unnest(genres) FROM movies
+ON CONFLICT DO NOTHING;
@@ -0,0 +1 @@
+CREATE UNIQUE INDEX IF NOT EXISTS movies_title_year_unique_idx ON movies (lower(title), year);
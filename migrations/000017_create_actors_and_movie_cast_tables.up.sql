@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS actors (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS movie_cast (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    actor_id bigint NOT NULL REFERENCES actors ON DELETE CASCADE,
+    character_name text NOT NULL DEFAULT '',
+    billing_order integer NOT NULL CHECK (billing_order > 0),
+    PRIMARY KEY (movie_id, actor_id)
+);
+
+CREATE INDEX IF NOT EXISTS movie_cast_actor_id_idx ON movie_cast (actor_id);
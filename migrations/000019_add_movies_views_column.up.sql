@@ -0,0 +1,3 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS views bigint NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS movies_views_idx ON movies (views DESC, id);
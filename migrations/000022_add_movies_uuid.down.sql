@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS movies_uuid_idx;
+
+ALTER TABLE movies DROP COLUMN IF EXISTS uuid;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS movies_release_date_idx;
+
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_release_date_year_check;
+
+ALTER TABLE movies DROP COLUMN IF EXISTS release_date;
@@ -0,0 +1,5 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS release_date date;
+
+ALTER TABLE movies ADD CONSTRAINT movies_release_date_year_check CHECK (release_date IS NULL OR EXTRACT(YEAR FROM release_date) = year);
+
+CREATE INDEX IF NOT EXISTS movies_release_date_idx ON movies (COALESCE(release_date, make_date(year, 1, 1)));
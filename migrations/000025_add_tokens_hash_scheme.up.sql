@@ -0,0 +1 @@
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS hash_scheme text NOT NULL DEFAULT 'sha256';
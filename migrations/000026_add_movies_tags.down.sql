@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS movies_search_idx;
+
+DROP FUNCTION IF EXISTS movie_search_vector(text, text[]);
+
+DROP INDEX IF EXISTS movies_tags_idx;
+
+ALTER TABLE movies DROP COLUMN IF EXISTS tags;
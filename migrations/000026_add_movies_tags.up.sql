@@ -0,0 +1,12 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS tags text[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS movies_tags_idx ON movies USING GIN (tags);
+
+-- The words searched by the q parameter: those of the title, weighted above those of the tags. array_to_string is
+-- only stable, since it depends on how its elements are output, so the function is declared immutable to be
+-- usable in an index; that holds for arrays of text.
+CREATE OR REPLACE FUNCTION movie_search_vector(title text, tags text[]) RETURNS tsvector
+    LANGUAGE sql IMMUTABLE PARALLEL SAFE
+    AS $$ SELECT setweight(to_tsvector('simple', title), 'A') || setweight(to_tsvector('simple', array_to_string(tags, ' ')), 'B') $$;
+
+CREATE INDEX IF NOT EXISTS movies_search_idx ON movies USING GIN (movie_search_vector(title, tags));
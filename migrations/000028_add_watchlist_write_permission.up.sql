@@ -0,0 +1,10 @@
+INSERT INTO permissions (code)
+VALUES ('watchlist:write');
+
+-- Grant the new permission to every user who can read movies, which is everyone registered so far, so that their
+-- watchlists keep working.
+INSERT INTO users_permissions (user_id, permission_id)
+SELECT users_permissions.user_id, (SELECT id FROM permissions WHERE code = 'watchlist:write')
+FROM users_permissions
+INNER JOIN permissions ON permissions.id = users_permissions.permission_id
+WHERE permissions.code = 'movies:read';